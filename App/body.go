@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/cowsed/Pumice/App/data"
+	fs9p "github.com/knusbaum/go9p/fs"
+	"github.com/knusbaum/go9p/proto"
+)
+
+// maxNoteSize bounds how large a body may grow through writes
+var maxNoteSize uint64 = 64 << 20
+
+var errNoteTooLarge = errors.New("note would grow past the size limit")
+
+// bodyFile is the raw markdown of a note. Each fid edits its own copy
+// which is written back to the vault when the fid is clunked
+type bodyFile struct {
+	fs9p.BaseFile
+	vault   *Vault
+	loc     data.VaultLocation
+	buffers map[uint64]*bodyBuffer
+	// called when a write moved the note, as sealing a note marked encrypted does
+	moved func()
+}
+
+type bodyBuffer struct {
+	content []byte
+	dirty   bool
+}
+
+func newBodyFile(filesys *fs9p.FS, vault *Vault, loc data.VaultLocation) *bodyFile {
+	// clients attach as themselves rather than as User, so the owner bits alone would make this read only
+	var perm uint32 = 0666
+	if vault.readOnly {
+		perm = 0444
+	}
+	return &bodyFile{
+		BaseFile: *fs9p.NewBaseFile(filesys.NewStat("body", User, Group, perm)),
+		vault:    vault,
+		loc:      loc,
+		buffers:  map[uint64]*bodyBuffer{},
+	}
+}
+
+func (f *bodyFile) Open(fid uint64, omode proto.Mode) error {
+	if f.vault.readOnly && (omode&0x0F != proto.Oread || omode&proto.Otrunc != 0) {
+		return ErrReadOnly
+	}
+	buf := &bodyBuffer{content: []byte{}}
+	if omode&proto.Otrunc != 0 {
+		buf.dirty = true
+	} else {
+		content, err := f.vault.ReadBody(f.loc)
+		if err != nil {
+			return err
+		}
+		buf.content = content
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.buffers[fid] = buf
+	return nil
+}
+
+func (f *bodyFile) Read(fid uint64, offset uint64, count uint64) ([]byte, error) {
+	f.RLock()
+	defer f.RUnlock()
+	content := f.buffers[fid].content
+	flen := uint64(len(content))
+	if offset >= flen {
+		return []byte{}, nil
+	}
+	if offset+count > flen {
+		count = flen - offset
+	}
+	return content[offset : offset+count], nil
+}
+
+func (f *bodyFile) Write(fid uint64, offset uint64, data []byte) (uint32, error) {
+	f.Lock()
+	defer f.Unlock()
+	buf := f.buffers[fid]
+	// written so offset+len(data) cannot overflow
+	if offset > maxNoteSize || uint64(len(data)) > maxNoteSize-offset {
+		return 0, errNoteTooLarge
+	}
+	end := offset + uint64(len(data))
+	if end > uint64(len(buf.content)) {
+		buf.content = append(buf.content, make([]byte, end-uint64(len(buf.content)))...)
+	}
+	copy(buf.content[offset:end], data)
+	buf.dirty = true
+	return uint32(len(data)), nil
+}
+
+func (f *bodyFile) Close(fid uint64) error {
+	f.Lock()
+	buf := f.buffers[fid]
+	delete(f.buffers, fid)
+	f.Unlock()
+	if buf == nil || !buf.dirty {
+		return nil
+	}
+	// the error goes back in the Rclunk, but clients rarely look at it
+	err := f.vault.WriteBody(f.loc, buf.content)
+	if err != nil {
+		slog.Error("Could not save note body", "path", f.loc, "err", err)
+		f.vault.events.Publish(EventWriteFailed, string(f.loc), err.Error())
+	} else if _, still := f.vault.Note(f.loc); !still && f.moved != nil {
+		f.moved()
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/knusbaum/go9p/proto"
+)
+
+func TestBodyWriteLimits(t *testing.T) {
+	vault, ft := testVault(t, map[string]string{"a.md": "a"})
+	body := newBodyFile(ft.fs, vault, "a.md")
+	if err := body.Open(1, proto.Ordwr); err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []uint64{maxNoteSize, math.MaxUint64 - 1} {
+		n, err := body.Write(1, offset, []byte("xy"))
+		if !errors.Is(err, errNoteTooLarge) || n != 0 {
+			t.Errorf("Expected writing at %d to be refused, wrote %d: %v", offset, n, err)
+		}
+	}
+	if n, err := body.Write(1, 1, []byte("b")); err != nil || n != 1 {
+		t.Fatalf("Expected a small write to work, wrote %d: %v", n, err)
+	}
+	if err := body.Close(1); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := vault.ReadBody("a.md"); string(bs) != "ab" {
+		t.Fatalf("Expected the body to be saved, got %q", bs)
+	}
+}
+
+func TestBodyWriteBackFailure(t *testing.T) {
+	vault, ft := testVault(t, map[string]string{"a.md": "a"})
+	sub := vault.events.subscribe()
+	body := newBodyFile(ft.fs, vault, "a.md")
+	if err := body.Open(1, proto.Owrite|proto.Otrunc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Trash("a.md"); err != nil {
+		t.Fatal(err)
+	}
+	if err := body.Close(1); !errors.Is(err, ErrNoSuchNote) {
+		t.Fatalf("Expected the clunk to fail with %v, got %v", ErrNoSuchNote, err)
+	}
+	// publishing never blocks, so everything is queued by now
+	for len(sub.events) > 0 {
+		ev := <-sub.events
+		if ev.Kind == EventWriteFailed {
+			if ev.Args[0] != "a.md" {
+				t.Fatalf("Expected the failure to name a.md, got %v", ev)
+			}
+			return
+		}
+	}
+	t.Fatal("Expected a write-failed event")
+}
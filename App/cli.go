@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cowsed/Pumice/App/data"
+	"github.com/cowsed/Pumice/App/query"
+	"github.com/cowsed/Pumice/App/vcs"
+)
+
+// command is a subcommand of pumice. run gets the arguments after the name and returns the exit code
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+func commandList() []command {
+	return []command{
+		{"index", "index the vault and write its cache", indexMain},
+		{"search", "list notes containing every word", searchMain},
+		{"query", "list notes matching a query, e.g. tag:recipe AND -draft", queryMain},
+		{"links", "list the notes a note links to", linksMain},
+		{"backlinks", "list the notes linking to a note", backlinksMain},
+		{"tags", "count the tags of the vault or list those of a note", tagsMain},
+		{"serve", "serve the vault over 9P and HTTP", serveMain},
+		{"export", "serve the files of a directory over 9P as they are", exportMain},
+		{"history", "list, diff and restore snapshots of a note", historyMain},
+		{"tangle", "write code blocks out to the files they name", tangleMain},
+		{"lsp", "run a language server on stdin and stdout", lspMain},
+		{"help", "show this message", helpMain},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commandList() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func helpMain(args []string) int {
+	fmt.Fprint(os.Stderr, "usage: pumice <command> [flags] [args]\n\ncommands:\n")
+	for _, cmd := range commandList() {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprint(os.Stderr, "\nRun pumice <command> -h for the flags of a command.\n")
+	return 0
+}
+
+// cliOptions are the flags shared by the commands that read a vault
+type cliOptions struct {
+	vault          string
+	json           bool
+	passphraseFile string
+	verbose        bool
+	// the workspace config of the vault, set by loadConfig
+	config Config
+}
+
+// commandFlags makes the flag set of a command with -vault and -v already defined.
+// usage describes the command and its arguments, the flags are listed after it
+func commandFlags(name, usage string, opts *cliOptions) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&opts.vault, "vault", ".", "directory of the vault")
+	flags.BoolVar(&opts.verbose, "v", false, "log indexing progress to stderr")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// outputFlags adds -json to commandFlags, for commands whose output scripts read
+func outputFlags(name, usage string, opts *cliOptions) *flag.FlagSet {
+	flags := commandFlags(name, usage, opts)
+	flags.BoolVar(&opts.json, "json", false, "print JSON instead of text")
+	return flags
+}
+
+// vaultFlags adds -passphrase-file to outputFlags, for commands that open encrypted notes
+func vaultFlags(name, usage string, opts *cliOptions) *flag.FlagSet {
+	flags := outputFlags(name, usage, opts)
+	flags.StringVar(&opts.passphraseFile, "passphrase-file", "", "file containing the passphrase that unlocks encrypted notes")
+	return flags
+}
+
+// parseCommand parses args and checks the number of arguments left over, printing usage if it is wrong
+func parseCommand(flags *flag.FlagSet, args []string, minArgs, maxArgs int) bool {
+	if err := flags.Parse(args); err != nil {
+		return false
+	}
+	if flags.NArg() < minArgs || (maxArgs >= 0 && flags.NArg() > maxArgs) {
+		flags.Usage()
+		return false
+	}
+	return true
+}
+
+// setupLogging keeps stderr to warnings and errors unless -v is given
+func (o cliOptions) setupLogging() {
+	if o.verbose {
+		return
+	}
+	// log.Println goes through the default slog handler too, at info level
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+}
+
+// loadConfig reads the workspace config of vaultPath. A vault without one gets the defaults
+func (o *cliOptions) loadConfig(vaultPath data.OSPath) {
+	cfg, err := loadWorkspaceConfig(vaultPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("Unable to load workspace config, using default...", "err", err)
+	}
+	o.config = cfg
+}
+
+// openVault indexes the vault the same way serving it does, but never changes a note
+func (o *cliOptions) openVault() (*Vault, error) {
+	o.setupLogging()
+	root, err := filepath.Abs(o.vault)
+	if err != nil {
+		return nil, err
+	}
+	vaultPath := data.OSPath(root)
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	o.loadConfig(vaultPath)
+
+	filesys := vaultFS(vaultPath)
+	keys := unlockVault(Flags{VaultPath: vaultPath, PassphraseFile: o.passphraseFile})
+	mds, err := vaultNotes(filesys, keys)
+	if err != nil {
+		return nil, err
+	}
+	caches := CacheAll(mds, filesys, nil, keys)
+	// history is left alone, but the cache should say who last changed each note just like serving does
+	if vcs.Available() {
+		if repo, err := vcs.Open(root); err == nil {
+			(&changeRecorder{repo: repo}).Annotate(caches)
+		}
+	}
+	vault := NewVault(vaultPath, caches, nil, keys)
+	vault.readOnly = true
+	return vault, nil
+}
+
+// print writes v as JSON with -json, otherwise text writes it for people
+func (o cliOptions) print(v any, text func(w io.Writer)) int {
+	if !o.json {
+		text(os.Stdout)
+		return 0
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(v)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// printPaths prints one note per line, or a JSON list
+func (o cliOptions) printPaths(locs []data.VaultLocation) int {
+	return o.print(locs, func(w io.Writer) {
+		for _, loc := range locs {
+			fmt.Fprintln(w, loc)
+		}
+	})
+}
+
+func notePaths(notes []data.NoteCache) []data.VaultLocation {
+	locs := make([]data.VaultLocation, len(notes))
+	for i, note := range notes {
+		locs[i] = note.Path
+	}
+	return locs
+}
+
+// noteArg finds the note named on the command line, given relative to the vault
+func noteArg(vault *Vault, arg string) (data.NoteCache, error) {
+	loc := data.VaultLocation(strings.TrimPrefix(path.Clean(filepath.ToSlash(arg)), "/"))
+	note, ok := vault.Note(loc)
+	if !ok {
+		return note, fmt.Errorf("%s: %w", loc, ErrNoSuchNote)
+	}
+	return note, nil
+}
+
+const indexUsage = `usage: index [flags]
+
+Indexes every note of the vault, writes the cache used to open it quickly
+and prints what was found.
+`
+
+type indexSummary struct {
+	Notes     int `json:"notes"`
+	Encrypted int `json:"encrypted"`
+	Tags      int `json:"tags"`
+	Links     int `json:"links"`
+	// links that do not resolve to a note of the vault
+	Broken int `json:"broken"`
+}
+
+func indexMain(args []string) int {
+	opts := cliOptions{}
+	flags := vaultFlags("index", indexUsage, &opts)
+	if !parseCommand(flags, args, 0, 0) {
+		return 2
+	}
+	vault, err := opts.openVault()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = vault.SaveCache()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "could not save cache:", err)
+		return 1
+	}
+
+	summary := indexSummary{}
+	tags := data.NewTagSet()
+	for _, note := range vault.Notes() {
+		summary.Notes++
+		if note.Encrypted {
+			summary.Encrypted++
+		}
+		for _, tag := range note.Tags.List() {
+			tags.Add(tag)
+		}
+		summary.Links += len(note.Outlinks)
+		for _, out := range note.Outlinks {
+			if _, ok := vault.Note(out); !ok {
+				summary.Broken++
+			}
+		}
+	}
+	summary.Tags = tags.Len()
+	return opts.print(summary, func(w io.Writer) {
+		fmt.Fprintf(w, "indexed %d notes (%d encrypted), %d tags, %d links (%d broken)\n",
+			summary.Notes, summary.Encrypted, summary.Tags, summary.Links, summary.Broken)
+	})
+}
+
+const searchUsage = `usage: search [flags] word...
+
+Lists the notes whose text contains every word, ignoring case.
+`
+
+func searchMain(args []string) int {
+	opts := cliOptions{}
+	flags := vaultFlags("search", searchUsage, &opts)
+	if !parseCommand(flags, args, 1, -1) {
+		return 2
+	}
+	q := query.And{}
+	for _, word := range flags.Args() {
+		q = append(q, query.Text{Text: word})
+	}
+	vault, err := opts.openVault()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return opts.printPaths(notePaths(vault.Search(q)))
+}
+
+const queryUsage = `usage: query [flags] expression
+
+Lists the notes matching a query, the same queries the query/ directory
+of a served vault takes:
+
+  tag:recipe       notes tagged #recipe or a tag nested under it
+  path:projects/   notes under a folder
+  key:value        notes whose front matter key has the value
+  word "a phrase"  notes containing the text
+
+Terms are joined with AND, OR and NOT (or a leading -) and grouped with
+parentheses. Terms next to each other must all match.
+`
+
+func queryMain(args []string) int {
+	opts := cliOptions{}
+	flags := vaultFlags("query", queryUsage, &opts)
+	if !parseCommand(flags, args, 1, -1) {
+		return 2
+	}
+	q, err := query.Parse(strings.Join(flags.Args(), " "))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	vault, err := opts.openVault()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return opts.printPaths(notePaths(vault.Search(q)))
+}
+
+const linksUsage = `usage: links [flags] note
+
+Lists the notes that a note links to, including links to notes that do not
+exist yet.
+`
+
+func linksMain(args []string) int {
+	opts := cliOptions{}
+	flags := vaultFlags("links", linksUsage, &opts)
+	if !parseCommand(flags, args, 1, 1) {
+		return 2
+	}
+	vault, err := opts.openVault()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	note, err := noteArg(vault, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return opts.printPaths(note.Outlinks)
+}
+
+const backlinksUsage = `usage: backlinks [flags] note
+
+Lists the notes that link to a note.
+`
+
+func backlinksMain(args []string) int {
+	opts := cliOptions{}
+	flags := vaultFlags("backlinks", backlinksUsage, &opts)
+	if !parseCommand(flags, args, 1, 1) {
+		return 2
+	}
+	vault, err := opts.openVault()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	note, err := noteArg(vault, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return opts.printPaths(vault.Inlinks(note.Path))
+}
+
+const tagsUsage = `usage: tags [flags] [note]
+
+Counts how many notes use each tag, most used first. Given a note, lists
+the tags of that note instead.
+`
+
+type tagCount struct {
+	Tag   data.Tag `json:"tag"`
+	Notes int      `json:"notes"`
+}
+
+func tagsMain(args []string) int {
+	opts := cliOptions{}
+	flags := vaultFlags("tags", tagsUsage, &opts)
+	if !parseCommand(flags, args, 0, 1) {
+		return 2
+	}
+	vault, err := opts.openVault()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if flags.NArg() == 1 {
+		note, err := noteArg(vault, flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		tags := note.Tags.StringList()
+		sort.Strings(tags)
+		return opts.print(tags, func(w io.Writer) {
+			for _, tag := range tags {
+				fmt.Fprintln(w, tag)
+			}
+		})
+	}
+
+	counts := map[data.Tag]int{}
+	for _, note := range vault.Notes() {
+		for _, tag := range note.Tags.List() {
+			counts[tag]++
+		}
+	}
+	tags := make([]tagCount, 0, len(counts))
+	for tag, n := range counts {
+		tags = append(tags, tagCount{Tag: tag, Notes: n})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Notes != tags[j].Notes {
+			return tags[i].Notes > tags[j].Notes
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return opts.print(tags, func(w io.Writer) {
+		for _, tc := range tags {
+			fmt.Fprintf(w, "%d\t%s\n", tc.Notes, tc.Tag)
+		}
+	})
+}
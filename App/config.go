@@ -7,6 +7,7 @@ import (
 
 	"fyne.io/fyne/v2"
 	"github.com/cowsed/Pumice/App/data"
+	"github.com/cowsed/Pumice/App/snapshot"
 )
 
 var configFolderName data.VaultLocation = ".config"
@@ -27,7 +28,7 @@ func loadWorkspaceConfig(vault_path data.OSPath) (Config, error) {
 		return default_cfg, err
 	}
 
-	cfg := Config{}
+	cfg := NewConfig()
 
 	err = json.Unmarshal(bs, &cfg)
 	if err != nil {
@@ -42,6 +43,11 @@ func NewConfig() Config {
 		Themes:       []Theme{},
 		CurrentTheme: "builtin",
 		WindowSize:   fyne.NewSize(400, 300),
+		Snapshots:    snapshot.DefaultRetention(),
+		Git: GitConfig{
+			AutoCommit:      false,
+			DebounceSeconds: 30,
+		},
 		// extensions: []Extension{}
 	}
 }
@@ -50,6 +56,16 @@ type Config struct {
 	Themes       []Theme   `json:"theme"`
 	CurrentTheme ThemeID   `json:"current_theme"`
 	WindowSize   fyne.Size `json:"size"`
+
+	Snapshots snapshot.Retention `json:"snapshots"`
+	Git       GitConfig          `json:"git"`
+	// Note that new notes are created from. Empty for blank notes
+	NoteTemplate data.VaultLocation `json:"note_template"`
+}
+
+type GitConfig struct {
+	AutoCommit      bool `json:"auto_commit"`
+	DebounceSeconds int  `json:"debounce_seconds"`
 }
 
 func (c Config) Save(vault_location data.OSPath) error {
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/cowsed/Pumice/App/data"
+	fs9p "github.com/knusbaum/go9p/fs"
+	"github.com/knusbaum/go9p/proto"
+)
+
+const ctlUsage = "reindex [path] | rename old new | retag old new | seal | flush-cache | quit"
+
+// ctlFile runs one command per line written to it. Reading it reports the backend state
+type ctlFile struct {
+	fs9p.BaseFile
+	vault *Vault
+	tree  *FSSTate
+}
+
+func newCtlFile(filesys *fs9p.FS, vault *Vault, tree *FSSTate) *ctlFile {
+	// clients attach as themselves rather than as User, see writableDirPerm
+	var perm uint32 = 0666
+	if vault.readOnly {
+		perm = 0444
+	}
+	return &ctlFile{
+		BaseFile: *fs9p.NewBaseFile(filesys.NewStat("ctl", User, Group, perm)),
+		vault:    vault,
+		tree:     tree,
+	}
+}
+
+func (f *ctlFile) Open(fid uint64, omode proto.Mode) error {
+	if f.vault.readOnly && (omode&0x0F != proto.Oread || omode&proto.Otrunc != 0) {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+func (f *ctlFile) Read(fid uint64, offset uint64, count uint64) ([]byte, error) {
+	state := []byte(f.vault.State().String() + "\n")
+	if offset >= uint64(len(state)) {
+		return []byte{}, nil
+	}
+	return state[offset:min(uint64(len(state)), offset+count)], nil
+}
+
+func (f *ctlFile) Write(fid uint64, offset uint64, bs []byte) (uint32, error) {
+	for _, line := range strings.Split(string(bs), "\n") {
+		err := f.run(strings.Fields(line))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return uint32(len(bs)), nil
+}
+
+func (f *ctlFile) run(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	// every command changes something, even if only what the server is doing
+	if f.vault.readOnly {
+		return ErrReadOnly
+	}
+	slog.Info("ctl", "command", args)
+	switch {
+	case args[0] == "reindex" && len(args) == 1:
+		_, err := f.vault.Reindex()
+		f.tree.sync()
+		return err
+	case args[0] == "reindex" && len(args) == 2:
+		err := f.vault.ReindexNote(data.VaultLocation(args[1]))
+		f.tree.sync()
+		return err
+	case args[0] == "rename" && len(args) == 3:
+		err := f.vault.Rename(data.VaultLocation(args[1]), data.VaultLocation(args[2]))
+		f.tree.sync()
+		return err
+	case args[0] == "retag" && len(args) == 3:
+		_, err := f.vault.Retag(data.Tag(strings.TrimPrefix(args[1], "#")), data.Tag(strings.TrimPrefix(args[2], "#")))
+		return err
+	case args[0] == "seal" && len(args) == 1:
+		_, err := f.vault.Seal()
+		f.tree.sync()
+		return err
+	case args[0] == "flush-cache" && len(args) == 1:
+		return f.vault.SaveCache()
+	case args[0] == "quit" && len(args) == 1:
+		f.vault.Quit()
+		return nil
+	}
+	return fmt.Errorf("bad ctl command %q, want %s", strings.Join(args, " "), ctlUsage)
+}
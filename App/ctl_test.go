@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/knusbaum/go9p/proto"
+)
+
+func TestReadOnlyCtl(t *testing.T) {
+	vault, ft := testVault(t, map[string]string{"a.md": "a"})
+	vault.readOnly = true
+	ctl := newCtlFile(ft.fs, vault, ft)
+
+	if perm := ctl.Stat().Mode & 0777; perm != 0444 {
+		t.Fatalf("Expected ctl to be 0444, got %o", perm)
+	}
+	if err := ctl.Open(1, proto.Owrite); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Expected opening ctl for writing to fail with %v, got %v", ErrReadOnly, err)
+	}
+	if err := ctl.Open(1, proto.Oread); err != nil {
+		t.Fatalf("Expected ctl to stay readable, got %v", err)
+	}
+	for _, cmd := range []string{"quit", "reindex", "reindex a.md", "flush-cache"} {
+		if _, err := ctl.Write(1, 0, []byte(cmd)); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("Expected %q to fail with %v, got %v", cmd, ErrReadOnly, err)
+		}
+	}
+	select {
+	case <-vault.Done():
+		t.Fatal("Expected quit to be refused")
+	default:
+	}
+}
@@ -2,6 +2,7 @@ package data
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/cowsed/Pumice/App/config"
 	"github.com/cowsed/Pumice/App/parser"
@@ -16,10 +17,70 @@ type VaultCache struct {
 }
 
 type NoteCache struct {
-	Path     VaultLocation
-	Tags     TagSet
-	Outlinks []VaultLocation
-	Metadata map[string]MetaDataValue
+	Path VaultLocation
+	Tags TagSet
+	// Wikilink targets as written
+	Links []Link
+	// Links resolved against the rest of the vault
+	Outlinks   []VaultLocation
+	Metadata   map[string]MetaDataValue
+	Callouts   []Callout
+	CodeBlocks []CodeBlock
+	// Last commit that touched this note, if the vault is version controlled
+	LastChanged *Revision
+	// Encrypted notes are only indexed while the vault is unlocked and never written to disk in the clear
+	Encrypted bool
+}
+
+// EncryptedKey is the front matter key that asks for a note to be encrypted
+const EncryptedKey = "encrypted"
+
+// WantsEncryption reports whether front matter marks a note as encrypted
+func WantsEncryption(meta map[string]MetaDataValue) bool {
+	b, ok := meta[EncryptedKey].(bool)
+	return ok && b
+}
+
+// Redacted is the cache as it may be written to disk.
+// Encrypted notes, and notes marked for encryption that are not sealed yet, keep only their path
+func (vc VaultCache) Redacted() VaultCache {
+	out := VaultCache{
+		Version: vc.Version,
+		Notes:   make([]NoteCache, len(vc.Notes)),
+	}
+	for i, note := range vc.Notes {
+		if note.Encrypted || WantsEncryption(note.Metadata) {
+			note = NoteCache{
+				Path:       note.Path,
+				Tags:       NewTagSet(),
+				Links:      []Link{},
+				Outlinks:   []VaultLocation{},
+				Metadata:   map[string]MetaDataValue{},
+				Callouts:   []Callout{},
+				CodeBlocks: []CodeBlock{},
+				Encrypted:  note.Encrypted,
+			}
+		}
+		out.Notes[i] = note
+	}
+	return out
+}
+
+// Revision is a single commit in the history of a note
+type Revision struct {
+	Hash    string
+	Author  string
+	Email   string
+	Time    time.Time
+	Subject string
+}
+
+func (r Revision) String() string {
+	short := r.Hash
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	return fmt.Sprintf("%s %s %s %s", short, r.Time.Format(time.RFC3339), r.Author, r.Subject)
 }
 
 type FullPath struct {
@@ -89,10 +150,13 @@ func MakeNoteCache(path VaultLocation, bytes []byte) (cache NoteCache, doc ast.N
 	}
 
 	cache = NoteCache{
-		Path:     path,
-		Tags:     GetTags(doc),
-		Outlinks: []VaultLocation{},
-		Metadata: meta,
+		Path:       path,
+		Tags:       GetTags(doc),
+		Links:      GetLinks(doc),
+		Outlinks:   []VaultLocation{},
+		Metadata:   meta,
+		Callouts:   GetCallouts(doc, bytes),
+		CodeBlocks: GetCodeBlocks(doc, bytes),
 	}
 
 	// List the tags.
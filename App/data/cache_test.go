@@ -1,6 +1,9 @@
 package data
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -126,3 +129,133 @@ func assertTagsMatch(t *testing.T, expected []Tag, cache NoteCache) {
 		t.Logf("Got %v", cache.Tags)
 	}
 }
+
+func TestRedactedCacheHidesEncryptedNotes(t *testing.T) {
+	secret, _, err := MakeNoteCache("Secret.md.enc", []byte("---\nkey: swordfish\n---\n#private\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Encrypted = true
+	// marked for encryption but not sealed yet
+	marked, _, _ := MakeNoteCache("Marked.md", []byte("---\nencrypted: true\n---\n#unsealed [[Hideout]]\n"))
+	plain, _, _ := MakeNoteCache("Plain.md", []byte("#public\n"))
+
+	bs, err := json.Marshal(VaultCache{Notes: []NoteCache{secret, marked, plain}}.Redacted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{"swordfish", "private", "unsealed", "Hideout"} {
+		if strings.Contains(string(bs), leak) {
+			t.Errorf("Encrypted note leaked %q into cache: %s", leak, bs)
+		}
+	}
+	if !strings.Contains(string(bs), "public") {
+		t.Errorf("Expected plain note tags to be kept: %s", bs)
+	}
+}
+
+func TestCalloutIndex(t *testing.T) {
+	a, _, _ := MakeNoteCache("A.md", []byte("# A\n\n> [!todo] Ship it\n> soon\n\n> [!todo]- Later\n> hidden\n"))
+	b, _, _ := MakeNoteCache("B.md", []byte("> [!warning]\n> careful\n\n> [!TODO]+ Also\n"))
+
+	if len(a.Callouts) != 2 || a.Callouts[0].Line != 3 || a.Callouts[1].Line != 6 {
+		t.Fatalf("Unexpected callouts %v", a.Callouts)
+	}
+
+	vc := VaultCache{Notes: []NoteCache{a, b}}
+	open := vc.FindCallouts("todo", true)
+	if len(open) != 2 {
+		t.Fatalf("Expected 2 open todo callouts, got %v", open)
+	}
+	if open[0].Note != "A.md" || open[0].Title != "Ship it" || open[1].Note != "B.md" || open[1].Title != "Also" {
+		t.Errorf("Unexpected open todos %v", open)
+	}
+	if all := vc.FindCallouts("", false); len(all) != 4 {
+		t.Errorf("Expected every callout, got %v", all)
+	}
+}
+
+func TestCodeBlockIndex(t *testing.T) {
+	src := "# Code\n\n```go file=src/main.go {.numbered title=\"Main file\"}\npackage main\n\nfunc main() {}\n```\n\n```\n```\n\n- item\n  ```sh\n  echo hi\n  ```\n"
+	cache, _, err := MakeNoteCache("Code.md", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := cache.CodeBlocks
+	if len(blocks) != 3 {
+		t.Fatalf("Expected 3 code blocks, got %v", blocks)
+	}
+
+	main := blocks[0]
+	if main.Language != "go" || main.Attrs["file"] != "src/main.go" || main.Attrs["title"] != "Main file" {
+		t.Errorf("Unexpected info parse %q %v", main.Language, main.Attrs)
+	}
+	if _, ok := main.Attrs[".numbered"]; !ok {
+		t.Errorf("Expected bare attribute to be recorded, got %v", main.Attrs)
+	}
+	if main.StartLine != 4 || main.EndLine != 6 {
+		t.Errorf("Expected lines 4-6, got %d-%d", main.StartLine, main.EndLine)
+	}
+	if main.Content != "package main\n\nfunc main() {}\n" {
+		t.Errorf("Unexpected content %q", main.Content)
+	}
+
+	if blocks[1].Language != "" || blocks[1].Content != "" {
+		t.Errorf("Expected empty unlabeled block, got %+v", blocks[1])
+	}
+	if blocks[2].Language != "sh" || blocks[2].Content != "echo hi\n" || blocks[2].StartLine != 14 {
+		t.Errorf("Expected indented list block, got %+v", blocks[2])
+	}
+}
+
+func TestLinkResolution(t *testing.T) {
+	sources := map[VaultLocation]string{
+		"Index.md":          "See [[Ideas]], [[projects/Ideas|the other one]], [[Ideas#Top]] and [[Missing]]. ![[diagram.png]] [[#Local]]",
+		"Ideas.md":          "back to [[index]]",
+		"projects/Ideas.md": "[[Ideas]]",
+	}
+	notes := []NoteCache{}
+	for loc, src := range sources {
+		cache, _, err := MakeNoteCache(loc, []byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		notes = append(notes, cache)
+	}
+	ResolveOutlinks(notes)
+	byPath := map[VaultLocation]NoteCache{}
+	for _, n := range notes {
+		byPath[n.Path] = n
+	}
+
+	want := []VaultLocation{"Ideas.md", "projects/Ideas.md", "Missing.md", "diagram.png"}
+	if fmt.Sprint(byPath["Index.md"].Outlinks) != fmt.Sprint(want) {
+		t.Errorf("Expected outlinks %v, got %v", want, byPath["Index.md"].Outlinks)
+	}
+	if out := byPath["projects/Ideas.md"].Outlinks; len(out) != 1 || out[0] != "projects/Ideas.md" {
+		t.Errorf("Expected a link to resolve to the note in the same folder first, got %v", out)
+	}
+	if out := byPath["Ideas.md"].Outlinks; len(out) != 1 || out[0] != "Index.md" {
+		t.Errorf("Expected case insensitive resolution, got %v", out)
+	}
+
+	in := Inlinks(notes, "Ideas.md")
+	if len(in) != 1 || in[0] != "Index.md" {
+		t.Errorf("Expected Index.md to link to Ideas.md, got %v", in)
+	}
+}
+
+func TestRenameTag(t *testing.T) {
+	src := "---\ntags: [project, \"projects\", project/old]\naliases:\n  - project\n---\n# Notes #project\n\nSee #project/alpha and #projects, not `#project` in code.\n"
+	want := "---\ntags: [work, \"projects\", work/old]\naliases:\n  - project\n---\n# Notes #work\n\nSee #work/alpha and #projects, not `#project` in code.\n"
+	got := string(RenameTag([]byte(src), "project", "work"))
+	if got != want {
+		t.Errorf("Unexpected rename\n%s\nwant\n%s", got, want)
+	}
+
+	block := "---\ntags:\n  - project\n  - other\ntitle: x\n---\nbody\n"
+	got = string(RenameTag([]byte(block), "project", "work"))
+	if got != strings.Replace(block, "- project", "- work", 1) {
+		t.Errorf("Unexpected block list rename\n%s", got)
+	}
+}
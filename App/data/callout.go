@@ -0,0 +1,74 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cowsed/Pumice/App/parser"
+	"github.com/yuin/goldmark/ast"
+)
+
+type Callout struct {
+	Type  string
+	Title string
+	// "" if the callout can't be folded, "+" if it starts expanded, "-" if it starts collapsed
+	Fold string
+	// 1 based line of the `[!type]` marker
+	Line int
+}
+
+// Open reports whether the callout's body is shown by default
+func (c Callout) Open() bool {
+	return c.Fold != parser.FoldClosed.String()
+}
+
+func (c Callout) String() string {
+	return fmt.Sprintf("%d [!%s]%s %s", c.Line, c.Type, c.Fold, c.Title)
+}
+
+func GetCallouts(doc ast.Node, source []byte) []Callout {
+	callouts := []Callout{}
+	ast.Walk(doc, func(node ast.Node, enter bool) (ast.WalkStatus, error) {
+		if n, ok := node.(*parser.Callout); ok && enter {
+			callouts = append(callouts, Callout{
+				Type:  n.CalloutType,
+				Title: n.Title,
+				Fold:  n.Fold.String(),
+				Line:  LineOf(source, n.Marker.Start),
+			})
+		}
+		return ast.WalkContinue, nil
+	})
+	return callouts
+}
+
+// LineOf converts a byte offset into a 1 based line number
+func LineOf(source []byte, offset int) int {
+	if offset > len(source) {
+		offset = len(source)
+	}
+	return bytes.Count(source[:offset], []byte("\n")) + 1
+}
+
+type CalloutRef struct {
+	Note VaultLocation
+	Callout
+}
+
+// FindCallouts lists the callouts of a type across the vault. An empty type matches every callout.
+// If openOnly is set, collapsed callouts are skipped
+func (vc VaultCache) FindCallouts(calloutType string, openOnly bool) []CalloutRef {
+	found := []CalloutRef{}
+	for _, note := range vc.Notes {
+		for _, c := range note.Callouts {
+			if calloutType != "" && c.Type != calloutType {
+				continue
+			}
+			if openOnly && !c.Open() {
+				continue
+			}
+			found = append(found, CalloutRef{Note: note.Path, Callout: c})
+		}
+	}
+	return found
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+type CodeBlock struct {
+	Language string
+	// Info string as written after the opening fence
+	Info  string
+	Attrs map[string]string
+	// 1 based, inclusive range of the block's content lines. The fences are not included.
+	// Empty blocks have EndLine = StartLine-1, and both are 0 if the block has no info string to place it by
+	StartLine int
+	EndLine   int
+	// Kept in memory for tangling but not written into the cache
+	Content string `json:"-"`
+}
+
+func GetCodeBlocks(doc ast.Node, source []byte) []CodeBlock {
+	blocks := []CodeBlock{}
+	ast.Walk(doc, func(node ast.Node, enter bool) (ast.WalkStatus, error) {
+		n, ok := node.(*ast.FencedCodeBlock)
+		if !ok || !enter {
+			return ast.WalkContinue, nil
+		}
+		cb := CodeBlock{Attrs: map[string]string{}}
+		if n.Info != nil {
+			cb.Info = strings.TrimSpace(string(n.Info.Segment.Value(source)))
+			cb.Language, cb.Attrs = ParseInfo(cb.Info)
+		}
+
+		content := strings.Builder{}
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			line := lines.At(i)
+			content.Write(line.Value(source))
+		}
+		cb.Content = content.String()
+
+		if lines.Len() > 0 {
+			first := lines.At(0)
+			cb.StartLine = LineOf(source, first.Start)
+			cb.EndLine = cb.StartLine + lines.Len() - 1
+		} else if n.Info != nil {
+			cb.StartLine = LineOf(source, n.Info.Segment.Start) + 1
+			cb.EndLine = cb.StartLine - 1
+		}
+		blocks = append(blocks, cb)
+		return ast.WalkSkipChildren, nil
+	})
+	return blocks
+}
+
+// ParseInfo splits a fence info string like `go file=main.go {.numbered title="a b"}`
+// into its language and attributes. Attributes without a value map to ""
+func ParseInfo(info string) (string, map[string]string) {
+	attrs := map[string]string{}
+	lang := ""
+	for i, field := range splitInfo(info) {
+		key, value, hasValue := strings.Cut(field, "=")
+		if i == 0 && !hasValue {
+			lang = field
+			continue
+		}
+		attrs[key] = strings.Trim(value, `"'`)
+	}
+	return lang, attrs
+}
+
+// splitInfo breaks an info string on whitespace, keeping quoted values together and dropping braces
+func splitInfo(info string) []string {
+	fields := []string{}
+	cur := strings.Builder{}
+	var quote rune
+	flush := func() {
+		if cur.Len() > 0 {
+			fields = append(fields, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range info {
+		switch {
+		case quote != 0:
+			cur.WriteRune(r)
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			cur.WriteRune(r)
+		case r == '{' || r == '}' || r == ' ' || r == '\t':
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return fields
+}
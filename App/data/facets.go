@@ -0,0 +1,114 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Facet is one front matter key and the notes behind each of its values
+type Facet struct {
+	Key string
+	// kinds of values the key was given: string, number, bool, date, list, map or null
+	Types []string
+	// notes by value. Each element of a list counts as a value of its own
+	Values map[string][]VaultLocation
+	// how many notes set the key
+	Notes int
+}
+
+// Facets collects the front matter keys used by notes, sorted by key
+func Facets(notes []NoteCache) []Facet {
+	byKey := map[string]*Facet{}
+	types := map[string]map[string]struct{}{}
+	for _, note := range notes {
+		for key, value := range note.Metadata {
+			f, ok := byKey[key]
+			if !ok {
+				f = &Facet{Key: key, Values: map[string][]VaultLocation{}}
+				byKey[key] = f
+				types[key] = map[string]struct{}{}
+			}
+			f.Notes++
+			types[key][valueType(value)] = struct{}{}
+			seen := map[string]struct{}{}
+			for _, v := range facetValues(value) {
+				if _, dup := seen[v]; dup {
+					continue
+				}
+				seen[v] = struct{}{}
+				f.Values[v] = append(f.Values[v], note.Path)
+			}
+		}
+	}
+
+	facets := make([]Facet, 0, len(byKey))
+	for key, f := range byKey {
+		for t := range types[key] {
+			f.Types = append(f.Types, t)
+		}
+		sort.Strings(f.Types)
+		for _, locs := range f.Values {
+			sort.Slice(locs, func(i, j int) bool { return locs[i] < locs[j] })
+		}
+		facets = append(facets, *f)
+	}
+	sort.Slice(facets, func(i, j int) bool { return facets[i].Key < facets[j].Key })
+	return facets
+}
+
+// SortedValues lists the values of a facet, most used first
+func (f Facet) SortedValues() []string {
+	values := make([]string, 0, len(f.Values))
+	for v := range f.Values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(f.Values[values[i]]) != len(f.Values[values[j]]) {
+			return len(f.Values[values[i]]) > len(f.Values[values[j]])
+		}
+		return values[i] < values[j]
+	})
+	return values
+}
+
+func valueType(value MetaDataValue) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int64, uint64, float64:
+		return "number"
+	case time.Time:
+		return "date"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}, map[interface{}]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// facetValues are the values a note can be found under. Maps and empty values are not browsable
+func facetValues(value MetaDataValue) []string {
+	switch v := value.(type) {
+	case nil, map[string]interface{}, map[interface{}]interface{}:
+		return nil
+	case []interface{}:
+		values := []string{}
+		for _, elem := range v {
+			values = append(values, facetValues(elem)...)
+		}
+		return values
+	case time.Time:
+		return []string{v.Format(time.DateOnly)}
+	}
+	s := fmt.Sprint(value)
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFacets(t *testing.T) {
+	sources := map[VaultLocation]string{
+		"soup.md":  "---\nrecipe_type: meal\nserves: 4\n---\n",
+		"pie.md":   "---\nrecipe_type: [dessert, meal, meal]\nserves: many\n---\n",
+		"plain.md": "no front matter\n",
+		"todo.md":  "---\ndone: false\nextra: {a: 1}\nempty:\n---\n",
+	}
+	notes := []NoteCache{}
+	for loc, src := range sources {
+		cache, _, err := MakeNoteCache(loc, []byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		notes = append(notes, cache)
+	}
+
+	facets := Facets(notes)
+	keys := []string{}
+	for _, f := range facets {
+		keys = append(keys, f.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"done", "empty", "extra", "recipe_type", "serves"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	recipe := facets[3]
+	if !reflect.DeepEqual(recipe.Types, []string{"list", "string"}) || recipe.Notes != 2 {
+		t.Errorf("unexpected recipe_type facet %+v", recipe)
+	}
+	if !reflect.DeepEqual(recipe.Values["meal"], []VaultLocation{"pie.md", "soup.md"}) {
+		t.Errorf("expected both notes under meal, got %v", recipe.Values["meal"])
+	}
+	if !reflect.DeepEqual(recipe.SortedValues(), []string{"meal", "dessert"}) {
+		t.Errorf("expected most used value first, got %v", recipe.SortedValues())
+	}
+
+	if serves := facets[4]; !reflect.DeepEqual(serves.Types, []string{"number", "string"}) || len(serves.Values) != 2 {
+		t.Errorf("unexpected serves facet %+v", serves)
+	}
+	if len(facets[1].Values) != 0 || len(facets[2].Values) != 0 || facets[1].Types[0] != "null" || facets[2].Types[0] != "map" {
+		t.Errorf("empty and map values should not be browsable: %+v %+v", facets[1], facets[2])
+	}
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+// GetLinks lists the targets of the wikilinks in a note in the order they first appear.
+// Links to a heading of the same note (`[[#heading]]`) are left out
+func GetLinks(doc ast.Node) []Link {
+	links := []Link{}
+	seen := map[Link]struct{}{}
+	ast.Walk(doc, func(node ast.Node, enter bool) (ast.WalkStatus, error) {
+		n, ok := node.(*wikilink.Node)
+		if !ok || !enter || len(n.Target) == 0 {
+			return ast.WalkContinue, nil
+		}
+		link := Link(n.Target)
+		if _, dup := seen[link]; !dup {
+			seen[link] = struct{}{}
+			links = append(links, link)
+		}
+		return ast.WalkContinue, nil
+	})
+	return links
+}
+
+// LinkResolver finds the note a wikilink points to the way Obsidian does:
+// by path if the link has one, otherwise by note name
+type LinkResolver struct {
+	byPath map[string]VaultLocation
+	byName map[string][]VaultLocation
+}
+
+func linkKey(s string) string {
+	return strings.ToLower(strings.TrimSuffix(s, ".md"))
+}
+
+func NewLinkResolver(notes []VaultLocation) LinkResolver {
+	lr := LinkResolver{
+		byPath: map[string]VaultLocation{},
+		byName: map[string][]VaultLocation{},
+	}
+	for _, loc := range notes {
+		lr.byPath[linkKey(string(loc))] = loc
+		name := linkKey(string(loc.Name()))
+		lr.byName[name] = append(lr.byName[name], loc)
+	}
+	for _, locs := range lr.byName {
+		sort.Slice(locs, func(i, j int) bool {
+			if len(locs[i]) != len(locs[j]) {
+				return len(locs[i]) < len(locs[j])
+			}
+			return locs[i] < locs[j]
+		})
+	}
+	return lr
+}
+
+// Resolve finds the note link points to from the note at from.
+// Links to notes that do not exist resolve to where Obsidian would create them, relative to the vault root
+func (lr LinkResolver) Resolve(from VaultLocation, link Link) VaultLocation {
+	target := strings.TrimPrefix(path.Clean(string(link)), "/")
+	if strings.Contains(target, "/") {
+		if loc, ok := lr.byPath[linkKey(target)]; ok {
+			return loc
+		}
+	} else {
+		candidates := lr.byName[linkKey(target)]
+		for _, loc := range candidates {
+			if loc.Dir() == from.Dir() {
+				return loc
+			}
+		}
+		if len(candidates) > 0 {
+			return candidates[0]
+		}
+	}
+	if path.Ext(target) == "" {
+		target += ".md"
+	}
+	return VaultLocation(target)
+}
+
+// ResolveOutlinks fills in the Outlinks of every note from its Links
+func ResolveOutlinks(notes []NoteCache) {
+	locs := make([]VaultLocation, len(notes))
+	for i, note := range notes {
+		locs[i] = note.Path
+	}
+	lr := NewLinkResolver(locs)
+	for i, note := range notes {
+		outlinks := []VaultLocation{}
+		seen := map[VaultLocation]struct{}{}
+		for _, link := range note.Links {
+			loc := lr.Resolve(note.Path, link)
+			if _, dup := seen[loc]; !dup {
+				seen[loc] = struct{}{}
+				outlinks = append(outlinks, loc)
+			}
+		}
+		notes[i].Outlinks = outlinks
+	}
+}
+
+// Inlinks lists the notes that link to loc
+func Inlinks(notes []NoteCache, loc VaultLocation) []VaultLocation {
+	inlinks := []VaultLocation{}
+	for _, note := range notes {
+		for _, out := range note.Outlinks {
+			if out == loc {
+				inlinks = append(inlinks, note.Path)
+				break
+			}
+		}
+	}
+	return inlinks
+}
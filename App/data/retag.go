@@ -0,0 +1,107 @@
+package data
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cowsed/Pumice/App/parser"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+	"go.abhg.dev/goldmark/hashtag"
+)
+
+// RenameTag rewrites every use of tag old in a note to new, both `#old` in the text and in the
+// front matter tags list. Nested tags move along: `#old/child` becomes `#new/child`
+func RenameTag(source []byte, old, new Tag) []byte {
+	type edit struct{ start, stop int }
+	edits := []edit{}
+
+	doc := parser.VaultParser().Parse(text.NewReader(source))
+	ast.Walk(doc, func(node ast.Node, enter bool) (ast.WalkStatus, error) {
+		n, ok := node.(*hashtag.Node)
+		if !ok || !enter || !renames(Tag(n.Tag), old) {
+			return ast.WalkContinue, nil
+		}
+		if t, ok := n.FirstChild().(*ast.Text); ok {
+			// skip the '#'
+			start := t.Segment.Start + 1
+			edits = append(edits, edit{start, start + len(old)})
+		}
+		return ast.WalkContinue, nil
+	})
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	out := append([]byte{}, source...)
+	for _, e := range edits {
+		out = append(out[:e.start], append([]byte(new), out[e.stop:]...)...)
+	}
+	return renameFrontMatterTag(out, old, new)
+}
+
+func renames(tag, old Tag) bool {
+	return tag == old || strings.HasPrefix(string(tag), string(old)+"/")
+}
+
+var tagsKey = regexp.MustCompile(`^tags:\s*(.*)$`)
+var listItem = regexp.MustCompile(`^(\s*-\s+)(.*?)(\s*)$`)
+
+// renameFrontMatterTag handles `tags: [a, b]`, `tags: a` and block lists under `tags:`
+func renameFrontMatterTag(source []byte, old, new Tag) []byte {
+	lines := bytes.SplitAfter(source, []byte("\n"))
+	if len(lines) == 0 || strings.TrimSpace(string(lines[0])) != "---" {
+		return source
+	}
+	inTags := false
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimRight(string(lines[i]), "\r\n")
+		ending := string(lines[i])[len(line):]
+		if line == "---" || line == "..." {
+			break
+		}
+		if m := tagsKey.FindStringSubmatch(line); m != nil {
+			inTags = m[1] == ""
+			if !inTags {
+				lines[i] = []byte("tags: " + renameInlineTags(m[1], old, new) + ending)
+			}
+			continue
+		}
+		if !inTags {
+			continue
+		}
+		if m := listItem.FindStringSubmatch(line); m != nil {
+			lines[i] = []byte(m[1] + renameInlineTags(m[2], old, new) + m[3] + ending)
+			continue
+		}
+		inTags = strings.HasPrefix(line, " ") || line == ""
+	}
+	return bytes.Join(lines, nil)
+}
+
+// renameInlineTags renames old in a yaml scalar or flow list like `[a, "b", old/c]`
+func renameInlineTags(value string, old, new Tag) string {
+	open, close := "", ""
+	inner := value
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		open, close = "[", "]"
+		inner = value[1 : len(value)-1]
+	}
+	items := strings.Split(inner, ",")
+	for i, item := range items {
+		trimmed := strings.TrimSpace(item)
+		quote := ""
+		if len(trimmed) >= 2 && (trimmed[0] == '"' || trimmed[0] == '\'') && trimmed[len(trimmed)-1] == trimmed[0] {
+			quote = trimmed[:1]
+			trimmed = trimmed[1 : len(trimmed)-1]
+		}
+		if !renames(Tag(trimmed), old) {
+			continue
+		}
+		renamed := string(new) + strings.TrimPrefix(trimmed, string(old))
+		lead := item[:len(item)-len(strings.TrimLeft(item, " \t"))]
+		trail := item[len(strings.TrimRight(item, " \t")):]
+		items[i] = lead + quote + renamed + quote + trail
+	}
+	return open + strings.Join(items, ",") + close
+}
@@ -1,8 +1,12 @@
 package data
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"path"
+	"path/filepath"
+	"sort"
 )
 
 type OSPath string
@@ -25,6 +29,29 @@ func (op OSPath) String() string {
 	return string(op)
 }
 
+// WriteAtomic replaces path with content so readers never see a partially written file
+func WriteAtomic(path string, content []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 func (vl VaultLocation) Append(file string) VaultLocation {
 	return VaultLocation(path.Join(string(vl), file))
 }
@@ -67,3 +94,22 @@ func NewTagSet() TagSet {
 func (ts *TagSet) Add(tag Tag) {
 	ts.internal[tag] = struct{}{}
 }
+
+func (ts TagSet) MarshalJSON() ([]byte, error) {
+	l := ts.StringList()
+	sort.Strings(l)
+	return json.Marshal(l)
+}
+
+func (ts *TagSet) UnmarshalJSON(bs []byte) error {
+	l := []string{}
+	err := json.Unmarshal(bs, &l)
+	if err != nil {
+		return err
+	}
+	*ts = NewTagSet()
+	for _, t := range l {
+		ts.Add(Tag(t))
+	}
+	return nil
+}
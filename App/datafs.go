@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cowsed/Pumice/App/data"
+	fs9p "github.com/knusbaum/go9p/fs"
+)
+
+// clients attach as themselves rather than as User, so the data tree has to be writable by others
+const writableDirPerm = 0777
+
+func dataDirPerm(vault *Vault) uint32 {
+	if vault.readOnly {
+		return 0555
+	}
+	return writableDirPerm
+}
+
+var dataDirName = "data"
+
+var errBadName = errors.New("names cannot be empty, . or .., or contain /")
+
+// FSSTate tracks the folders and notes of the data/ tree so clients can create and remove notes
+type FSSTate struct {
+	fs        *fs9p.FS
+	vault     *Vault
+	template  data.VaultLocation
+	cachedirs map[data.VaultLocation]*fs9p.StaticDir
+	notedirs  map[data.VaultLocation]*noteDir
+	dataRoot  *fs9p.StaticDir
+	sync.Mutex
+}
+
+func (ft *FSSTate) makeDataDir() fs9p.Dir {
+	ft.dataRoot = fs9p.NewStaticDir(ft.fs.NewStat(dataDirName, User, Group, dataDirPerm(ft.vault)))
+	for _, cache := range ft.vault.Notes() {
+		ft.addNote(cache.Path)
+	}
+	return ft.dataRoot
+}
+
+// GetOrMakeDir finds the folder at path, making it and its parents if needed. The caller must hold the lock
+func (ft *FSSTate) GetOrMakeDir(path data.VaultLocation) *fs9p.StaticDir {
+	if path == "." {
+		return ft.dataRoot
+	}
+	if dir, exists := ft.cachedirs[path]; exists {
+		return dir
+	}
+	name := path.Name()
+	parentDir := path.Dir()
+	parent := ft.GetOrMakeDir(parentDir)
+	me := fs9p.NewStaticDir(ft.fs.NewStat(string(name), User, Group, dataDirPerm(ft.vault)))
+	parent.AddChild(me)
+	ft.cachedirs[path] = me
+	return me
+}
+
+// addNote puts the directory for a note into the tree. The caller must hold the lock
+func (ft *FSSTate) addNote(loc data.VaultLocation) *noteDir {
+	noteDir := makeDirFromCache(loc, ft.vault, ft.fs, ft.sync)
+	ft.GetOrMakeDir(loc.Dir()).AddChild(noteDir)
+	ft.notedirs[loc] = noteDir
+	return noteDir
+}
+
+// sync brings the tree in line with the vault after notes were added or removed behind its back
+func (ft *FSSTate) sync() {
+	ft.Lock()
+	defer ft.Unlock()
+	indexed := map[data.VaultLocation]struct{}{}
+	for _, note := range ft.vault.Notes() {
+		indexed[note.Path] = struct{}{}
+		if _, ok := ft.notedirs[note.Path]; !ok {
+			ft.addNote(note.Path)
+		}
+	}
+	for loc, dir := range ft.notedirs {
+		if _, ok := indexed[loc]; !ok {
+			fs9p.RMFile(ft.fs, dir)
+			delete(ft.notedirs, loc)
+		}
+	}
+}
+
+// locationOf maps a node of the data tree back to the vault
+func (ft *FSSTate) locationOf(node fs9p.FSNode) (data.VaultLocation, bool) {
+	full := fs9p.FullPath(node)
+	prefix := "/" + dataDirName
+	if full == prefix {
+		return ".", true
+	}
+	if !strings.HasPrefix(full, prefix+"/") {
+		return "", false
+	}
+	return data.VaultLocation(strings.TrimPrefix(full, prefix+"/")), true
+}
+
+// folderOf finds the vault folder a directory of the data tree stands for
+func (ft *FSSTate) folderOf(dir fs9p.Dir) (data.VaultLocation, error) {
+	loc, ok := ft.locationOf(dir)
+	if ok && loc == "." {
+		return loc, nil
+	}
+	if _, isFolder := ft.cachedirs[loc]; !ok || !isFolder {
+		return "", fmt.Errorf("%s: notes can only be created in folders of %s/", fs9p.FullPath(dir), dataDirName)
+	}
+	return loc, nil
+}
+
+// childOf is where name in folder lives, as long as name stays inside folder
+func childOf(folder data.VaultLocation, name string) (data.VaultLocation, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", fmt.Errorf("%q: %w", name, errBadName)
+	}
+	return folder.Append(name), nil
+}
+
+// createNote makes a new note from the template, on disk and in the tree. The caller must hold the lock
+func (ft *FSSTate) createNote(parent fs9p.Dir, name string) (*noteDir, error) {
+	folder, err := ft.folderOf(parent)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := childOf(folder, name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := noteFromTemplate(ft.vault.path, ft.template, loc, time.Now())
+	if err != nil {
+		slog.Warn("Could not read note template, creating an empty note", "template", ft.template, "err", err)
+		content = []byte{}
+	}
+	cache, err := ft.vault.Create(loc, content)
+	if err != nil {
+		return nil, err
+	}
+	return ft.addNote(cache.Path), nil
+}
+
+// createFile handles Tcreate of `name.md` in a folder. The fid ends up on the body of the new note
+func (ft *FSSTate) createFile(filesys *fs9p.FS, parent fs9p.Dir, user, name string, perm uint32, mode uint8) (fs9p.File, error) {
+	ft.Lock()
+	defer ft.Unlock()
+	if path.Ext(name) != ".md" {
+		return nil, fmt.Errorf("%s: only .md notes can be created", name)
+	}
+	noteDir, err := ft.createNote(parent, name)
+	if err != nil {
+		return nil, err
+	}
+	return noteDir.Children()["body"].(fs9p.File), nil
+}
+
+// createDir handles Tcreate of a directory. `name.md` makes a note, anything else a folder
+func (ft *FSSTate) createDir(filesys *fs9p.FS, parent fs9p.Dir, user, name string, perm uint32, mode uint8) (fs9p.Dir, error) {
+	ft.Lock()
+	defer ft.Unlock()
+	if path.Ext(name) == ".md" {
+		noteDir, err := ft.createNote(parent, name)
+		if err != nil {
+			return nil, err
+		}
+		return noteDir, nil
+	}
+	folder, err := ft.folderOf(parent)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := childOf(folder, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := ft.cachedirs[loc]; exists {
+		return nil, fmt.Errorf("%s: %w", loc, fs.ErrExist)
+	}
+	err = os.Mkdir(data.ToOSPath(ft.vault.path, loc), 0777)
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, err
+	}
+	return ft.GetOrMakeDir(loc), nil
+}
+
+// remove handles Tremove. Notes go to the trash, folders must be empty
+func (ft *FSSTate) remove(filesys *fs9p.FS, node fs9p.FSNode) error {
+	ft.Lock()
+	defer ft.Unlock()
+	loc, ok := ft.locationOf(node)
+	if !ok || loc == "." {
+		return fmt.Errorf("%s cannot be removed", fs9p.FullPath(node))
+	}
+
+	if _, isNote := ft.notedirs[loc]; isNote {
+		trashed, err := ft.vault.Trash(loc)
+		if err != nil {
+			return err
+		}
+		slog.Info("Moved note to trash", "path", loc, "to", trashed)
+		delete(ft.notedirs, loc)
+		return fs9p.RMFile(filesys, node)
+	}
+
+	dir, isFolder := ft.cachedirs[loc]
+	if !isFolder {
+		return fmt.Errorf("%s cannot be removed", fs9p.FullPath(node))
+	}
+	if len(dir.Children()) > 0 {
+		return fmt.Errorf("%s: folder is not empty", loc)
+	}
+	err := os.Remove(data.ToOSPath(ft.vault.path, loc))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	delete(ft.cachedirs, loc)
+	return fs9p.RMFile(filesys, node)
+}
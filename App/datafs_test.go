@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cowsed/Pumice/App/data"
+	fs9p "github.com/knusbaum/go9p/fs"
+)
+
+// testVault makes a writable vault holding files and the data tree serving it
+func testVault(t *testing.T, files map[string]string) (*Vault, *FSSTate) {
+	t.Helper()
+	dir := t.TempDir()
+	caches := []data.NoteCache{}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cache, _, err := data.MakeNoteCache(data.VaultLocation(name), []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		caches = append(caches, cache)
+	}
+	vault := NewVault(data.OSPath(dir), caches, nil, nil)
+	vfs, root := fs9p.NewFS(User, Group, 0755)
+	ft := &FSSTate{
+		fs:        vfs,
+		vault:     vault,
+		cachedirs: map[data.VaultLocation]*fs9p.StaticDir{},
+		notedirs:  map[data.VaultLocation]*noteDir{},
+	}
+	root.AddChild(ft.makeDataDir())
+	return vault, ft
+}
+
+func TestCreateRejectsBadNames(t *testing.T) {
+	vault, ft := testVault(t, map[string]string{"folder/a.md": "a"})
+	folder := ft.cachedirs["folder"]
+
+	for _, name := range []string{"", ".", "..", "../escaped.md", "a/b.md", "../escaped"} {
+		if _, err := ft.createFile(ft.fs, folder, User, name, 0644, 0); err == nil {
+			t.Errorf("Expected creating the file %q to fail", name)
+		}
+		if _, err := ft.createDir(ft.fs, folder, User, name, 0755, 0); !errors.Is(err, errBadName) {
+			t.Errorf("Expected creating the directory %q to fail with %v, got %v", name, errBadName, err)
+		}
+	}
+	entries, err := os.ReadDir(string(vault.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "folder" {
+		t.Fatalf("Expected only folder/ in the vault, got %v", entries)
+	}
+
+	if _, err := ft.createFile(ft.fs, folder, User, "b.md", 0644, 0); err != nil {
+		t.Fatalf("Expected a plain name to be created, got %v", err)
+	}
+	if _, err := ft.createDir(ft.fs, folder, User, "sub", 0755, 0); err != nil {
+		t.Fatalf("Expected a plain folder to be created, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(string(vault.path), "folder", "sub")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/cowsed/Pumice/App/data"
+	"github.com/cowsed/Pumice/App/vaultcrypt"
+)
+
+var passphraseEnv = "PUMICE_PASSPHRASE"
+
+// unlockVault builds the keyring for encrypted notes.
+// Without a passphrase the keyring stays locked and encrypted notes are not indexed
+func unlockVault(flags Flags) *vaultcrypt.Keyring {
+	keys := vaultcrypt.NewKeyring()
+	passphrase := os.Getenv(passphraseEnv)
+	if flags.PassphraseFile != "" {
+		bs, err := os.ReadFile(flags.PassphraseFile)
+		if err != nil {
+			slog.Error("Could not read passphrase file, encrypted notes stay locked", "err", err)
+			return keys
+		}
+		passphrase = strings.TrimRight(string(bs), "\r\n")
+	}
+	if passphrase == "" {
+		return keys
+	}
+	sealed, err := anySealedNote(vaultFS(flags.VaultPath))
+	if err != nil {
+		slog.Error("Could not read an encrypted note to check the passphrase, encrypted notes stay locked", "err", err)
+		return keys
+	}
+	err = keys.Unlock(passphrase, sealed)
+	if err != nil {
+		slog.Error("Could not unlock vault", "err", err)
+	}
+	return keys
+}
+
+// anySealedNote reads one encrypted note of the vault, nil if there are none
+func anySealedNote(filesys fs.FS) ([]byte, error) {
+	encs, err := encryptedNotes(filesys)
+	if err != nil || len(encs) == 0 {
+		return nil, err
+	}
+	return readAll(filesys, encs[0])
+}
+
+// vaultNotes lists the notes to index: plaintext notes, plus encrypted notes while the vault is unlocked.
+// Notes marked for encryption are left out until they are sealed, see Vault.Seal, so their
+// content never ends up in the index or the cache
+func vaultNotes(filesys fs.FS, keys *vaultcrypt.Keyring) ([]string, error) {
+	mds, err := allFilesOfType(filesys, ".md")
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]string, 0, len(mds))
+	for _, p := range mds {
+		if _, marked := markedForEncryption(filesys, p); marked {
+			slog.Warn("Note is marked encrypted but not sealed yet, not indexing it. Unlock the vault to seal it", "path", p)
+			continue
+		}
+		plain = append(plain, p)
+	}
+	if keys.Unlocked() {
+		encs, err := encryptedNotes(filesys)
+		if err != nil {
+			return nil, err
+		}
+		plain = append(plain, encs...)
+	}
+	return plain, nil
+}
+
+// encryptedNotes lists the .md.enc notes in the vault
+func encryptedNotes(filesys fs.FS) ([]string, error) {
+	all, err := allFilesOfType(filesys, ".enc")
+	if err != nil {
+		return nil, err
+	}
+	encs := []string{}
+	for _, p := range all {
+		if vaultcrypt.IsEncryptedPath(p) {
+			encs = append(encs, p)
+		}
+	}
+	return encs, nil
+}
+
+// markedForEncryption reads the plaintext note p and says whether its front matter asks for encryption
+func markedForEncryption(filesys fs.FS, p string) ([]byte, bool) {
+	bs, err := readAll(filesys, p)
+	if err != nil {
+		return bs, false
+	}
+	return bs, marked(data.VaultLocation(p), bs)
+}
+
+// marked says whether content, stored in the clear at loc, asks to be encrypted
+func marked(loc data.VaultLocation, content []byte) bool {
+	if vaultcrypt.IsEncryptedPath(string(loc)) || !bytes.Contains(content, []byte(vaultcrypt.MetadataKey)) {
+		return false
+	}
+	cache, _, err := data.MakeNoteCache(loc, content)
+	return err == nil && vaultcrypt.WantsEncryption(cache.Metadata)
+}
+
+// Seal encrypts every note whose front matter asks for it and returns where they ended up.
+// Notes are also sealed as they are written, this catches the ones edited behind the vault's back.
+// A sealed note moves to its .md.enc counterpart and a renamed event says so, but links
+// naming the old path are not rewritten and its snapshots are dropped rather than moved.
+// Plaintext that was already committed to git stays in the history of the repository
+func (v *Vault) Seal() ([]data.VaultLocation, error) {
+	if v.readOnly {
+		return nil, ErrReadOnly
+	}
+	if !v.keys.Unlocked() {
+		return nil, vaultcrypt.ErrLocked
+	}
+	filesys := vaultFS(v.path)
+	mds, err := allFilesOfType(filesys, ".md")
+	if err != nil {
+		return nil, err
+	}
+
+	v.Lock()
+	defer v.Unlock()
+	defer v.relink()
+	sealed := []data.VaultLocation{}
+	for _, p := range mds {
+		plain, marked := markedForEncryption(filesys, p)
+		if !marked {
+			continue
+		}
+		loc := data.VaultLocation(p)
+		cache, _, err := data.MakeNoteCache(loc, plain)
+		if err != nil {
+			return sealed, err
+		}
+		sealedLoc, raw, err := sealNote(v.path, loc, plain, v.keys)
+		if err != nil {
+			return sealed, fmt.Errorf("%s: %w", loc, err)
+		}
+		v.indexSealed(loc, sealedLoc, cache, raw)
+		sealed = append(sealed, sealedLoc)
+	}
+	return sealed, nil
+}
+
+// reindexMarked takes in a plaintext note found marked for encryption. It is sealed if the vault
+// can, and otherwise left out of the index as vaultNotes does. The caller must hold the lock
+func (v *Vault) reindexMarked(loc data.VaultLocation, plain []byte, existed bool) error {
+	if v.readOnly || !v.keys.Unlocked() {
+		slog.Warn("Note is marked encrypted but not sealed yet, not indexing it", "path", loc)
+		if existed {
+			delete(v.notes, loc)
+			delete(v.sums, loc)
+			v.relink()
+			v.events.Publish(EventRemoved, string(loc))
+		}
+		return nil
+	}
+	cache, _, err := data.MakeNoteCache(loc, plain)
+	if err != nil {
+		return err
+	}
+	sealedLoc, raw, err := sealNote(v.path, loc, plain, v.keys)
+	if err != nil {
+		return fmt.Errorf("%s: %w", loc, err)
+	}
+	v.indexSealed(loc, sealedLoc, cache, raw)
+	v.relink()
+	return nil
+}
+
+// indexSealed moves the note at loc, which was just sealed to sealedLoc, in the index.
+// The caller must hold the lock and relink afterwards
+func (v *Vault) indexSealed(loc, sealedLoc data.VaultLocation, cache data.NoteCache, raw []byte) {
+	slog.Info("Encrypted note", "path", loc, "to", sealedLoc)
+	v.changes.Forget(loc)
+	v.changes.Removed(loc)
+	v.changes.Record(sealedLoc, raw)
+
+	cache.Path = sealedLoc
+	cache.Encrypted = true
+	delete(v.notes, loc)
+	delete(v.sums, loc)
+	v.notes[sealedLoc] = cache
+	v.versions[sealedLoc]++
+	v.sums[sealedLoc] = sumOf(raw)
+	v.events.Publish(EventRenamed, string(loc), string(sealedLoc))
+}
+
+// sealNote replaces a plaintext note with its encrypted counterpart and returns where it is and what was written
+func sealNote(vaultPath data.OSPath, loc data.VaultLocation, plain []byte, keys *vaultcrypt.Keyring) (data.VaultLocation, []byte, error) {
+	sealedLoc, sealed, err := writeSealed(vaultPath, loc, plain, keys)
+	if err != nil {
+		return "", nil, err
+	}
+	return sealedLoc, sealed, os.Remove(data.ToOSPath(vaultPath, loc))
+}
+
+// writeSealed encrypts plain into the .md.enc counterpart of loc, which must not exist yet
+func writeSealed(vaultPath data.OSPath, loc data.VaultLocation, plain []byte, keys *vaultcrypt.Keyring) (data.VaultLocation, []byte, error) {
+	sealed, err := keys.Encrypt(plain)
+	if err != nil {
+		return "", nil, err
+	}
+	sealedLoc := data.VaultLocation(strings.TrimSuffix(string(loc), ".md") + vaultcrypt.Extension)
+	f, err := os.OpenFile(data.ToOSPath(vaultPath, sealedLoc), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = f.Write(sealed)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(data.ToOSPath(vaultPath, sealedLoc))
+		return "", nil, err
+	}
+	return sealedLoc, sealed, nil
+}
+
+func readAll(filesys fs.FS, p string) ([]byte, error) {
+	fil, err := filesys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer fil.Close()
+	return io.ReadAll(fil)
+}
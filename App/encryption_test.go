@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cowsed/Pumice/App/vaultcrypt"
+	fs9p "github.com/knusbaum/go9p/fs"
+	"github.com/knusbaum/go9p/proto"
+)
+
+var markedNote = "---\nencrypted: true\n---\nsecret #private\n"
+
+// unlock gives the vault a keyring unlocked with a passphrase no note was sealed under yet
+func unlock(t *testing.T, vault *Vault) {
+	t.Helper()
+	vault.keys = vaultcrypt.NewKeyring()
+	if err := vault.keys.Unlock("passphrase", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReindexSealsMarkedNotes(t *testing.T) {
+	vault, _ := testVault(t, map[string]string{"plain.md": "plain"})
+	if err := os.WriteFile(filepath.Join(string(vault.path), "secret.md"), []byte(markedNote), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unlock(t, vault)
+
+	sub := vault.events.subscribe()
+	if _, err := vault.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(string(vault.path), "secret.md")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the plaintext to be gone, got %v", err)
+	}
+	note, ok := vault.Note("secret.md.enc")
+	if !ok || !note.Encrypted || !note.Tags.Contains("private") {
+		t.Fatalf("Expected the sealed note in the index, got %v", note)
+	}
+	renamed := false
+	for _, ev := range drain(sub) {
+		renamed = renamed || ev.Kind == EventRenamed && ev.Args[0] == "secret.md" && ev.Args[1] == "secret.md.enc"
+	}
+	if !renamed {
+		t.Fatal("Expected a renamed event for the sealed note")
+	}
+	if body, err := vault.ReadBody("secret.md.enc"); err != nil || string(body) != markedNote {
+		t.Fatalf("Expected the sealed body to decrypt, got %q %v", body, err)
+	}
+}
+
+func TestWritingMarksSeals(t *testing.T) {
+	vault, ft := testVault(t, map[string]string{"plain.md": "plain"})
+	unlock(t, vault)
+
+	body := ft.notedirs["plain.md"].Children()["body"].(fs9p.File)
+	if err := body.Open(1, proto.Owrite|proto.Otrunc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := body.Write(1, 0, []byte(markedNote)); err != nil {
+		t.Fatal(err)
+	}
+	if err := body.Close(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vault.Note("plain.md.enc"); !ok {
+		t.Fatal("Expected the written note to be sealed")
+	}
+	if _, ok := ft.notedirs["plain.md.enc"]; !ok {
+		t.Fatal("Expected the data tree to follow the sealed note")
+	}
+	cache, err := vault.Create("new.md", []byte(markedNote))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Path != "new.md.enc" || !cache.Encrypted {
+		t.Fatalf("Expected the new note to be created sealed, got %v", cache)
+	}
+	for _, name := range []string{"plain.md", "new.md"} {
+		if _, err := os.Stat(filepath.Join(string(vault.path), name)); !os.IsNotExist(err) {
+			t.Errorf("Expected no plaintext %s, got %v", name, err)
+		}
+	}
+}
+
+func TestLockedVaultKeepsMarkedNotesOffDisk(t *testing.T) {
+	vault, _ := testVault(t, map[string]string{"plain.md": "plain"})
+	onDisk := filepath.Join(string(vault.path), "plain.md")
+
+	if err := vault.WriteBody("plain.md", []byte(markedNote)); !errors.Is(err, vaultcrypt.ErrLocked) {
+		t.Fatalf("Expected writing a marked note while locked to fail with %v, got %v", vaultcrypt.ErrLocked, err)
+	}
+	if bs, _ := os.ReadFile(onDisk); string(bs) != "plain" {
+		t.Fatalf("Expected the note to be left as it was, got %q", bs)
+	}
+	if _, err := vault.Create("new.md", []byte(markedNote)); !errors.Is(err, vaultcrypt.ErrLocked) {
+		t.Fatalf("Expected creating a marked note while locked to fail with %v, got %v", vaultcrypt.ErrLocked, err)
+	}
+
+	// edited behind the vault's back, then reindexed and the cache flushed
+	if err := os.WriteFile(onDisk, []byte(markedNote), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.ReindexNote("plain.md"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vault.Note("plain.md"); ok {
+		t.Fatal("Expected the marked note to leave the index")
+	}
+	if err := vault.SaveCache(); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := os.ReadFile(filepath.Join(string(vault.path), ".cache", "data.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(cache), "private") {
+		t.Fatalf("Expected nothing of the marked note in the cache, got %s", cache)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cowsed/Pumice/App/data"
+	fs9p "github.com/knusbaum/go9p/fs"
+	"github.com/knusbaum/go9p/proto"
+)
+
+type EventKind string
+
+const (
+	EventModified        EventKind = "modified"
+	EventCreated         EventKind = "created"
+	EventRemoved         EventKind = "removed"
+	EventRenamed         EventKind = "renamed"
+	EventTagAdded        EventKind = "tag-added"
+	EventTagRemoved      EventKind = "tag-removed"
+	EventReindexComplete EventKind = "reindex-complete"
+	// a body written through the file system could not be saved, the edit is lost
+	EventWriteFailed EventKind = "write-failed"
+)
+
+// Event is one line of the events file, its fields separated by tabs, e.g. `renamed\told.md\tnew.md`
+type Event struct {
+	Kind EventKind
+	Args []string
+}
+
+// a path can hold a tab or a newline, which would split a field or a line
+var eventFieldEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`)
+
+func (e Event) String() string {
+	if len(e.Args) == 0 {
+		return string(e.Kind)
+	}
+	return string(e.Kind) + "\t" + e.fields()
+}
+
+// fields is the arguments of the event, escaped and separated by tabs
+func (e Event) fields() string {
+	escaped := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		escaped[i] = eventFieldEscaper.Replace(arg)
+	}
+	return strings.Join(escaped, "\t")
+}
+
+// how many events a reader may fall behind before it is dropped
+var eventBacklog = 256
+
+var ErrSlowReader = errors.New("events: reader fell behind and was dropped, reopen to resume")
+
+type subscriber struct {
+	events  chan Event
+	dropped bool
+}
+
+// EventBus fans vault changes out to every open events file.
+// Publishing never blocks: readers that cannot keep up are dropped
+type EventBus struct {
+	subs map[*subscriber]struct{}
+	sync.Mutex
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: map[*subscriber]struct{}{}}
+}
+
+func (eb *EventBus) Publish(kind EventKind, args ...string) {
+	if eb == nil {
+		return
+	}
+	ev := Event{Kind: kind, Args: args}
+	eb.Lock()
+	defer eb.Unlock()
+	for sub := range eb.subs {
+		select {
+		case sub.events <- ev:
+		default:
+			sub.dropped = true
+			close(sub.events)
+			delete(eb.subs, sub)
+		}
+	}
+}
+
+func (eb *EventBus) subscribe() *subscriber {
+	sub := &subscriber{events: make(chan Event, eventBacklog)}
+	eb.Lock()
+	defer eb.Unlock()
+	eb.subs[sub] = struct{}{}
+	return sub
+}
+
+func (eb *EventBus) unsubscribe(sub *subscriber) {
+	eb.Lock()
+	defer eb.Unlock()
+	if _, ok := eb.subs[sub]; ok {
+		close(sub.events)
+		delete(eb.subs, sub)
+	}
+}
+
+// wasDropped must be asked after the channel is closed
+func (eb *EventBus) wasDropped(sub *subscriber) bool {
+	eb.Lock()
+	defer eb.Unlock()
+	return sub.dropped
+}
+
+// publishTagChanges reports the tags that differ between two versions of a note
+func (eb *EventBus) publishTagChanges(loc data.VaultLocation, before, after data.TagSet) {
+	for _, tag := range after.List() {
+		if !before.Contains(tag) {
+			eb.Publish(EventTagAdded, string(loc), string(tag))
+		}
+	}
+	for _, tag := range before.List() {
+		if !after.Contains(tag) {
+			eb.Publish(EventTagRemoved, string(loc), string(tag))
+		}
+	}
+}
+
+// eventsFile gives every fid its own cursor into the event stream.
+// Reads block until there is something to return
+type eventsFile struct {
+	fs9p.BaseFile
+	bus     *EventBus
+	readers map[uint64]*eventReader
+}
+
+type eventReader struct {
+	sub    *subscriber
+	unread []byte
+	sync.Mutex
+}
+
+func newEventsFile(filesys *fs9p.FS, bus *EventBus) *eventsFile {
+	return &eventsFile{
+		BaseFile: *fs9p.NewBaseFile(filesys.NewStat("events", User, Group, 0444)),
+		bus:      bus,
+		readers:  map[uint64]*eventReader{},
+	}
+}
+
+func (f *eventsFile) Open(fid uint64, omode proto.Mode) error {
+	if omode&0x0F != proto.Oread {
+		return fmt.Errorf("events is read only")
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.readers[fid] = &eventReader{sub: f.bus.subscribe()}
+	return nil
+}
+
+func (f *eventsFile) Read(fid uint64, offset uint64, count uint64) ([]byte, error) {
+	f.RLock()
+	r := f.readers[fid]
+	f.RUnlock()
+	if r == nil {
+		return nil, fmt.Errorf("events: fid not open")
+	}
+	// one read at a time per fid so lines are never interleaved
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.unread) == 0 {
+		ev, ok := <-r.sub.events
+		if !ok {
+			if f.bus.wasDropped(r.sub) {
+				return nil, ErrSlowReader
+			}
+			return []byte{}, nil
+		}
+		r.unread = []byte(ev.String() + "\n")
+	}
+	// hand over whatever else is already queued without blocking
+drain:
+	for uint64(len(r.unread)) < count {
+		select {
+		case ev, ok := <-r.sub.events:
+			if !ok {
+				break drain
+			}
+			r.unread = append(r.unread, []byte(ev.String()+"\n")...)
+		default:
+			break drain
+		}
+	}
+	n := min(count, uint64(len(r.unread)))
+	out := r.unread[:n]
+	r.unread = r.unread[n:]
+	return out, nil
+}
+
+func (f *eventsFile) Close(fid uint64) error {
+	f.Lock()
+	r := f.readers[fid]
+	delete(f.readers, fid)
+	f.Unlock()
+	if r != nil {
+		f.bus.unsubscribe(r.sub)
+	}
+	return nil
+}
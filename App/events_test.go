@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/knusbaum/go9p/proto"
+)
+
+func TestEventLines(t *testing.T) {
+	ev := Event{Kind: EventRenamed, Args: []string{"old note.md", "tab\there.md"}}
+	fields := strings.Split(ev.String(), "\t")
+	want := []string{"renamed", "old note.md", `tab\there.md`}
+	if strings.Join(fields, "|") != strings.Join(want, "|") {
+		t.Fatalf("Expected fields %q, got %q", want, fields)
+	}
+	if line := (Event{Kind: EventModified, Args: []string{"two\nlines.md"}}).String(); strings.Contains(line, "\n") {
+		t.Fatalf("Expected one line, got %q", line)
+	}
+	if line := (Event{Kind: EventReindexComplete}).String(); line != "reindex-complete" {
+		t.Fatalf("Expected no trailing tab, got %q", line)
+	}
+}
+
+func TestSlowReaderIsDropped(t *testing.T) {
+	backlog := eventBacklog
+	eventBacklog = 2
+	t.Cleanup(func() { eventBacklog = backlog })
+
+	vault, ft := testVault(t, nil)
+	events := newEventsFile(ft.fs, vault.events)
+	if err := events.Open(1, proto.Oread); err != nil {
+		t.Fatal(err)
+	}
+	if err := events.Open(2, proto.Oread); err != nil {
+		t.Fatal(err)
+	}
+	// fid 2 keeps up, fid 1 does not
+	for _, name := range []string{"a.md", "b.md", "c.md"} {
+		vault.events.Publish(EventCreated, name)
+		if bs, err := events.Read(2, 0, 1024); err != nil || string(bs) != "created\t"+name+"\n" {
+			t.Fatalf("Expected the reader keeping up to see %s, got %q: %v", name, bs, err)
+		}
+	}
+
+	// what was queued before the drop is still handed over
+	if bs, err := events.Read(1, 0, 1024); err != nil || string(bs) != "created\ta.md\ncreated\tb.md\n" {
+		t.Fatalf("Expected the queued events, got %q: %v", bs, err)
+	}
+	if _, err := events.Read(1, 0, 1024); !errors.Is(err, ErrSlowReader) {
+		t.Fatalf("Expected the slow reader to be dropped with %v, got %v", ErrSlowReader, err)
+	}
+}
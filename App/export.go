@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	backendfs "PumiceBackend/proto9p/fs"
+	"PumiceBackend/server"
+)
+
+const exportUsage = `usage: export [flags]
+
+Serves the files under a directory over 9P exactly as they are on disk,
+without indexing anything, using the file server of the Backend. Clients
+only read unless -rw is given.
+`
+
+// exportAddr is where export listens when no -listen is given
+var exportAddr = "tcp!localhost!9000"
+
+// exportMain runs the export subcommand and returns the exit code
+func exportMain(args []string) int {
+	opts := cliOptions{}
+	flags := outputFlags("export", exportUsage, &opts)
+	addrs := listenFlag{}
+	flags.Var(&addrs, "listen", "address to serve on, tcp!host!port or unix!/path (repeatable, default "+exportAddr+")")
+	rw := flags.Bool("rw", false, "let clients create, write, rename and remove files, anyone who can connect may do so")
+	if !parseCommand(flags, args, 0, 0) {
+		return 2
+	}
+	opts.setupLogging()
+	if len(addrs) == 0 {
+		addrs = listenFlag{exportAddr}
+	}
+
+	root, err := filepath.Abs(opts.vault)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fileServer, err := backendfs.NewServer(root)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fileServer.ReadOnly = !*rw
+	listeners, err := listen(addrs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	bound := make([]string, len(listeners))
+	for i, l := range listeners {
+		bound[i] = l.Addr().String()
+	}
+	code := opts.print(map[string]any{"directory": root, "listen": bound, "readonly": fileServer.ReadOnly}, func(w io.Writer) {
+		fmt.Fprintf(w, "exporting %s on %s\n", root, strings.Join(bound, ", "))
+	})
+	if code != 0 {
+		return code
+	}
+
+	served := make(chan error, len(listeners))
+	for _, l := range listeners {
+		defer l.Close()
+		go func() {
+			served <- exportListener(l, &fileServer)
+		}()
+	}
+	err = <-served
+	if err != nil {
+		slog.Error("Exporting failed", "err", err)
+		return 1
+	}
+	return 0
+}
+
+// exportListener is serveListener for the Backend file server
+func exportListener(l net.Listener, srv server.Server) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			slog.Info("Client connected", "addr", l.Addr(), "remote", conn.RemoteAddr())
+			err := server.Serve(bufio.NewReader(conn), conn, srv)
+			if err != nil {
+				slog.Debug("Client disconnected", "remote", conn.RemoteAddr(), "err", err)
+			}
+		}()
+	}
+}
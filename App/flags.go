@@ -1,26 +1,64 @@
 package main
 
 import (
-	"flag"
-	"fmt"
-	"os"
+	"errors"
+	"strings"
 
 	"github.com/cowsed/Pumice/App/data"
 )
 
 type Flags struct {
 	VaultPath data.OSPath
+	// File holding the passphrase for encrypted notes. PUMICE_PASSPHRASE is used if this is empty
+	PassphraseFile string
+	// Plan 9 style dial strings to serve the vault on. The vault is posted as a plan9port service if empty
+	Listen []string
+	// Serve the vault without letting clients change it
+	ReadOnly bool
+	// Address of the HTTP API, disabled if empty. A bare port binds to localhost
+	HTTP string
 }
 
-func parseFlags() Flags {
-	flag.Parse()
+// listenFlag collects every -listen given
+type listenFlag []string
 
-	args := flag.Args()
-	if len(args) != 1 {
-		fmt.Println("This app requires one command line argument. The directory of the vault to open")
-		os.Exit(1)
+func (lf *listenFlag) String() string {
+	return strings.Join(*lf, ",")
+}
+
+func (lf *listenFlag) Set(addr string) error {
+	_, _, err := parseDialString(addr)
+	if err != nil {
+		return err
 	}
-	return Flags{
-		VaultPath: data.OSPath(args[0]),
+	*lf = append(*lf, addr)
+	return nil
+}
+
+const serveUsage = `usage: serve [flags] [vault]
+
+Serves the vault over 9P, posted as the plan9port service vaultfs unless
+-listen is given. The vault may be given with -vault or as the argument.
+`
+
+// parseFlags reads the flags of serve, the shared ones end up in opts
+func parseFlags(args []string, opts *cliOptions) (Flags, error) {
+	flags := vaultFlags("serve", serveUsage, opts)
+	listen := listenFlag{}
+	flags.Var(&listen, "listen", "address to serve the vault on, tcp!host!port or unix!/path (repeatable)")
+	readOnly := flags.Bool("readonly", false, "serve the vault read only")
+	httpAddr := flags.String("http", "", "also serve the vault as an HTTP API on this address, a bare port binds to localhost")
+	if !parseCommand(flags, args, 0, 1) {
+		return Flags{}, errors.New("bad arguments")
 	}
+	if flags.NArg() == 1 {
+		opts.vault = flags.Arg(0)
+	}
+	return Flags{
+		VaultPath:      data.OSPath(opts.vault),
+		PassphraseFile: opts.passphraseFile,
+		Listen:         listen,
+		ReadOnly:       *readOnly,
+		HTTP:           *httpAddr,
+	}, nil
 }
@@ -3,6 +3,7 @@ module github.com/cowsed/Pumice/App
 go 1.23.0
 
 require (
+	PumiceBackend v0.0.0
 	fyne.io/fyne/v2 v2.5.1
 	github.com/knusbaum/go9p v1.18.0
 	github.com/litao91/goldmark-mathjax v0.0.0-20210217064022-a43cf739a50f
@@ -11,6 +12,7 @@ require (
 	github.com/yuin/goldmark-meta v1.1.0
 	go.abhg.dev/goldmark/hashtag v0.3.1
 	go.abhg.dev/goldmark/wikilink v0.5.0
+	golang.org/x/crypto v0.31.0
 )
 
 require (
@@ -32,7 +34,10 @@ require (
 	github.com/srwiley/rasterx v0.0.0-20220730225603-2ab79fcdd4ef // indirect
 	golang.org/x/image v0.18.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
-	golang.org/x/sys v0.20.0 // indirect
-	golang.org/x/text v0.16.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
+
+// the export command serves files with the Backend file server
+replace PumiceBackend => ../Backend
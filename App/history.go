@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+const historyUsage = `usage: history [flags] <command> [args]
+
+commands:
+  list <note>                 list snapshots of a note, newest first
+  diff <note> <from> [to]     diff two snapshots (to defaults to the newest)
+  show <note> <version>       print a snapshot
+  restore <note> <version>    write a snapshot back into the vault
+`
+
+// historyMain runs the history subcommand and returns the exit code
+func historyMain(args []string) int {
+	opts := cliOptions{}
+	flags := outputFlags("history", historyUsage, &opts)
+	if !parseCommand(flags, args, 2, 4) {
+		return 2
+	}
+	opts.setupLogging()
+	rest := flags.Args()
+
+	vaultPath := data.OSPath(opts.vault)
+	opts.loadConfig(vaultPath)
+	store, err := openSnapshotStore(vaultPath, opts.config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "opening snapshot store:", err)
+		return 1
+	}
+
+	cmd, note := rest[0], data.VaultLocation(rest[1])
+	switch {
+	case cmd == "list" && len(rest) == 2:
+		versions := store.Versions(note)
+		return opts.print(versions, func(w io.Writer) {
+			for _, v := range versions {
+				fmt.Fprintf(w, "%s %s %6d bytes\n", v.Short(), v.Time.Format(time.RFC3339), v.Size)
+			}
+		})
+	case cmd == "diff" && (len(rest) == 3 || len(rest) == 4):
+		to := ""
+		if len(rest) == 4 {
+			to = rest[3]
+		} else if versions := store.Versions(note); len(versions) > 0 {
+			to = versions[0].Hash
+		}
+		diff, err := store.Diff(note, rest[2], to)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return opts.print(map[string]string{"diff": diff}, func(w io.Writer) {
+			fmt.Fprint(w, diff)
+		})
+	case cmd == "show" && len(rest) == 3:
+		bs, err := store.Read(note, rest[2])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return opts.print(map[string]string{"content": string(bs)}, func(w io.Writer) {
+			w.Write(bs)
+		})
+	case cmd == "restore" && len(rest) == 3:
+		v, err := store.Restore(vaultPath, note, rest[2])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return opts.print(v, func(w io.Writer) {
+			fmt.Fprintf(w, "restored %s to %s\n", note, v.Short())
+		})
+	}
+	flags.Usage()
+	return 2
+}
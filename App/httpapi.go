@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	fs9p "github.com/knusbaum/go9p/fs"
+	"github.com/knusbaum/go9p/proto"
+)
+
+// httpAPI serves the same tree as 9P to clients that only speak HTTP.
+// GET reads files and lists directories as JSON, PUT replaces a file, POST sends a command to ctl
+// or creates a note or folder, DELETE removes one. GET /events is a server-sent event stream
+type httpAPI struct {
+	fs     *fs9p.FS
+	events *EventBus
+	// fids for files opened over HTTP. 9P fids carry a connection number from 1 up in the top half, so these never collide
+	nextFid atomic.Uint64
+	// the host named on the command line and the port listened on, see allowedHost
+	host string
+	port string
+}
+
+type dirEntry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir"`
+	Length  uint64    `json:"length"`
+	Mode    string    `json:"mode"`
+	Mtime   time.Time `json:"mtime"`
+	Version uint32    `json:"version"`
+}
+
+func newDirEntry(st proto.Stat) dirEntry {
+	return dirEntry{
+		Name:    st.Name,
+		Dir:     st.Mode&proto.DMDIR != 0,
+		Length:  st.Length,
+		Mode:    fmt.Sprintf("%o", st.Mode&0777),
+		Mtime:   time.Unix(int64(st.Mtime), 0).UTC(),
+		Version: st.Qid.Vers,
+	}
+}
+
+// httpAddr binds to localhost unless a host is given
+func httpAddr(addr string) string {
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return addr
+}
+
+func serveHTTP(addr string, api *httpAPI) error {
+	addr = httpAddr(addr)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	api.host, _, _ = net.SplitHostPort(addr)
+	_, api.port, _ = net.SplitHostPort(l.Addr().String())
+	slog.Info("serving http", "addr", l.Addr())
+	srv := &http.Server{
+		Handler:           api,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		// the event stream lifts this for itself
+		WriteTimeout: time.Minute,
+		IdleTimeout:  2 * time.Minute,
+	}
+	return srv.Serve(l)
+}
+
+// allowedHost says whether a request naming host may have been meant for us.
+// Web pages can point a name they control at 127.0.0.1 to reach the API, so names other
+// than localhost and the one listened on are refused, IP addresses are always fine
+func (api *httpAPI) allowedHost(host string) bool {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = host, "80"
+	}
+	if port != api.port {
+		return false
+	}
+	return name == "localhost" || strings.EqualFold(name, api.host) || net.ParseIP(strings.Trim(name, "[]")) != nil
+}
+
+// sameOrigin refuses requests a browser sends on behalf of another site
+func (api *httpAPI) sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && api.allowedHost(u.Host)
+}
+
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNoSuchNote):
+		return http.StatusNotFound
+	case errors.Is(err, ErrNoteExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, errNoteTooLarge):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+var errNotFound = errors.New("no such file or directory")
+
+// walk finds the node at p. Query directories are made up on the way but never added to the tree
+func (api *httpAPI) walk(p string) (fs9p.FSNode, error) {
+	var node fs9p.FSNode = api.fs.Root
+	for _, name := range strings.Split(strings.Trim(path.Clean(p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		dir, ok := node.(fs9p.Dir)
+		if !ok {
+			return nil, errNotFound
+		}
+		child, ok := dir.Children()[name]
+		if !ok && api.fs.WalkFail != nil {
+			made, err := api.fs.WalkFail(api.fs, dir, name)
+			if err != nil {
+				return nil, err
+			}
+			child, ok = made, made != nil
+		}
+		if !ok {
+			return nil, errNotFound
+		}
+		node = child
+	}
+	return node, nil
+}
+
+func (api *httpAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !api.allowedHost(r.Host) {
+		http.Error(w, "unknown host "+r.Host, http.StatusMisdirectedRequest)
+		return
+	}
+	if !api.sameOrigin(r) {
+		http.Error(w, "cross site requests are not allowed", http.StatusForbidden)
+		return
+	}
+	node, err := api.walk(r.URL.Path)
+	if errors.Is(err, errNotFound) && r.Method == http.MethodPost {
+		api.create(w, r)
+		return
+	} else if errors.Is(err, errNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		api.get(w, r, node)
+	case http.MethodPut:
+		api.write(w, r, node, proto.Owrite|proto.Otrunc)
+	case http.MethodPost:
+		// anything else would keep whatever the body is too short to overwrite
+		if _, ok := node.(*ctlFile); !ok {
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			http.Error(w, "POST only sends commands to ctl or creates what does not exist yet, PUT replaces a file", http.StatusMethodNotAllowed)
+			return
+		}
+		api.write(w, r, node, proto.Owrite)
+	case http.MethodDelete:
+		if api.fs.RemoveFile == nil {
+			http.Error(w, ErrReadOnly.Error(), http.StatusForbidden)
+			return
+		}
+		err := api.fs.RemoveFile(api.fs, node)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (api *httpAPI) get(w http.ResponseWriter, r *http.Request, node fs9p.FSNode) {
+	if _, ok := node.(*eventsFile); ok {
+		api.stream(w, r)
+		return
+	}
+	if dir, ok := node.(fs9p.Dir); ok {
+		entries := []dirEntry{}
+		for _, child := range dir.Children() {
+			entries = append(entries, newDirEntry(child.Stat()))
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+
+	file := node.(fs9p.File)
+	fid := api.nextFid.Add(1)
+	err := file.Open(fid, proto.Oread)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	defer file.Close(fid)
+	content := []byte{}
+	for {
+		chunk, err := file.Read(fid, uint64(len(content)), proto.IOUnit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		content = append(content, chunk...)
+	}
+	if file.Stat().Name == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Write(content)
+}
+
+// write sends the request body to a file the way a 9P client would: open, write, clunk
+func (api *httpAPI) write(w http.ResponseWriter, r *http.Request, node fs9p.FSNode, mode proto.Mode) {
+	file, ok := node.(fs9p.File)
+	if !ok {
+		http.Error(w, "cannot write to a directory", http.StatusMethodNotAllowed)
+		return
+	}
+	content, ok := readRequest(w, r)
+	if !ok {
+		return
+	}
+	err := writeFile(file, api.nextFid.Add(1), content, mode)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readRequest reads the request body, up to the size a note may have. It answers the request itself when it fails
+func readRequest(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxNoteSize)))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, errNoteTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return content, true
+}
+
+func writeFile(file fs9p.File, fid uint64, content []byte, mode proto.Mode) error {
+	err := file.Open(fid, mode)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(fid, 0, content)
+	closeErr := file.Close(fid)
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// create makes the note or folder at the request path. A note is given the request body if there is one
+func (api *httpAPI) create(w http.ResponseWriter, r *http.Request) {
+	if api.fs.CreateDir == nil {
+		http.Error(w, ErrReadOnly.Error(), http.StatusForbidden)
+		return
+	}
+	parentPath, name := path.Split(path.Clean(r.URL.Path))
+	parent, err := api.walk(parentPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	dir, ok := parent.(fs9p.Dir)
+	if !ok {
+		http.Error(w, parentPath+" is not a directory", http.StatusBadRequest)
+		return
+	}
+	content, ok := readRequest(w, r)
+	if !ok {
+		return
+	}
+
+	made, err := api.fs.CreateDir(api.fs, dir, User, name, proto.DMDIR|writableDirPerm, uint8(proto.Oread))
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	if body, ok := made.Children()["body"].(fs9p.File); ok && len(content) > 0 {
+		err = writeFile(body, api.nextFid.Add(1), content, proto.Owrite|proto.Otrunc)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+	}
+	w.Header().Set("Location", fs9p.FullPath(made))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// stream sends vault changes as server-sent events until the client goes away
+func (api *httpAPI) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	// the stream outlives the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	sub := api.events.subscribe()
+	defer api.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.events:
+			if !ok {
+				if api.events.wasDropped(sub) {
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", ErrSlowReader)
+					flusher.Flush()
+				}
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Kind, ev.fields())
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testAPI serves a writable vault holding files over HTTP as if it listened on localhost:8080
+func testAPI(t *testing.T, files map[string]string) (*Vault, *httpAPI) {
+	t.Helper()
+	vault, _ := testVault(t, files)
+	vfs, _ := makeVaultCacheFS(vault, NewConfig())
+	return vault, &httpAPI{fs: vfs, events: vault.events, host: "localhost", port: "8080"}
+}
+
+func request(api *httpAPI, method, target, body string, headers ...string) *http.Response {
+	r := httptest.NewRequest(method, "http://localhost:8080"+target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
+
+func TestHTTPMethods(t *testing.T) {
+	vault, api := testAPI(t, map[string]string{"a.md": "alpha"})
+	onDisk := func(name string) string {
+		bs, _ := os.ReadFile(filepath.Join(string(vault.path), name))
+		return string(bs)
+	}
+
+	resp := request(api, http.MethodGet, "/data/a.md/body", "")
+	if resp.StatusCode != http.StatusOK || readBody(t, resp) != "alpha" {
+		t.Fatalf("Expected to read the body, got %v", resp.Status)
+	}
+	resp = request(api, http.MethodGet, "/data", "")
+	entries := []dirEntry{}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "a.md" || !entries[0].Dir {
+		t.Fatalf("Expected data/ to list a.md, got %v", entries)
+	}
+	if resp := request(api, http.MethodGet, "/data/missing.md", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected a missing note to be not found, got %v", resp.Status)
+	}
+
+	if resp := request(api, http.MethodPut, "/data/a.md/body", "replaced"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected PUT to replace the body, got %v: %s", resp.Status, readBody(t, resp))
+	}
+	if got := onDisk("a.md"); got != "replaced" {
+		t.Fatalf("Expected the note to be replaced on disk, got %q", got)
+	}
+
+	if resp := request(api, http.MethodPost, "/data/b.md", "beta"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected POST to create a note, got %v: %s", resp.Status, readBody(t, resp))
+	}
+	if got := onDisk("b.md"); got != "beta" {
+		t.Fatalf("Expected the new note on disk, got %q", got)
+	}
+
+	if resp := request(api, http.MethodDelete, "/data/b.md", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected DELETE to remove the note, got %v: %s", resp.Status, readBody(t, resp))
+	}
+	if _, ok := vault.Note("b.md"); ok {
+		t.Fatal("Expected b.md to be gone from the vault")
+	}
+}
+
+func TestHTTPRefusesOtherSites(t *testing.T) {
+	_, api := testAPI(t, map[string]string{"a.md": "alpha"})
+
+	for _, c := range []struct {
+		name    string
+		host    string
+		headers []string
+		status  int
+	}{
+		{"rebound name", "evil.example:8080", nil, http.StatusMisdirectedRequest},
+		{"other port", "localhost:9090", nil, http.StatusMisdirectedRequest},
+		{"foreign origin", "localhost:8080", []string{"Origin", "http://evil.example"}, http.StatusForbidden},
+		{"cross site fetch", "localhost:8080", []string{"Sec-Fetch-Site", "cross-site"}, http.StatusForbidden},
+		{"same site fetch", "localhost:8080", []string{"Sec-Fetch-Site", "same-site"}, http.StatusForbidden},
+		{"ip address", "127.0.0.1:8080", nil, http.StatusNoContent},
+		{"own origin", "localhost:8080", []string{"Origin", "http://localhost:8080", "Sec-Fetch-Site", "same-origin"}, http.StatusNoContent},
+	} {
+		r := httptest.NewRequest(http.MethodPut, "http://"+c.host+"/data/a.md/body", strings.NewReader(c.name))
+		for i := 0; i+1 < len(c.headers); i += 2 {
+			r.Header.Set(c.headers[i], c.headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: expected %d, got %d", c.name, c.status, w.Code)
+		}
+	}
+}
+
+func TestHTTPWriteLimits(t *testing.T) {
+	limit := maxNoteSize
+	maxNoteSize = 10
+	t.Cleanup(func() { maxNoteSize = limit })
+	vault, api := testAPI(t, map[string]string{"a.md": "alpha"})
+
+	if resp := request(api, http.MethodPut, "/data/a.md/body", "far too long"); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected an oversized PUT to be refused, got %v", resp.Status)
+	}
+	if resp := request(api, http.MethodPost, "/data/b.md", "far too long"); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected an oversized POST to be refused, got %v", resp.Status)
+	}
+	if _, ok := vault.Note("b.md"); ok {
+		t.Fatal("Expected no note from the oversized POST")
+	}
+
+	// POST would keep the end of a longer file
+	if resp := request(api, http.MethodPost, "/data/a.md/body", "al"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected POST to a note file to be refused, got %v", resp.Status)
+	}
+	if bs, _ := vault.ReadBody("a.md"); string(bs) != "alpha" {
+		t.Fatalf("Expected the body to be left alone, got %q", bs)
+	}
+	if resp := request(api, http.MethodPost, "/ctl", "reindex"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected POST to ctl to run the command, got %v: %s", resp.Status, readBody(t, resp))
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/cowsed/Pumice/App/data"
+	fs9p "github.com/knusbaum/go9p/fs"
+	"github.com/knusbaum/go9p/proto"
+)
+
+// listing is one entry of a listingDir. A node made for an earlier listing is kept as long as key stays the same
+type listing struct {
+	name string
+	key  any
+	make func() fs9p.FSNode
+}
+
+// listingDir works out its entries from the vault every time it is read or walked,
+// so it never has to be told about changes. Kept nodes keep their qids
+type listingDir struct {
+	stat   proto.Stat
+	parent fs9p.Dir
+	list   func() []listing
+	nodes  map[string]listedNode
+	sync.Mutex
+}
+
+type listedNode struct {
+	key  any
+	node fs9p.FSNode
+}
+
+func newListingDir(filesys *fs9p.FS, name string, list func() []listing) *listingDir {
+	stat := filesys.NewStat(name, User, Group, proto.DMDIR|0555)
+	stat.Qid.Qtype = uint8(stat.Mode >> 24)
+	return &listingDir{
+		stat:  *stat,
+		list:  list,
+		nodes: map[string]listedNode{},
+	}
+}
+
+func (d *listingDir) Stat() proto.Stat {
+	return d.stat
+}
+
+func (d *listingDir) WriteStat(s *proto.Stat) error {
+	return errors.New("listing directories cannot be changed")
+}
+
+func (d *listingDir) SetParent(p fs9p.Dir) {
+	d.Lock()
+	defer d.Unlock()
+	d.parent = p
+}
+
+func (d *listingDir) Parent() fs9p.Dir {
+	d.Lock()
+	defer d.Unlock()
+	return d.parent
+}
+
+func (d *listingDir) Children() map[string]fs9p.FSNode {
+	entries := d.list()
+	d.Lock()
+	defer d.Unlock()
+	kept := map[string]listedNode{}
+	children := map[string]fs9p.FSNode{}
+	for _, e := range entries {
+		if _, dup := children[e.name]; dup {
+			continue
+		}
+		ln, ok := d.nodes[e.name]
+		if !ok || ln.key != e.key {
+			ln = listedNode{key: e.key, node: e.make()}
+			ln.node.SetParent(d)
+		}
+		kept[e.name] = ln
+		children[e.name] = ln.node
+	}
+	d.nodes = kept
+	return children
+}
+
+// noteLink stands in for the directory of a note from data/. The files inside are the note's own,
+// so walking back out of them ends up in data/
+type noteLink struct {
+	fs9p.Dir
+	parent fs9p.Dir
+}
+
+func (l *noteLink) SetParent(d fs9p.Dir) {
+	l.parent = d
+}
+
+func (l *noteLink) Parent() fs9p.Dir {
+	return l.parent
+}
+
+// notesDir lists a set of notes laid out in folders as in data/, starting at folder prefix
+func notesDir(filesys *fs9p.FS, tree *FSSTate, name string, prefix data.VaultLocation, notes func() []data.VaultLocation) *listingDir {
+	return newListingDir(filesys, name, func() []listing {
+		locs := notes()
+		tree.Lock()
+		defer tree.Unlock()
+		entries := []listing{}
+		for _, loc := range locs {
+			rest := string(loc)
+			if prefix != "." {
+				var inside bool
+				rest, inside = strings.CutPrefix(rest, string(prefix)+"/")
+				if !inside {
+					continue
+				}
+			}
+			if folder, _, nested := strings.Cut(rest, "/"); nested {
+				entries = append(entries, listing{name: folder, key: "folder", make: func() fs9p.FSNode {
+					return notesDir(filesys, tree, folder, prefix.Append(folder), notes)
+				}})
+				continue
+			}
+			noteDir, ok := tree.notedirs[loc]
+			if !ok {
+				continue
+			}
+			entries = append(entries, listing{name: rest, key: noteDir, make: func() fs9p.FSNode {
+				return &noteLink{Dir: noteDir}
+			}})
+		}
+		return entries
+	})
+}
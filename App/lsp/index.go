@@ -0,0 +1,306 @@
+package lsp
+
+import (
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/cowsed/Pumice/App/data"
+	"github.com/yuin/goldmark/ast"
+)
+
+// linkSpan is a wikilink as written in a note. Offsets are bytes into the note's text
+type linkSpan struct {
+	Target   string
+	Fragment string
+	Embed    bool
+	// the whole link, brackets included
+	Start, End int
+	// where the target and the fragment are written. The fragment span is empty if there is none
+	TargetStart, TargetEnd int
+	FragStart, FragEnd     int
+}
+
+type headingSpan struct {
+	Text       string
+	Level      int
+	Start, End int
+}
+
+type document struct {
+	loc        data.VaultLocation
+	text       string
+	lineStarts []int
+	cache      data.NoteCache
+	links      []linkSpan
+	headings   []headingSpan
+}
+
+var wikilinkPattern = regexp.MustCompile(`(!?)\[\[([^\[\]\n]+?)\]\]`)
+var inlineCodePattern = regexp.MustCompile("`[^`\n]*`")
+
+func newDocument(loc data.VaultLocation, content string) *document {
+	doc := &document{loc: loc, text: content, lineStarts: []int{0}}
+	for i, c := range content {
+		if c == '\n' {
+			doc.lineStarts = append(doc.lineStarts, i+1)
+		}
+	}
+	source := []byte(content)
+	cache, root, _ := data.MakeNoteCache(loc, source)
+	doc.cache = cache
+	doc.headings = findHeadings(root, source)
+	doc.links = doc.findLinks()
+	return doc
+}
+
+func findHeadings(root ast.Node, source []byte) []headingSpan {
+	headings := []headingSpan{}
+	ast.Walk(root, func(node ast.Node, enter bool) (ast.WalkStatus, error) {
+		h, ok := node.(*ast.Heading)
+		if !ok || !enter || h.Lines().Len() == 0 {
+			return ast.WalkContinue, nil
+		}
+		seg := h.Lines().At(0)
+		headings = append(headings, headingSpan{
+			Text:  string(seg.Value(source)),
+			Level: h.Level,
+			Start: seg.Start,
+			End:   seg.Stop,
+		})
+		return ast.WalkSkipChildren, nil
+	})
+	return headings
+}
+
+// findLinks scans the text for wikilinks, skipping code blocks and inline code
+func (doc *document) findLinks() []linkSpan {
+	inCode := map[int]bool{}
+	for _, block := range doc.cache.CodeBlocks {
+		for line := block.StartLine; line <= block.EndLine; line++ {
+			inCode[line] = true
+		}
+	}
+	links := []linkSpan{}
+	for i, start := range doc.lineStarts {
+		if inCode[i+1] {
+			continue
+		}
+		end := len(doc.text)
+		if i+1 < len(doc.lineStarts) {
+			end = doc.lineStarts[i+1]
+		}
+		line := inlineCodePattern.ReplaceAllStringFunc(doc.text[start:end], func(code string) string {
+			return strings.Repeat(" ", len(code))
+		})
+		for _, m := range wikilinkPattern.FindAllStringSubmatchIndex(line, -1) {
+			links = append(links, parseLink(doc.text, start+m[0], start+m[1], m[3] > m[2]))
+		}
+	}
+	return links
+}
+
+// parseLink splits `[[target#fragment|alias]]` found at text[start:end]
+func parseLink(text string, start, end int, embed bool) linkSpan {
+	link := linkSpan{Embed: embed, Start: start, End: end}
+	inner := start + 2
+	if embed {
+		inner++
+	}
+	innerEnd := end - 2
+	if bar := strings.IndexByte(text[inner:innerEnd], '|'); bar >= 0 {
+		innerEnd = inner + bar
+	}
+	link.TargetStart, link.TargetEnd = inner, innerEnd
+	link.FragStart, link.FragEnd = innerEnd, innerEnd
+	if hash := strings.IndexByte(text[inner:innerEnd], '#'); hash >= 0 {
+		link.TargetEnd = inner + hash
+		link.FragStart = inner + hash + 1
+	}
+	link.Target = strings.TrimSpace(text[link.TargetStart:link.TargetEnd])
+	link.Fragment = strings.TrimSpace(text[link.FragStart:link.FragEnd])
+	return link
+}
+
+// position converts a byte offset into a line and UTF-16 column
+func (doc *document) position(offset int) Position {
+	line := sort.Search(len(doc.lineStarts), func(i int) bool { return doc.lineStarts[i] > offset }) - 1
+	start := doc.lineStarts[line]
+	return Position{Line: line, Character: utf16Len(doc.text[start:offset])}
+}
+
+func (doc *document) rangeOf(start, end int) Range {
+	return Range{Start: doc.position(start), End: doc.position(end)}
+}
+
+// offset converts a position back into a byte offset, clamped to the document
+func (doc *document) offset(p Position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(doc.lineStarts) {
+		return len(doc.text)
+	}
+	offset := doc.lineStarts[p.Line]
+	units := 0
+	for offset < len(doc.text) && units < p.Character {
+		r, size := utf8.DecodeRuneInString(doc.text[offset:])
+		if r == '\n' {
+			break
+		}
+		units += utf16.RuneLen(r)
+		offset += size
+	}
+	return offset
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+func (doc *document) linkAt(offset int) (linkSpan, bool) {
+	for _, link := range doc.links {
+		if link.Start <= offset && offset < link.End {
+			return link, true
+		}
+	}
+	return linkSpan{}, false
+}
+
+func (doc *document) headingAt(offset int) (headingSpan, bool) {
+	for _, h := range doc.headings {
+		// anywhere on the heading's line
+		lineStart := doc.lineStarts[doc.position(h.Start).Line]
+		if lineStart <= offset && offset <= h.End {
+			return h, true
+		}
+	}
+	return headingSpan{}, false
+}
+
+func (doc *document) heading(fragment string) (headingSpan, bool) {
+	for _, h := range doc.headings {
+		if strings.EqualFold(strings.TrimSpace(h.Text), fragment) {
+			return h, true
+		}
+	}
+	return headingSpan{}, false
+}
+
+// index holds every note of the vault in memory. Edits from the client replace a note's text
+// and the index is worked out again from that, the disk is only read once at start up
+type index struct {
+	root     string
+	docs     map[data.VaultLocation]*document
+	resolver data.LinkResolver
+}
+
+func newIndex(root string, texts map[data.VaultLocation]string) *index {
+	idx := &index{root: root, docs: map[data.VaultLocation]*document{}}
+	for loc, content := range texts {
+		idx.docs[loc] = newDocument(loc, content)
+	}
+	idx.relink()
+	return idx
+}
+
+func (idx *index) relink() {
+	locs := make([]data.VaultLocation, 0, len(idx.docs))
+	for loc := range idx.docs {
+		locs = append(locs, loc)
+	}
+	idx.resolver = data.NewLinkResolver(locs)
+}
+
+func (idx *index) update(loc data.VaultLocation, content string) {
+	_, existed := idx.docs[loc]
+	idx.docs[loc] = newDocument(loc, content)
+	if !existed {
+		idx.relink()
+	}
+}
+
+func (idx *index) move(from, to data.VaultLocation) {
+	doc, ok := idx.docs[from]
+	if !ok {
+		return
+	}
+	delete(idx.docs, from)
+	idx.docs[to] = newDocument(to, doc.text)
+	idx.relink()
+}
+
+// resolve finds the note a link in from points to. Links without a target point at from itself
+func (idx *index) resolve(from data.VaultLocation, link linkSpan) (*document, bool) {
+	if link.Target == "" {
+		doc, ok := idx.docs[from]
+		return doc, ok
+	}
+	doc, ok := idx.docs[idx.resolver.Resolve(from, data.Link(link.Target))]
+	return doc, ok
+}
+
+// sortedDocs lists the notes in path order so results are stable
+func (idx *index) sortedDocs() []*document {
+	docs := make([]*document, 0, len(idx.docs))
+	for _, doc := range idx.docs {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].loc < docs[j].loc })
+	return docs
+}
+
+// reference is a link somewhere in the vault
+type reference struct {
+	doc  *document
+	link linkSpan
+}
+
+// references lists the links that point to the note at loc
+func (idx *index) references(loc data.VaultLocation) []reference {
+	refs := []reference{}
+	for _, doc := range idx.sortedDocs() {
+		for _, link := range doc.links {
+			if target, ok := idx.resolve(doc.loc, link); ok && target.loc == loc {
+				refs = append(refs, reference{doc, link})
+			}
+		}
+	}
+	return refs
+}
+
+// linkText is how notes link to loc: by name unless another note has the same name
+func (idx *index) linkText(loc data.VaultLocation) string {
+	name := strings.TrimSuffix(string(loc.Name()), ".md")
+	for other := range idx.docs {
+		if other != loc && strings.EqualFold(strings.TrimSuffix(string(other.Name()), ".md"), name) {
+			return strings.TrimSuffix(string(loc), ".md")
+		}
+	}
+	return name
+}
+
+func (idx *index) uri(loc data.VaultLocation) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(idx.root, filepath.FromSlash(string(loc))))}).String()
+}
+
+// location maps a file URI back into the vault
+func (idx *index) location(uri string) (data.VaultLocation, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	rel, err := filepath.Rel(idx.root, filepath.FromSlash(u.Path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return data.VaultLocation(filepath.ToSlash(rel)), true
+}
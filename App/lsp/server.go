@@ -0,0 +1,428 @@
+// Package lsp is a language server for the notes of a vault. It completes wikilinks,
+// headings and tags, follows links, finds backlinks, renames notes and headings and
+// warns about broken links
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+type Server struct {
+	idx  *index
+	open map[data.VaultLocation]bool
+	out  io.Writer
+}
+
+// NewServer serves the notes of cache from the vault at root, an absolute path.
+// The text of every note is read once here, later changes come from the client
+func NewServer(root string, cache data.VaultCache, read func(data.VaultLocation) ([]byte, error)) (*Server, error) {
+	texts := map[data.VaultLocation]string{}
+	for _, note := range cache.Notes {
+		bs, err := read(note.Path)
+		if err != nil {
+			return nil, err
+		}
+		texts[note.Path] = string(bs)
+	}
+	return &Server{
+		idx:  newIndex(root, texts),
+		open: map[data.VaultLocation]bool{},
+	}, nil
+}
+
+// Serve answers requests read from in until the client sends exit or in ends
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	s.out = out
+	r := bufio.NewReader(in)
+	for {
+		msg, err := readMessage(r)
+		var rerr *responseError
+		if errors.As(err, &rerr) {
+			writeMessage(out, errorResponse{JSONRPC: "2.0", Error: rerr})
+			continue
+		} else if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			continue
+		}
+		if err != nil {
+			if !errors.As(err, &rerr) {
+				rerr = &responseError{Code: codeRequestFailed, Message: err.Error()}
+			}
+			err = writeMessage(out, errorResponse{JSONRPC: "2.0", ID: msg.ID, Error: rerr})
+		} else {
+			err = writeMessage(out, response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func decode[T any](params json.RawMessage) (T, error) {
+	var v T
+	err := json.Unmarshal(params, &v)
+	if err != nil {
+		return v, &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return v, nil
+}
+
+func (s *Server) handle(msg message) (any, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   map[string]any{"openClose": true, "change": 1},
+				"completionProvider": map[string]any{"triggerCharacters": []string{"[", "#"}},
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"renameProvider":     true,
+			},
+			"serverInfo": map[string]any{"name": "pumice"},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		params, err := decode[didOpenParams](msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.changed(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		params, err := decode[didChangeParams](msg.Params)
+		if err != nil || len(params.ContentChanges) == 0 {
+			return nil, err
+		}
+		// only full syncs are asked for, so the last change is the whole text
+		return nil, s.changed(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		params, err := decode[didCloseParams](msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		if loc, ok := s.idx.location(params.TextDocument.URI); ok {
+			delete(s.open, loc)
+			return nil, s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+		}
+		return nil, nil
+	case "textDocument/completion":
+		return withPosition(s, msg.Params, s.completion)
+	case "textDocument/definition":
+		return withPosition(s, msg.Params, s.definition)
+	case "textDocument/references":
+		return withPosition(s, msg.Params, s.references)
+	case "textDocument/rename":
+		params, err := decode[renameParams](msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		doc, offset, err := s.at(params.textDocumentPositionParams)
+		if err != nil {
+			return nil, err
+		}
+		return s.rename(doc, offset, params.NewName)
+	}
+	if msg.ID != nil {
+		return nil, &responseError{Code: codeMethodNotFound, Message: "method not supported: " + msg.Method}
+	}
+	return nil, nil
+}
+
+func (s *Server) notify(method string, params any) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) at(params textDocumentPositionParams) (*document, int, error) {
+	loc, ok := s.idx.location(params.TextDocument.URI)
+	doc := s.idx.docs[loc]
+	if !ok || doc == nil {
+		return nil, 0, fmt.Errorf("%s is not a note of the vault", params.TextDocument.URI)
+	}
+	return doc, doc.offset(params.Position), nil
+}
+
+func withPosition[T any](s *Server, raw json.RawMessage, f func(doc *document, offset int) (T, error)) (any, error) {
+	params, err := decode[textDocumentPositionParams](raw)
+	if err != nil {
+		return nil, err
+	}
+	doc, offset, err := s.at(params)
+	if err != nil {
+		return nil, err
+	}
+	return f(doc, offset)
+}
+
+// changed takes new text for a note from the client and reports broken links in every open note
+func (s *Server) changed(uri, text string) error {
+	loc, ok := s.idx.location(uri)
+	if !ok {
+		return nil
+	}
+	s.open[loc] = true
+	s.idx.update(loc, text)
+	return s.publishDiagnostics()
+}
+
+func (s *Server) publishDiagnostics() error {
+	locs := make([]data.VaultLocation, 0, len(s.open))
+	for loc := range s.open {
+		locs = append(locs, loc)
+	}
+	sort.Slice(locs, func(i, j int) bool { return locs[i] < locs[j] })
+	for _, loc := range locs {
+		err := s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         s.idx.uri(loc),
+			Diagnostics: s.diagnostics(s.idx.docs[loc]),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) diagnostics(doc *document) []Diagnostic {
+	diags := []Diagnostic{}
+	for _, link := range doc.links {
+		target, ok := s.idx.resolve(doc.loc, link)
+		message := ""
+		switch {
+		case !ok:
+			message = fmt.Sprintf("no note named %q", link.Target)
+		case link.Fragment != "" && !strings.HasPrefix(link.Fragment, "^"):
+			if _, ok := target.heading(link.Fragment); !ok {
+				message = fmt.Sprintf("no heading %q in %s", link.Fragment, target.loc)
+			}
+		}
+		if message != "" {
+			diags = append(diags, Diagnostic{
+				Range:    doc.rangeOf(link.Start, link.End),
+				Severity: severityWarning,
+				Source:   "pumice",
+				Message:  message,
+			})
+		}
+	}
+	return diags
+}
+
+var tagPrefixPattern = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_/-]*)$`)
+
+func (s *Server) completion(doc *document, offset int) ([]CompletionItem, error) {
+	lineStart := doc.lineStarts[doc.position(offset).Line]
+	before := doc.text[lineStart:offset]
+	items := []CompletionItem{}
+
+	if open := strings.LastIndex(before, "[["); open >= 0 && !strings.Contains(before[open:], "]]") {
+		typed := before[open+2:]
+		if strings.Contains(typed, "|") {
+			return items, nil
+		}
+		if target, fragment, ok := strings.Cut(typed, "#"); ok {
+			// headings of the note being linked to
+			targetDoc, found := s.idx.resolve(doc.loc, linkSpan{Target: strings.TrimSpace(target)})
+			if !found {
+				return items, nil
+			}
+			replace := doc.rangeOf(offset-len(fragment), offset)
+			for _, h := range targetDoc.headings {
+				items = append(items, CompletionItem{
+					Label:    h.Text,
+					Kind:     completionReference,
+					Detail:   strings.Repeat("#", h.Level) + " " + h.Text,
+					TextEdit: &TextEdit{Range: replace, NewText: h.Text},
+				})
+			}
+			return items, nil
+		}
+		replace := doc.rangeOf(offset-len(typed), offset)
+		for _, other := range s.idx.sortedDocs() {
+			text := s.idx.linkText(other.loc)
+			items = append(items, CompletionItem{
+				Label:    text,
+				Kind:     completionFile,
+				Detail:   string(other.loc),
+				TextEdit: &TextEdit{Range: replace, NewText: text},
+			})
+		}
+		return items, nil
+	}
+
+	if m := tagPrefixPattern.FindStringSubmatch(before); m != nil {
+		replace := doc.rangeOf(offset-len(m[1]), offset)
+		tags := map[string]struct{}{}
+		for _, other := range s.idx.docs {
+			for _, tag := range other.cache.Tags.StringList() {
+				tag = strings.TrimPrefix(tag, "#")
+				// the tag being typed is already in the index
+				if other == doc && tag == m[1] {
+					continue
+				}
+				tags[tag] = struct{}{}
+			}
+		}
+		names := make([]string, 0, len(tags))
+		for tag := range tags {
+			names = append(names, tag)
+		}
+		sort.Strings(names)
+		for _, tag := range names {
+			items = append(items, CompletionItem{
+				Label:    tag,
+				Kind:     completionKeyword,
+				TextEdit: &TextEdit{Range: replace, NewText: tag},
+			})
+		}
+	}
+	return items, nil
+}
+
+// definition follows the link under the cursor to its note, or to the heading it names
+func (s *Server) definition(doc *document, offset int) (*Location, error) {
+	link, ok := doc.linkAt(offset)
+	if !ok {
+		return nil, nil
+	}
+	target, ok := s.idx.resolve(doc.loc, link)
+	if !ok {
+		return nil, nil
+	}
+	loc := &Location{URI: s.idx.uri(target.loc)}
+	if h, ok := target.heading(link.Fragment); ok && link.Fragment != "" {
+		loc.Range = target.rangeOf(h.Start, h.End)
+	}
+	return loc, nil
+}
+
+// references lists the backlinks of the note linked under the cursor, or of the note itself
+func (s *Server) references(doc *document, offset int) ([]Location, error) {
+	target := doc
+	if link, ok := doc.linkAt(offset); ok {
+		if linked, ok := s.idx.resolve(doc.loc, link); ok {
+			target = linked
+		}
+	}
+	locs := []Location{}
+	for _, ref := range s.idx.references(target.loc) {
+		locs = append(locs, Location{URI: s.idx.uri(ref.doc.loc), Range: ref.doc.rangeOf(ref.link.Start, ref.link.End)})
+	}
+	return locs, nil
+}
+
+// rename renames the heading under the cursor, otherwise the note linked under the cursor or the note itself.
+// Links to it are rewritten. The edit is applied to the index right away as the client may not
+// report changes to notes it does not have open
+func (s *Server) rename(doc *document, offset int, newName string) (*WorkspaceEdit, error) {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return nil, &responseError{Code: codeInvalidParams, Message: "the new name is empty"}
+	}
+	edits := map[data.VaultLocation][]TextEdit{}
+	addEdit := func(d *document, start, end int, text string) {
+		edits[d.loc] = append(edits[d.loc], TextEdit{Range: d.rangeOf(start, end), NewText: text})
+	}
+
+	if h, ok := doc.headingAt(offset); ok && !inLink(doc, offset) {
+		addEdit(doc, h.Start, h.End, newName)
+		for _, ref := range s.idx.references(doc.loc) {
+			if strings.EqualFold(ref.link.Fragment, strings.TrimSpace(h.Text)) {
+				addEdit(ref.doc, ref.link.FragStart, ref.link.FragEnd, newName)
+			}
+		}
+		return s.apply(edits, nil), nil
+	}
+
+	target := doc
+	if link, ok := doc.linkAt(offset); ok {
+		linked, ok := s.idx.resolve(doc.loc, link)
+		if !ok {
+			return nil, &responseError{Code: codeRequestFailed, Message: fmt.Sprintf("no note named %q", link.Target)}
+		}
+		target = linked
+	}
+	newLoc := data.VaultLocation(strings.TrimPrefix(path.Clean(strings.TrimSuffix(newName, ".md")+".md"), "/"))
+	if !strings.Contains(newName, "/") {
+		newLoc = target.loc.Dir().Append(string(newLoc))
+	}
+	// the rename would move the note out of the vault, or leave it without a name
+	if newLoc.Name() == ".md" || strings.HasPrefix(string(newLoc), "../") {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("%q is not a note in the vault", newName)}
+	}
+	if _, exists := s.idx.docs[newLoc]; exists {
+		return nil, &responseError{Code: codeRequestFailed, Message: fmt.Sprintf("%s already exists", newLoc)}
+	}
+	for _, ref := range s.idx.references(target.loc) {
+		if ref.link.Target == "" {
+			continue
+		}
+		text := strings.TrimSuffix(string(newLoc.Name()), ".md")
+		if strings.Contains(ref.link.Target, "/") {
+			text = strings.TrimSuffix(string(newLoc), ".md")
+		}
+		addEdit(ref.doc, ref.link.TargetStart, ref.link.TargetEnd, text)
+	}
+	return s.apply(edits, &RenameFile{Kind: "rename", OldURI: s.idx.uri(target.loc), NewURI: s.idx.uri(newLoc)}), nil
+}
+
+func inLink(doc *document, offset int) bool {
+	_, ok := doc.linkAt(offset)
+	return ok
+}
+
+// apply turns edits into a WorkspaceEdit and makes the same changes to the index
+func (s *Server) apply(edits map[data.VaultLocation][]TextEdit, move *RenameFile) *WorkspaceEdit {
+	we := &WorkspaceEdit{DocumentChanges: []any{}}
+	locs := make([]data.VaultLocation, 0, len(edits))
+	for loc := range edits {
+		locs = append(locs, loc)
+	}
+	sort.Slice(locs, func(i, j int) bool { return locs[i] < locs[j] })
+	for _, loc := range locs {
+		doc := s.idx.docs[loc]
+		tde := TextDocumentEdit{Edits: edits[loc]}
+		tde.TextDocument.URI = s.idx.uri(loc)
+		we.DocumentChanges = append(we.DocumentChanges, tde)
+
+		// later edits first so earlier offsets stay valid
+		sorted := append([]TextEdit{}, edits[loc]...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return doc.offset(sorted[i].Range.Start) > doc.offset(sorted[j].Range.Start)
+		})
+		text := doc.text
+		for _, e := range sorted {
+			text = text[:doc.offset(e.Range.Start)] + e.NewText + text[doc.offset(e.Range.End):]
+		}
+		s.idx.update(loc, text)
+	}
+	if move != nil {
+		we.DocumentChanges = append(we.DocumentChanges, *move)
+		from, _ := s.idx.location(move.OldURI)
+		to, _ := s.idx.location(move.NewURI)
+		s.idx.move(from, to)
+		if s.open[from] {
+			delete(s.open, from)
+			s.open[to] = true
+		}
+	}
+	return we
+}
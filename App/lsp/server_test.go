@@ -0,0 +1,267 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+const root = "/vault"
+
+// session sends requests to a server and collects what it wrote back, keyed by request id.
+// Notifications from the server are collected in order
+type session struct {
+	in  bytes.Buffer
+	ids int
+}
+
+func (s *session) send(method string, params any) int {
+	s.ids++
+	s.write(map[string]any{"jsonrpc": "2.0", "id": s.ids, "method": method, "params": params})
+	return s.ids
+}
+
+func (s *session) notify(method string, params any) {
+	s.write(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (s *session) write(msg any) {
+	body, _ := json.Marshal(msg)
+	fmt.Fprintf(&s.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+type reply struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+func (s *session) run(t *testing.T, srv *Server) (map[int]reply, []reply) {
+	out := bytes.Buffer{}
+	err := srv.Serve(&s.in, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies := map[int]reply{}
+	notes := []reply{}
+	r := bufio.NewReader(&out)
+	for {
+		raw, err := readRaw(r)
+		if err != nil {
+			break
+		}
+		rep := reply{}
+		json.Unmarshal(raw, &rep)
+		if rep.Method != "" {
+			notes = append(notes, rep)
+		} else {
+			replies[rep.ID] = rep
+		}
+	}
+	return replies, notes
+}
+
+func readRaw(r *bufio.Reader) ([]byte, error) {
+	length := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		fmt.Sscanf(line, "Content-Length: %d", &length)
+	}
+	body := make([]byte, length)
+	_, err := r.Read(body)
+	return body, err
+}
+
+func uri(loc string) string {
+	return "file://" + root + "/" + loc
+}
+
+func pos(uriStr string, line, char int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": uriStr},
+		"position":     map[string]any{"line": line, "character": char},
+	}
+}
+
+func testServer(t *testing.T) *Server {
+	texts := map[data.VaultLocation]string{
+		"a.md":         "# Alpha\n\nSee [[b]] and [[b#Part One|part]] and [[missing]] #todo\n\n## Second\n[[#Second]] `[[code]]`\n",
+		"b.md":         "# Bee\n\n## Part One\ntext #todo/later\n",
+		"sub/c.md":     "Up [[a#Gone]] and [[sub/d]] ",
+		"sub/d.md":     "",
+		"other/unused": "",
+	}
+	cache := data.VaultCache{}
+	for loc := range texts {
+		cache.Notes = append(cache.Notes, data.NoteCache{Path: loc})
+	}
+	srv, err := NewServer(root, cache, func(loc data.VaultLocation) ([]byte, error) {
+		return []byte(texts[loc]), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestNavigation(t *testing.T) {
+	s := &session{}
+	s.send("initialize", map[string]any{})
+	s.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri("a.md"), "text": testServer(t).idx.docs["a.md"].text}})
+	def := s.send("textDocument/definition", pos(uri("a.md"), 2, 20))
+	missing := s.send("textDocument/definition", pos(uri("a.md"), 2, 42))
+	refs := s.send("textDocument/references", pos(uri("b.md"), 0, 0))
+	notes := s.send("textDocument/completion", pos(uri("sub/c.md"), 0, 6))
+	headings := s.send("textDocument/completion", pos(uri("a.md"), 2, 22))
+	s.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri("sub/c.md")},
+		"contentChanges": []map[string]any{{"text": "#to"}},
+	})
+	tags := s.send("textDocument/completion", pos(uri("sub/c.md"), 0, 3))
+	unknown := s.send("workspace/symbol", map[string]any{})
+	s.send("shutdown", nil)
+	s.notify("exit", nil)
+
+	replies, notifications := s.run(t, testServer(t))
+
+	if !strings.Contains(string(replies[1].Result), `"definitionProvider":true`) {
+		t.Errorf("unexpected capabilities %s", replies[1].Result)
+	}
+
+	loc := Location{}
+	json.Unmarshal(replies[def].Result, &loc)
+	if loc.URI != uri("b.md") || loc.Range.Start != (Position{2, 3}) {
+		t.Errorf("expected definition at the Part One heading of b.md, got %+v", loc)
+	}
+	if string(replies[missing].Result) != "null" {
+		t.Errorf("broken links have no definition, got %s", replies[missing].Result)
+	}
+
+	locs := []Location{}
+	json.Unmarshal(replies[refs].Result, &locs)
+	if len(locs) != 2 || locs[0].URI != uri("a.md") || locs[0].Range.Start != (Position{2, 4}) {
+		t.Errorf("expected both links from a.md as references, got %+v", locs)
+	}
+
+	items := []CompletionItem{}
+	json.Unmarshal(replies[notes].Result, &items)
+	labels := []string{}
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	if strings.Join(labels, " ") != "a b unused c d" || items[3].Detail != "sub/c.md" {
+		t.Errorf("unexpected note completions %+v", items)
+	}
+	items = nil
+	json.Unmarshal(replies[headings].Result, &items)
+	if len(items) != 2 || items[1].Label != "Part One" || items[1].TextEdit.Range.Start != (Position{2, 18}) {
+		t.Errorf("unexpected heading completions %+v", items)
+	}
+	items = nil
+	json.Unmarshal(replies[tags].Result, &items)
+	if len(items) != 2 || items[0].Label != "todo" || items[1].Label != "todo/later" {
+		t.Errorf("unexpected tag completions %+v", items)
+	}
+	if replies[unknown].Error == nil || replies[unknown].Error.Code != codeMethodNotFound {
+		t.Errorf("expected unknown methods to fail, got %+v", replies[unknown])
+	}
+
+	// the open a.md is checked on open and again when c.md changes
+	diags := publishDiagnosticsParams{}
+	json.Unmarshal(notifications[0].Params, &diags)
+	if diags.URI != uri("a.md") || len(diags.Diagnostics) != 1 || !strings.Contains(diags.Diagnostics[0].Message, "missing") {
+		t.Errorf("expected the link to missing to be reported, got %+v", diags)
+	}
+}
+
+func TestDiagnosticsAndRename(t *testing.T) {
+	s := &session{}
+	s.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri("sub/c.md"), "text": "Up [[a#Gone]] and [[sub/d]] "}})
+	heading := s.send("textDocument/rename", map[string]any{
+		"textDocument": map[string]any{"uri": uri("b.md")},
+		"position":     map[string]any{"line": 2, "character": 5},
+		"newName":      "Part 1",
+	})
+	note := s.send("textDocument/rename", map[string]any{
+		"textDocument": map[string]any{"uri": uri("sub/c.md")},
+		"position":     map[string]any{"line": 0, "character": 22},
+		"newName":      "delta",
+	})
+	taken := s.send("textDocument/rename", map[string]any{
+		"textDocument": map[string]any{"uri": uri("a.md")},
+		"position":     map[string]any{"line": 1, "character": 0},
+		"newName":      "b",
+	})
+	outside := []int{}
+	for _, name := range []string{"", "../../x", "sub/../../x", "/"} {
+		outside = append(outside, s.send("textDocument/rename", map[string]any{
+			"textDocument": map[string]any{"uri": uri("a.md")},
+			"position":     map[string]any{"line": 1, "character": 0},
+			"newName":      name,
+		}))
+	}
+	s.notify("exit", nil)
+
+	srv := testServer(t)
+	replies, notifications := s.run(t, srv)
+
+	diags := publishDiagnosticsParams{}
+	json.Unmarshal(notifications[0].Params, &diags)
+	if len(diags.Diagnostics) != 1 || !strings.Contains(diags.Diagnostics[0].Message, `no heading "Gone"`) {
+		t.Errorf("expected the missing heading to be reported, got %+v", diags)
+	}
+
+	if !strings.Contains(string(replies[heading].Result), `"newText":"Part 1"`) {
+		t.Errorf("unexpected heading rename %s", replies[heading].Result)
+	}
+	if !strings.Contains(srv.idx.docs["a.md"].text, "[[b#Part 1|part]]") || srv.idx.docs["b.md"].headings[1].Text != "Part 1" {
+		t.Errorf("heading rename was not applied to the index: %q", srv.idx.docs["a.md"].text)
+	}
+
+	we := string(replies[note].Result)
+	if !strings.Contains(we, `"newText":"sub/delta"`) || !strings.Contains(we, `"newUri":"`+uri("sub/delta.md")+`"`) {
+		t.Errorf("unexpected note rename %s", we)
+	}
+	if _, ok := srv.idx.docs["sub/delta.md"]; !ok || srv.idx.docs["sub/c.md"].text != "Up [[a#Gone]] and [[sub/delta]] " {
+		t.Errorf("note rename was not applied to the index")
+	}
+	if replies[taken].Error == nil {
+		t.Errorf("renaming onto an existing note should fail")
+	}
+	for _, id := range outside {
+		if replies[id].Error == nil {
+			t.Errorf("renaming out of the vault should fail, got %s", replies[id].Result)
+		}
+	}
+	if _, ok := srv.idx.docs["a.md"]; !ok {
+		t.Errorf("a refused rename moved the note")
+	}
+}
+
+func TestPositions(t *testing.T) {
+	doc := newDocument("x.md", "é😀 [[a]]\nnext")
+	link := doc.links[0]
+	if p := doc.position(link.Start); p != (Position{0, 4}) {
+		t.Errorf("expected UTF-16 columns, got %+v", p)
+	}
+	if off := doc.offset(Position{0, 4}); off != link.Start {
+		t.Errorf("expected offset %d, got %d", link.Start, off)
+	}
+	if off := doc.offset(Position{1, 99}); off != len(doc.text) {
+		t.Errorf("expected offsets past the end of a line to clamp, got %d", off)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cowsed/Pumice/App/config"
+	"github.com/cowsed/Pumice/App/data"
+	"github.com/cowsed/Pumice/App/lsp"
+	"github.com/cowsed/Pumice/App/vaultcrypt"
+)
+
+const lspUsage = `usage: lsp [flags]
+
+Runs a language server for the notes of the vault on stdin and stdout.
+Encrypted notes are left out.
+`
+
+// lspMain runs the lsp subcommand and returns the exit code
+func lspMain(args []string) int {
+	opts := cliOptions{}
+	// stdout carries the protocol, so there is no -json
+	flags := commandFlags("lsp", lspUsage, &opts)
+	if !parseCommand(flags, args, 0, 0) {
+		return 2
+	}
+	opts.setupLogging()
+
+	root, err := filepath.Abs(opts.vault)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	vaultPath := data.OSPath(root)
+	filesys := vaultFS(vaultPath)
+	keys := vaultcrypt.NewKeyring()
+	mds, err := vaultNotes(filesys, keys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	cache := data.VaultCache{Version: config.VERSION, Notes: CacheAll(mds, filesys, nil, keys)}
+	srv, err := lsp.NewServer(root, cache, func(loc data.VaultLocation) ([]byte, error) {
+		return os.ReadFile(data.ToOSPath(vaultPath, loc))
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = srv.Serve(os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -9,14 +9,17 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"syscall"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/widget"
 	"github.com/cowsed/Pumice/App/config"
 	"github.com/cowsed/Pumice/App/data"
+	"github.com/cowsed/Pumice/App/vaultcrypt"
 	"github.com/knusbaum/go9p"
 	fs9p "github.com/knusbaum/go9p/fs"
 )
@@ -53,6 +56,10 @@ func allFilesOfType(filesys fs.FS, ext string) ([]string, error) {
 				return err
 			}
 			if info.IsDir() {
+				// .cache, .trash, .git and friends are not part of the vault
+				if fpath != "." && strings.HasPrefix(info.Name(), ".") {
+					return fs.SkipDir
+				}
 				return nil
 			}
 			if path.Ext(fpath) != ext {
@@ -73,6 +80,7 @@ type CacheResponse struct {
 	path  string
 	err   error
 	cache data.NoteCache
+	sum   contentSum
 }
 
 func NewCacheEntryErr(path string, err error) CacheResponse {
@@ -83,7 +91,7 @@ func NewCacheEntryErr(path string, err error) CacheResponse {
 	}
 }
 
-func readFiles(filesys fs.FS, in chan string, out chan CacheResponse) {
+func readFiles(filesys fs.FS, changes *changeRecorder, keys *vaultcrypt.Keyring, in chan string, out chan CacheResponse) {
 	i := 0
 	for path := range in {
 		i++
@@ -96,28 +104,54 @@ func readFiles(filesys fs.FS, in chan string, out chan CacheResponse) {
 
 		// Read file
 		bs, err := io.ReadAll(fil)
+		fil.Close()
 		if err != nil {
 			out <- NewCacheEntryErr(path, err)
 			continue
 		}
 
+		// encrypted notes are snapshotted as ciphertext
+		changes.Record(data.VaultLocation(path), bs)
+		sum := sumOf(bs)
+
+		encrypted := vaultcrypt.IsEncryptedPath(path)
+		if encrypted {
+			bs, err = keys.Decrypt(bs)
+			if err != nil {
+				out <- NewCacheEntryErr(path, err)
+				continue
+			}
+		}
+
 		// Parse File
 		cache, _, err := data.MakeNoteCache(data.VaultLocation(path), bs)
 		if err != nil {
 			out <- NewCacheEntryErr(path, err)
 			continue
 		}
+		cache.Encrypted = encrypted
 		log.Println(i, "Im looking at", path)
 		out <- CacheResponse{
 			path:  path,
 			err:   nil,
 			cache: cache,
+			sum:   sum,
 		}
 
 	}
 }
 
-func CacheAll(mds []string, filesys fs.FS) []data.NoteCache {
+func CacheAll(mds []string, filesys fs.FS, changes *changeRecorder, keys *vaultcrypt.Keyring) []data.NoteCache {
+	caches, _ := cacheAll(mds, filesys, changes, keys)
+	return caches
+}
+
+// cacheAll is CacheAll that also says what was on disk for each note
+func cacheAll(mds []string, filesys fs.FS, changes *changeRecorder, keys *vaultcrypt.Keyring) ([]data.NoteCache, map[data.VaultLocation]contentSum) {
+	sums := map[data.VaultLocation]contentSum{}
+	if len(mds) == 0 {
+		return []data.NoteCache{}, sums
+	}
 	num_threads := 1
 
 	in := make(chan string, num_threads)
@@ -125,7 +159,7 @@ func CacheAll(mds []string, filesys fs.FS) []data.NoteCache {
 
 	// Start workers
 	for i := 0; i < num_threads; i++ {
-		go readFiles(filesys, in, out)
+		go readFiles(filesys, changes, keys, in, out)
 	}
 
 	//Dump in
@@ -153,37 +187,135 @@ func CacheAll(mds []string, filesys fs.FS) []data.NoteCache {
 	values := []data.NoteCache{}
 	for _, v := range caches {
 		values = append(values, v.cache)
+		sums[v.cache.Path] = v.sum
 	}
 
-	return values
+	return values, sums
 }
 
 func main() {
-	flags := parseFlags()
+	if len(os.Args) > 1 {
+		if cmd, ok := findCommand(os.Args[1]); ok {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
+	// `pumice [flags] <vault>` predates the subcommands and still serves the vault
+	os.Exit(serveMain(os.Args[1:]))
+}
+
+// serveMain runs the serve subcommand and returns the exit code
+func serveMain(args []string) int {
+	opts := cliOptions{}
+	flags, err := parseFlags(args, &opts)
+	if err != nil {
+		return 2
+	}
+	opts.setupLogging()
 	slog.Info("Loaded flags", "flags", flags)
 
+	opts.loadConfig(flags.VaultPath)
+	cfg := opts.config
+	changes := setupVersioning(flags.VaultPath, cfg)
+	defer changes.Close()
+
 	// updates := LoadWorkspace(flags)
 	// fmt.Println(updates)
 
 	filesys := vaultFS(flags.VaultPath)
-	mds, err := allFilesOfType(filesys, ".md")
+	keys := unlockVault(flags)
+	mds, err := vaultNotes(filesys, keys)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
 
 	log.Println("There are ", len(mds), "markdown files here")
 
-	caches := CacheAll(mds, filesys)
+	caches, sums := cacheAll(mds, filesys, changes, keys)
+	changes.Annotate(caches)
 
-	log.Printf("Read %v of %v files", len(caches), len(mds))
+	vault := NewVault(flags.VaultPath, caches, changes, keys)
+	vault.sums = sums
 
-	vaultCache := makeVaultCacheFS(caches)
-	log.Println("serving")
-	go9p.PostSrv("vaultfs", vaultCache.Server())
+	err = vault.SaveCache()
+	if err != nil {
+		slog.Warn("Could not save cache", "err", err)
+	}
 
-	fmt.Println(caches[0])
+	log.Printf("Read %v of %v files", len(caches), len(mds))
 
+	vault.readOnly = flags.ReadOnly
+	if keys.Unlocked() && !vault.readOnly {
+		sealed, err := vault.Seal()
+		if err != nil {
+			slog.Error("Could not seal notes marked encrypted", "err", err)
+		} else if len(sealed) > 0 {
+			slog.Info("Sealed notes marked encrypted", "count", len(sealed))
+		}
+	}
+	vfs, srv := makeVaultCacheFS(vault, cfg)
+
+	served := make(chan error, len(flags.Listen)+2)
+	serving := map[string]any{"vault": flags.VaultPath}
+	if flags.HTTP != "" {
+		serving["http"] = httpAddr(flags.HTTP)
+		go func() {
+			served <- serveHTTP(flags.HTTP, &httpAPI{fs: vfs, events: vault.events})
+		}()
+	}
+	if len(flags.Listen) == 0 {
+		serving["service"] = "vaultfs"
+		go func() {
+			served <- postSrv("vaultfs", srv)
+		}()
+	} else {
+		listeners, err := listen(flags.Listen)
+		if err != nil {
+			slog.Error("Could not listen", "err", err)
+			return 1
+		}
+		bound := make([]string, len(listeners))
+		for i, l := range listeners {
+			bound[i] = l.Addr().String()
+			defer l.Close()
+			go func() {
+				served <- serveListener(l, srv)
+			}()
+		}
+		serving["listen"] = bound
+	}
+	code := opts.print(serving, func(w io.Writer) {
+		fmt.Fprintf(w, "serving %s", flags.VaultPath)
+		if bound, ok := serving["listen"].([]string); ok {
+			fmt.Fprintf(w, " on %s", strings.Join(bound, ", "))
+		} else {
+			fmt.Fprint(w, " as the service vaultfs")
+		}
+		if flags.HTTP != "" {
+			fmt.Fprintf(w, ", http on %s", serving["http"])
+		}
+		fmt.Fprintln(w)
+	})
+	if code != 0 {
+		return code
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-served:
+		if err != nil {
+			slog.Error("Serving vault failed", "err", err)
+			return 1
+		}
+	case <-vault.Done():
+		log.Println("quit requested")
+	case sig := <-signals:
+		log.Println("stopping on", sig)
+	}
+	return 0
 }
+
 func StringsFile(links []string) func() []byte {
 	return func() []byte {
 		b := strings.Builder{}
@@ -213,80 +345,132 @@ func makeAboutDir(filesys *fs9p.FS) fs9p.Dir {
 	return dir
 }
 
-func makeDirFromCache(cache data.NoteCache, filesys *fs9p.FS) *fs9p.StaticDir {
-	dir := fs9p.NewStaticDir(filesys.NewStat(string(cache.Path.Name()), User, Group, 0755))
-	tags := fs9p.NewDynamicFile(filesys.NewStat("tags", User, Group, 0444), StringsFile(cache.Tags.StringList()))
-	dir.AddChild(tags)
+// makeDirFromCache makes the directory of a note. moved is called when writing the body moved the note
+func makeDirFromCache(loc data.VaultLocation, vault *Vault, filesys *fs9p.FS, moved func()) *noteDir {
+	dir := &noteDir{
+		StaticDir: fs9p.NewStaticDir(filesys.NewStat(string(loc.Name()), User, Group, dataDirPerm(vault))),
+		vault:     vault,
+		loc:       loc,
+	}
+	note := func() data.NoteCache {
+		cache, _ := vault.Note(loc)
+		return cache
+	}
+	// what a file generated from the note alone holds changes with the note's version,
+	// what shows where links lead also when any note comes or goes
+	ofNote := func() uint64 {
+		return uint64(vault.Version(loc))
+	}
+	ofVault := func() uint64 {
+		return uint64(vault.Version(loc))<<32 | uint64(vault.Relinks())
+	}
+	addFile := func(name string, version func() uint64, content func() []byte) {
+		file := &noteFile{
+			File:  fs9p.NewDynamicFile(filesys.NewStat(name, User, Group, 0444), content),
+			vault: vault,
+			loc:   loc,
+		}
+		if version != nil {
+			file.length = versionedLength(version, content)
+		}
+		dir.AddChild(file)
+	}
+
+	body := newBodyFile(filesys, vault, loc)
+	body.moved = moved
+	dir.AddChild(&noteFile{
+		File:  body,
+		vault: vault,
+		loc:   loc,
+		length: versionedLength(ofNote, func() []byte {
+			bs, _ := vault.ReadBody(loc)
+			return bs
+		}),
+	})
 
-	outlinks := fs9p.NewDynamicFile(filesys.NewStat("outlinks", User, Group, 0444), func() []byte {
+	addFile("html", ofVault, func() []byte {
+		bs, err := vault.RenderHTML(loc)
+		if err != nil {
+			log.Println("Error rendering", loc, err)
+		}
+		return bs
+	})
+
+	addFile("tags", ofNote, func() []byte {
+		return StringsFile(note().Tags.StringList())()
+	})
+
+	addFile("outlinks", ofVault, func() []byte {
 		buf := bytes.Buffer{}
-		for _, link := range cache.Outlinks {
+		for _, link := range note().Outlinks {
 			buf.WriteString(string(link))
 			buf.WriteByte('\n')
 		}
 		return buf.Bytes()
 	})
-	dir.AddChild(outlinks)
 
-	metadata := fs9p.NewDynamicFile(filesys.NewStat("metadata", User, Group, 0444), func() []byte {
-		bs, err := json.MarshalIndent(cache.Metadata, "", "  ")
+	addFile("inlinks", ofVault, func() []byte {
+		return LinksFile(vault.Inlinks(loc))()
+	})
+
+	addFile("metadata", ofNote, func() []byte {
+		bs, err := json.MarshalIndent(note().Metadata, "", "  ")
 		if err != nil {
 			log.Println("Error marshalling", err)
 		}
 		return bs
 	})
-	dir.AddChild(metadata)
 
-	return dir
-}
-
-type FSSTate struct {
-	fs        *fs9p.FS
-	cachedirs map[data.VaultLocation]*fs9p.StaticDir
-	dataRoot  *fs9p.StaticDir
-}
+	addFile("callouts", ofNote, func() []byte {
+		buf := bytes.Buffer{}
+		for _, c := range note().Callouts {
+			buf.WriteString(c.String())
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes()
+	})
 
-func (ft *FSSTate) GetOrMakeDir(path data.VaultLocation) *fs9p.StaticDir {
-	if path == "." {
-		return ft.dataRoot
-	}
-	if dir, exists := ft.cachedirs[path]; exists {
-		return dir
+	if repo := vault.changes.Repo(); repo != nil {
+		// git history moves with commits rather than with the vault, so it has no length
+		addFile("history", nil, func() []byte {
+			revs, err := repo.History(loc)
+			if err != nil {
+				log.Println("Error reading history", err)
+			}
+			buf := bytes.Buffer{}
+			for _, rev := range revs {
+				buf.WriteString(rev.String())
+				buf.WriteByte('\n')
+			}
+			return buf.Bytes()
+		})
 	}
-	name := path.Name()
-	parentDir := path.Dir()
-	parent := ft.GetOrMakeDir(parentDir)
-	me := fs9p.NewStaticDir(ft.fs.NewStat(string(name), User, Group, 0755))
-	parent.AddChild(me)
-	ft.cachedirs[path] = me
-	return me
+
+	return dir
 }
 
-func makeDataDir(caches []data.NoteCache, filesys *fs9p.FS) fs9p.Dir {
-	dir := fs9p.NewStaticDir(filesys.NewStat("data", User, Group, 0755))
-	vfst := FSSTate{
-		fs:        filesys,
+func makeVaultCacheFS(vault *Vault, cfg Config) (*fs9p.FS, go9p.Srv) {
+	vfst := &FSSTate{
+		vault:     vault,
+		template:  cfg.NoteTemplate,
 		cachedirs: map[data.VaultLocation]*fs9p.StaticDir{},
-		dataRoot:  dir,
+		notedirs:  map[data.VaultLocation]*noteDir{},
 	}
-
-	for _, cache := range caches {
-		parentPath := cache.Path.Dir()
-
-		noteDir := makeDirFromCache(cache, filesys)
-
-		parentDir := vfst.GetOrMakeDir(parentPath)
-		parentDir.AddChild(noteDir)
-
+	opts := []fs9p.Option{}
+	if !vault.readOnly {
+		opts = append(opts,
+			fs9p.WithCreateFile(vfst.createFile),
+			fs9p.WithCreateDir(vfst.createDir),
+			fs9p.WithRemoveFile(vfst.remove),
+		)
 	}
-	return dir
-}
-
-func makeVaultCacheFS(caches []data.NoteCache) *fs9p.FS {
-	vfs, root := fs9p.NewFS(User, Group, 0755)
+	vfs, root := fs9p.NewFS(User, Group, 0755, opts...)
+	vfst.fs = vfs
+	queries := newQueryTree(vfs, vault, vfst)
+	vfs.WalkFail = queries.walkFail
 
 	AboutDir := makeAboutDir(vfs)
-	DataDir := makeDataDir(caches, vfs)
+	DataDir := vfst.makeDataDir()
 
 	ActionDir := fs9p.NewStaticDir(vfs.NewStat("actions", User, Group, 0755))
 	searchFile := fs9p.NewDynamicFile(vfs.NewStat("search", User, Group, 0444), func() []byte { return []byte("coming soon\n") })
@@ -294,7 +478,11 @@ func makeVaultCacheFS(caches []data.NoteCache) *fs9p.FS {
 
 	root.AddChild(AboutDir)
 	root.AddChild(DataDir)
+	root.AddChild(newEventsFile(vfs, vault.events))
+	root.AddChild(newCtlFile(vfs, vault, vfst))
 	root.AddChild(ActionDir)
+	root.AddChild(queries.root)
+	root.AddChild(makeMetaDir(vfs, vault, vfst))
 
-	return vfs
+	return vfs, &querySrv{Srv: vfs.Server(), tree: queries}
 }
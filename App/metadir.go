@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/cowsed/Pumice/App/data"
+	fs9p "github.com/knusbaum/go9p/fs"
+)
+
+var metaDirName = "meta"
+
+// a value can hold characters a file name cannot
+var facetNameEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// facetName is the file name for a key or value, escaped so it cannot clash with the summary file
+// called reserved that sits beside it
+func facetName(value, reserved string) string {
+	switch value {
+	case ".", "..":
+		return strings.ReplaceAll(value, ".", "%2E")
+	case reserved:
+		return fmt.Sprintf("%%%02X", value[0]) + value[1:]
+	}
+	return facetNameEscaper.Replace(value)
+}
+
+func vaultFacets(vault *Vault) []data.Facet {
+	return data.Facets(vault.Notes())
+}
+
+func findFacet(vault *Vault, key string) (data.Facet, bool) {
+	for _, f := range vaultFacets(vault) {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return data.Facet{}, false
+}
+
+// makeMetaDir serves meta/<key>/<value>/ with the notes whose front matter gives key that value.
+// meta/keys sums up every key and meta/<key>/counts how often each value is used.
+// A key called keys is served as meta/%6Beys and a value called counts as %63ounts
+func makeMetaDir(filesys *fs9p.FS, vault *Vault, tree *FSSTate) fs9p.Dir {
+	keys := fs9p.NewDynamicFile(filesys.NewStat("keys", User, Group, 0444), func() []byte {
+		buf := bytes.Buffer{}
+		buf.WriteString("key\ttypes\tvalues\tnotes\n")
+		for _, f := range vaultFacets(vault) {
+			fmt.Fprintf(&buf, "%s\t%s\t%d\t%d\n", f.Key, strings.Join(f.Types, ","), len(f.Values), f.Notes)
+		}
+		return buf.Bytes()
+	})
+	return newListingDir(filesys, metaDirName, func() []listing {
+		entries := []listing{{name: "keys", key: "keys", make: func() fs9p.FSNode { return keys }}}
+		for _, f := range vaultFacets(vault) {
+			key := f.Key
+			entries = append(entries, listing{name: facetName(key, "keys"), key: "key", make: func() fs9p.FSNode {
+				return makeFacetDir(filesys, vault, tree, key)
+			}})
+		}
+		return entries
+	})
+}
+
+func makeFacetDir(filesys *fs9p.FS, vault *Vault, tree *FSSTate, key string) fs9p.Dir {
+	counts := fs9p.NewDynamicFile(filesys.NewStat("counts", User, Group, 0444), func() []byte {
+		f, _ := findFacet(vault, key)
+		buf := bytes.Buffer{}
+		for _, value := range f.SortedValues() {
+			fmt.Fprintf(&buf, "%d\t%s\n", len(f.Values[value]), value)
+		}
+		return buf.Bytes()
+	})
+	return newListingDir(filesys, facetName(key, "keys"), func() []listing {
+		entries := []listing{{name: "counts", key: "counts", make: func() fs9p.FSNode { return counts }}}
+		f, _ := findFacet(vault, key)
+		for _, value := range f.SortedValues() {
+			entries = append(entries, listing{name: facetName(value, "counts"), key: "value", make: func() fs9p.FSNode {
+				return notesDir(filesys, tree, facetName(value, "counts"), ".", func() []data.VaultLocation {
+					f, _ := findFacet(vault, key)
+					return f.Values[value]
+				})
+			}})
+		}
+		return entries
+	})
+}
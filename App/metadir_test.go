@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	fs9p "github.com/knusbaum/go9p/fs"
+)
+
+func TestMetaNamesDoNotHideSummaries(t *testing.T) {
+	vault, ft := testVault(t, map[string]string{"a.md": "---\nkeys: counts\n---\nbody"})
+	meta := makeMetaDir(ft.fs, vault, ft)
+
+	children := meta.Children()
+	if _, ok := children["keys"].(fs9p.File); !ok {
+		t.Fatalf("Expected meta/keys to stay the summary, got %v", children["keys"])
+	}
+	key, ok := children["%6Beys"].(fs9p.Dir)
+	if !ok {
+		t.Fatalf("Expected the key keys to be escaped, got %v", children)
+	}
+
+	values := key.Children()
+	if _, ok := values["counts"].(fs9p.File); !ok {
+		t.Fatalf("Expected counts to stay the summary, got %v", values["counts"])
+	}
+	value, ok := values["%63ounts"].(fs9p.Dir)
+	if !ok {
+		t.Fatalf("Expected the value counts to be escaped, got %v", values)
+	}
+	if _, ok := value.Children()["a.md"]; !ok {
+		t.Fatal("Expected a.md under the escaped value")
+	}
+}
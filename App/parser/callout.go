@@ -0,0 +1,209 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindCallout is the kind of an Obsidian style callout (`> [!note] Title`)
+var KindCallout = ast.NewNodeKind("Callout")
+
+type FoldState int
+
+const (
+	// Not foldable
+	FoldNone FoldState = iota
+	// Foldable and expanded, `[!note]+`
+	FoldOpen
+	// Foldable and collapsed, `[!note]-`
+	FoldClosed
+)
+
+func (f FoldState) String() string {
+	switch f {
+	case FoldOpen:
+		return "+"
+	case FoldClosed:
+		return "-"
+	}
+	return ""
+}
+
+// Callout replaces the blockquote it was written as. Its children are the
+// blocks of the quote after the `[!type]` line
+type Callout struct {
+	ast.BaseBlock
+	// lowercased, `[!Warning]` has type "warning"
+	CalloutType string
+	// Title as written. Empty if the callout has no title
+	Title string
+	Fold  FoldState
+	// the `[!type] title` line in the source
+	Marker text.Segment
+}
+
+func (c *Callout) Kind() ast.NodeKind {
+	return KindCallout
+}
+
+// DisplayTitle is the title shown for the callout. Callouts without a title use their type
+func (c *Callout) DisplayTitle() string {
+	if c.Title != "" {
+		return c.Title
+	}
+	if c.CalloutType == "" {
+		return ""
+	}
+	return strings.ToUpper(c.CalloutType[:1]) + c.CalloutType[1:]
+}
+
+func (c *Callout) Dump(source []byte, level int) {
+	ast.DumpHelper(c, source, level, map[string]string{
+		"Type":  c.CalloutType,
+		"Title": c.Title,
+		"Fold":  fmt.Sprintf("%q", c.Fold.String()),
+	}, nil)
+}
+
+var calloutMarker = regexp.MustCompile(`^\[!([A-Za-z0-9_-]+)\]([+-]?)(?:[ \t]+(.*?))?[ \t]*$`)
+
+type calloutTransformer struct{}
+
+func (ct *calloutTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	quotes := []*ast.Blockquote{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if bq, ok := n.(*ast.Blockquote); ok && entering {
+			quotes = append(quotes, bq)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, bq := range quotes {
+		para, ok := bq.FirstChild().(*ast.Paragraph)
+		if !ok || para.Lines().Len() == 0 {
+			continue
+		}
+		marker := para.Lines().At(0)
+		line := strings.TrimRight(string(marker.Value(source)), "\r\n")
+		match := calloutMarker.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		callout := &Callout{
+			CalloutType: strings.ToLower(match[1]),
+			Title:       match[3],
+			Marker:      marker,
+		}
+		switch match[2] {
+		case "+":
+			callout.Fold = FoldOpen
+		case "-":
+			callout.Fold = FoldClosed
+		}
+
+		dropFirstLine(para, marker)
+		if para.ChildCount() == 0 {
+			bq.RemoveChild(bq, para)
+		}
+
+		for c := bq.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			callout.AppendChild(callout, c)
+			c = next
+		}
+		bq.Parent().ReplaceChild(bq.Parent(), bq, callout)
+	}
+}
+
+// dropFirstLine removes the inline nodes that came from the marker line of a paragraph
+func dropFirstLine(para *ast.Paragraph, marker text.Segment) {
+	for c := para.FirstChild(); c != nil; {
+		start, ok := inlineStart(c)
+		if !ok || start >= marker.Stop {
+			break
+		}
+		next := c.NextSibling()
+		para.RemoveChild(para, c)
+		c = next
+	}
+	lines := text.NewSegments()
+	for i := 1; i < para.Lines().Len(); i++ {
+		lines.Append(para.Lines().At(i))
+	}
+	para.SetLines(lines)
+}
+
+// inlineStart finds where an inline node starts in the source
+func inlineStart(n ast.Node) (int, bool) {
+	if t, ok := n.(*ast.Text); ok {
+		return t.Segment.Start, true
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if start, ok := inlineStart(c); ok {
+			return start, true
+		}
+	}
+	return 0, false
+}
+
+type calloutRenderer struct{}
+
+func (cr *calloutRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindCallout, cr.renderCallout)
+}
+
+func (cr *calloutRenderer) renderCallout(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	c := node.(*Callout)
+	tag := "div"
+	if c.Fold != FoldNone {
+		tag = "details"
+	}
+	if !entering {
+		w.WriteString("</div>\n</" + tag + ">\n")
+		return ast.WalkContinue, nil
+	}
+
+	w.WriteString("<" + tag + ` class="callout" data-callout="`)
+	w.Write(util.EscapeHTML([]byte(c.CalloutType)))
+	w.WriteString(`"`)
+	if c.Fold == FoldOpen {
+		w.WriteString(" open")
+	}
+	w.WriteString(">\n")
+
+	titleTag := "div"
+	if c.Fold != FoldNone {
+		titleTag = "summary"
+	}
+	w.WriteString("<" + titleTag + ` class="callout-title">`)
+	w.Write(util.EscapeHTML([]byte(c.DisplayTitle())))
+	w.WriteString("</" + titleTag + ">\n")
+	w.WriteString(`<div class="callout-content">` + "\n")
+	return ast.WalkContinue, nil
+}
+
+type callouts struct{}
+
+// Callouts turns blockquotes starting with `[!type]` into Callout nodes
+var Callouts goldmark.Extender = &callouts{}
+
+func (e *callouts) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&calloutTransformer{}, 500),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&calloutRenderer{}, 500),
+	))
+}
+
+var _ renderer.NodeRenderer = &calloutRenderer{}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+func findCallouts(doc ast.Node) []*Callout {
+	found := []*Callout{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if c, ok := n.(*Callout); ok && entering {
+			found = append(found, c)
+		}
+		return ast.WalkContinue, nil
+	})
+	return found
+}
+
+func TestCallouts(t *testing.T) {
+	src := []byte(`> [!warning] Hot stove
+> Do not touch
+
+> [!Todo]-
+> - buy eggs
+
+> [!note]+ Outer
+> > [!tip] Inner
+> > nested
+
+> just a quote
+`)
+	doc := VaultParser().Parse(text.NewReader(src))
+	got := findCallouts(doc)
+
+	expected := []struct {
+		typ   string
+		title string
+		fold  FoldState
+	}{
+		{"warning", "Hot stove", FoldNone},
+		{"todo", "", FoldClosed},
+		{"note", "Outer", FoldOpen},
+		{"tip", "Inner", FoldNone},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d callouts, got %d", len(expected), len(got))
+	}
+	for i, e := range expected {
+		c := got[i]
+		if c.CalloutType != e.typ || c.Title != e.title || c.Fold != e.fold {
+			t.Errorf("Callout %d: expected %v, got type %q title %q fold %v", i, e, c.CalloutType, c.Title, c.Fold)
+		}
+	}
+
+	if got[1].DisplayTitle() != "Todo" {
+		t.Errorf("Expected untitled callout to be titled by its type, got %q", got[1].DisplayTitle())
+	}
+
+	body, ok := got[0].FirstChild().(*ast.Paragraph)
+	if !ok {
+		t.Fatalf("Expected callout body paragraph, got %T", got[0].FirstChild())
+	}
+	if string(body.Text(src)) != "Do not touch" {
+		t.Errorf("Expected marker line to be removed from the body, got %q", body.Text(src))
+	}
+	if _, ok := got[1].FirstChild().(*ast.List); !ok {
+		t.Errorf("Expected empty marker paragraph to be dropped, got %T", got[1].FirstChild())
+	}
+
+	quotes := 0
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if _, ok := n.(*ast.Blockquote); ok && entering {
+			quotes++
+		}
+		return ast.WalkContinue, nil
+	})
+	if quotes != 1 {
+		t.Errorf("Expected plain blockquote to be left alone, found %d quotes", quotes)
+	}
+}
@@ -13,17 +13,24 @@ import (
 )
 
 func VaultParser() parser.Parser {
-	md := goldmark.New(
+	return VaultMarkdown(nil).Parser()
+}
+
+// VaultMarkdown is the goldmark configuration used for notes.
+// resolver decides where wikilinks point when rendering, nil uses the wikilink default
+func VaultMarkdown(resolver wikilink.Resolver) goldmark.Markdown {
+	return goldmark.New(
 		goldmark.WithExtensions(
 			extension.GFM,
 			meta.New(meta.WithStoresInDocument()),
 			mathjax.MathJax,
-			&wikilink.Extender{},
+			&wikilink.Extender{Resolver: resolver},
 			&hashtag.Extender{
 				Resolver: nil,
 				Variant:  hashtag.ObsidianVariant,
 			},
 			emoji.Emoji,
+			Callouts,
 		),
 		goldmark.WithParserOptions(
 			parser.WithAutoHeadingID(),
@@ -33,6 +40,4 @@ func VaultParser() parser.Parser {
 			html.WithXHTML(),
 		),
 	)
-
-	return md.Parser()
 }
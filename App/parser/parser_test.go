@@ -0,0 +1,33 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+type prefixResolver struct{}
+
+func (prefixResolver) ResolveWikilink(n *wikilink.Node) ([]byte, error) {
+	if string(n.Target) == "nowhere" {
+		return nil, nil
+	}
+	return []byte("/notes/" + string(n.Target)), nil
+}
+
+func TestVaultMarkdownResolver(t *testing.T) {
+	buf := bytes.Buffer{}
+	err := VaultMarkdown(prefixResolver{}).Convert([]byte("[[here]] and [[nowhere]]\n"), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	if !strings.Contains(got, `<a href="/notes/here">here</a>`) {
+		t.Errorf("resolved link missing from %q", got)
+	}
+	if strings.Contains(got, "nowhere</a>") || !strings.Contains(got, "nowhere") {
+		t.Errorf("unresolved link should be plain text in %q", got)
+	}
+}
@@ -0,0 +1,238 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokTerm tokenKind = iota
+	tokOpen
+	tokClose
+)
+
+type token struct {
+	kind tokenKind
+	// set for `key:value` terms
+	key    string
+	hasKey bool
+	value  string
+	quoted bool
+	negate bool
+}
+
+// operator reports whether the token is a bare AND, OR or NOT
+func (t token) operator() string {
+	if t.kind != tokTerm || t.hasKey || t.quoted || t.negate {
+		return ""
+	}
+	switch t.value {
+	case "AND", "OR", "NOT":
+		return t.value
+	}
+	return ""
+}
+
+func lex(s string) ([]token, error) {
+	toks := []token{}
+	rs := []rune(s)
+	i := 0
+	readValue := func() (string, bool, error) {
+		if i < len(rs) && rs[i] == '"' {
+			end := i + 1
+			for end < len(rs) && rs[end] != '"' {
+				end++
+			}
+			if end == len(rs) {
+				return "", false, fmt.Errorf("%w: unterminated quote", ErrSyntax)
+			}
+			value := string(rs[i+1 : end])
+			i = end + 1
+			return value, true, nil
+		}
+		start := i
+		for i < len(rs) && !unicode.IsSpace(rs[i]) && !strings.ContainsRune(`()"`, rs[i]) {
+			i++
+		}
+		return string(rs[start:i]), false, nil
+	}
+
+	for i < len(rs) {
+		switch {
+		case unicode.IsSpace(rs[i]):
+			i++
+		case rs[i] == '(':
+			toks = append(toks, token{kind: tokOpen})
+			i++
+		case rs[i] == ')':
+			toks = append(toks, token{kind: tokClose})
+			i++
+		default:
+			tok := token{kind: tokTerm}
+			if rs[i] == '-' {
+				tok.negate = true
+				i++
+			}
+			value, quoted, err := readValue()
+			if err != nil {
+				return nil, err
+			}
+			if key, rest, found := strings.Cut(value, ":"); found && !quoted && key != "" {
+				tok.key, tok.hasKey = key, true
+				if rest == "" {
+					rest, quoted, err = readValue()
+					if err != nil {
+						return nil, err
+					}
+				}
+				value = rest
+			}
+			if value == "" && !quoted {
+				return nil, fmt.Errorf("%w: empty term", ErrSyntax)
+			}
+			tok.value, tok.quoted = value, quoted
+			toks = append(toks, tok)
+		}
+	}
+	return toks, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.toks) {
+		return token{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+// Parse reads a query. Terms are `tag:name`, `path:prefix`, `key:value` for front matter
+// and bare words or "quoted phrases" for note text. Terms are combined with AND, OR, NOT
+// and parentheses. Terms next to each other are ANDed and a leading `-` negates a term
+func Parse(s string) (Query, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("%w: empty query", ErrSyntax)
+	}
+	p := &parser{toks: toks}
+	q, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("%w: unexpected )", ErrSyntax)
+	}
+	return q, nil
+}
+
+func (p *parser) or() (Query, error) {
+	first, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	qs := Or{first}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.operator() != "OR" {
+			break
+		}
+		p.pos++
+		next, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, next)
+	}
+	if len(qs) == 1 {
+		return first, nil
+	}
+	return qs, nil
+}
+
+func (p *parser) and() (Query, error) {
+	first, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	qs := And{first}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind == tokClose || tok.operator() == "OR" {
+			break
+		}
+		if tok.operator() == "AND" {
+			p.pos++
+		}
+		next, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, next)
+	}
+	if len(qs) == 1 {
+		return first, nil
+	}
+	return qs, nil
+}
+
+func (p *parser) not() (Query, error) {
+	tok, ok := p.peek()
+	if ok && tok.operator() == "NOT" {
+		p.pos++
+		q, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return Not{q}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Query, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("%w: query ends early", ErrSyntax)
+	}
+	p.pos++
+	switch {
+	case tok.kind == tokOpen:
+		q, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if closing, ok := p.peek(); !ok || closing.kind != tokClose {
+			return nil, fmt.Errorf("%w: missing )", ErrSyntax)
+		}
+		p.pos++
+		return q, nil
+	case tok.kind == tokClose:
+		return nil, fmt.Errorf("%w: unexpected )", ErrSyntax)
+	case tok.operator() != "":
+		return nil, fmt.Errorf("%w: %s needs a term", ErrSyntax, tok.operator())
+	}
+
+	var q Query
+	switch {
+	case !tok.hasKey:
+		q = Text{tok.value}
+	case strings.EqualFold(tok.key, "tag"):
+		q = Tag{strings.TrimPrefix(tok.value, "#")}
+	case strings.EqualFold(tok.key, "path"):
+		q = Path{tok.value}
+	default:
+		q = Meta{Key: tok.key, Value: tok.value}
+	}
+	if tok.negate {
+		q = Not{q}
+	}
+	return q, nil
+}
@@ -0,0 +1,168 @@
+// Package query parses and runs searches over the notes of a vault, e.g. `tag:recipe AND time:quick`
+package query
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+var ErrSyntax = errors.New("query syntax error")
+
+// Query decides whether a note matches. body is only called by queries that search note text
+type Query interface {
+	Match(note data.NoteCache, body func() []byte) bool
+	String() string
+}
+
+// Tag matches notes with the tag or one nested under it, `tag:food` matches #food/bread
+type Tag struct {
+	Tag string
+}
+
+func (q Tag) Match(note data.NoteCache, body func() []byte) bool {
+	want := strings.ToLower(q.Tag)
+	for _, tag := range note.Tags.List() {
+		have := strings.ToLower(strings.TrimPrefix(string(tag), "#"))
+		if have == want || strings.HasPrefix(have, want+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (q Tag) String() string { return "tag:" + quote(q.Tag) }
+
+// Path matches notes whose vault path starts with the prefix
+type Path struct {
+	Prefix string
+}
+
+func (q Path) Match(note data.NoteCache, body func() []byte) bool {
+	prefix := strings.ToLower(strings.TrimPrefix(q.Prefix, "/"))
+	return strings.HasPrefix(strings.ToLower(string(note.Path)), prefix)
+}
+
+func (q Path) String() string { return "path:" + quote(q.Prefix) }
+
+// Meta matches notes whose front matter key has the value. Lists match if any element does
+type Meta struct {
+	Key   string
+	Value string
+}
+
+func (q Meta) Match(note data.NoteCache, body func() []byte) bool {
+	for key, value := range note.Metadata {
+		if strings.EqualFold(key, q.Key) && metaEquals(value, q.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func metaEquals(value data.MetaDataValue, want string) bool {
+	if list, ok := value.([]interface{}); ok {
+		for _, v := range list {
+			if metaEquals(v, want) {
+				return true
+			}
+		}
+		return false
+	}
+	return value != nil && strings.EqualFold(fmt.Sprint(value), want)
+}
+
+func (q Meta) String() string { return quote(q.Key) + ":" + quote(q.Value) }
+
+// Text matches notes whose body contains the text, ignoring case
+type Text struct {
+	Text string
+}
+
+func (q Text) Match(note data.NoteCache, body func() []byte) bool {
+	return bytes.Contains(bytes.ToLower(body()), bytes.ToLower([]byte(q.Text)))
+}
+
+func (q Text) String() string { return quote(q.Text) }
+
+type And []Query
+
+func (q And) Match(note data.NoteCache, body func() []byte) bool {
+	for _, sub := range q {
+		if !sub.Match(note, body) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q And) String() string { return join(q, " AND ") }
+
+type Or []Query
+
+func (q Or) Match(note data.NoteCache, body func() []byte) bool {
+	for _, sub := range q {
+		if sub.Match(note, body) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q Or) String() string { return join(q, " OR ") }
+
+type Not struct {
+	Query Query
+}
+
+func (q Not) Match(note data.NoteCache, body func() []byte) bool {
+	return !q.Query.Match(note, body)
+}
+
+func (q Not) String() string { return "NOT " + group(q.Query) }
+
+func join(qs []Query, sep string) string {
+	parts := make([]string, len(qs))
+	for i, q := range qs {
+		parts[i] = group(q)
+	}
+	return strings.Join(parts, sep)
+}
+
+func group(q Query) string {
+	switch q.(type) {
+	case And, Or:
+		return "(" + q.String() + ")"
+	}
+	return q.String()
+}
+
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\"():") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// Run lists the notes that match q, in the order given
+func Run(q Query, notes []data.NoteCache, body func(data.VaultLocation) []byte) []data.NoteCache {
+	found := []data.NoteCache{}
+	for _, note := range notes {
+		var read []byte
+		loaded := false
+		lazy := func() []byte {
+			if !loaded {
+				read = body(note.Path)
+				loaded = true
+			}
+			return read
+		}
+		if q.Match(note, lazy) {
+			found = append(found, note)
+		}
+	}
+	return found
+}
@@ -0,0 +1,81 @@
+package query
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+func notes(t *testing.T, sources map[string]string) ([]data.NoteCache, map[data.VaultLocation][]byte) {
+	caches := []data.NoteCache{}
+	bodies := map[data.VaultLocation][]byte{}
+	for _, name := range []string{"soup.md", "recipes/bread.md", "recipes/salad.md", "journal.md"} {
+		src, ok := sources[name]
+		if !ok {
+			continue
+		}
+		cache, _, err := data.MakeNoteCache(data.VaultLocation(name), []byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		caches = append(caches, cache)
+		bodies[cache.Path] = []byte(src)
+	}
+	return caches, bodies
+}
+
+func TestRun(t *testing.T) {
+	caches, bodies := notes(t, map[string]string{
+		"soup.md":          "---\nrecipe_type: meal\ntags: [tag1]\n---\nHot tomato soup\n",
+		"recipes/bread.md": "---\nrecipe_type: [side, snack]\n---\n#tag1/baking sourdough\n",
+		"recipes/salad.md": "---\nrecipe_type: meal\n---\nGreen #tag2\n",
+		"journal.md":       "Made soup today #tag1\n",
+	})
+	body := func(loc data.VaultLocation) []byte { return bodies[loc] }
+
+	cases := []struct {
+		query    string
+		expected []data.VaultLocation
+	}{
+		{"tag:tag1 AND recipe_type:meal", []data.VaultLocation{"soup.md"}},
+		{"tag:tag1", []data.VaultLocation{"soup.md", "recipes/bread.md", "journal.md"}},
+		{"recipe_type:snack", []data.VaultLocation{"recipes/bread.md"}},
+		{"soup -tag:tag2", []data.VaultLocation{"soup.md", "journal.md"}},
+		{"path:recipes/ AND NOT (tag:tag2 OR sourdough)", []data.VaultLocation{}},
+		{`"tomato soup" OR tag:#tag2`, []data.VaultLocation{"soup.md", "recipes/salad.md"}},
+	}
+	for _, c := range cases {
+		q, err := Parse(c.query)
+		if err != nil {
+			t.Errorf("%s: %v", c.query, err)
+			continue
+		}
+		got := Run(q, caches, body)
+		if len(got) != len(c.expected) {
+			t.Errorf("%s: expected %v, got %d notes", c.query, c.expected, len(got))
+			continue
+		}
+		for i, note := range got {
+			if note.Path != c.expected[i] {
+				t.Errorf("%s: expected %v at %d, got %v", c.query, c.expected[i], i, note.Path)
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	q, err := Parse(`tag:a b OR NOT "c d" key:"e f"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.String() != `(tag:a AND b) OR (NOT "c d" AND key:"e f")` {
+		t.Errorf("unexpected parse %s", q)
+	}
+
+	for _, bad := range []string{"", "(tag:a", "tag:a)", "a AND", "\"open", "NOT"} {
+		if _, err := Parse(bad); !errors.Is(err, ErrSyntax) {
+			t.Errorf("%q: expected a syntax error, got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/cowsed/Pumice/App/data"
+	"github.com/cowsed/Pumice/App/query"
+	"github.com/knusbaum/go9p"
+	fs9p "github.com/knusbaum/go9p/fs"
+	"github.com/knusbaum/go9p/proto"
+)
+
+var queryDirName = "query"
+
+// queryTree serves query/. Walking to `query/<query>` runs the query and makes a directory
+// of the matching notes, laid out as in data/. It is dropped again once no fid refers to it
+type queryTree struct {
+	fs    *fs9p.FS
+	vault *Vault
+	data  *FSSTate
+	root  *fs9p.StaticDir
+	// path from the root of every fid, per connection
+	fids map[go9p.Conn]map[uint32][]string
+	// how many fids are inside each query directory
+	refs map[string]int
+	sync.Mutex
+}
+
+func newQueryTree(filesys *fs9p.FS, vault *Vault, tree *FSSTate) *queryTree {
+	return &queryTree{
+		fs:    filesys,
+		vault: vault,
+		data:  tree,
+		root:  fs9p.NewStaticDir(filesys.NewStat(queryDirName, User, Group, 0555)),
+		fids:  map[go9p.Conn]map[uint32][]string{},
+		refs:  map[string]int{},
+	}
+}
+
+// walkFail makes the directory for a query the first time it is walked to
+func (qt *queryTree) walkFail(filesys *fs9p.FS, parent fs9p.Dir, name string) (fs9p.FSNode, error) {
+	if parent != fs9p.Dir(qt.root) {
+		return nil, nil
+	}
+	q, err := query.Parse(name)
+	if err != nil {
+		return nil, err
+	}
+	results := qt.vault.Search(q)
+	slog.Info("Ran query", "query", q, "results", len(results))
+
+	locs := make([]data.VaultLocation, len(results))
+	for i, note := range results {
+		locs[i] = note.Path
+	}
+	return notesDir(filesys, qt.data, name, ".", func() []data.VaultLocation { return locs }), nil
+}
+
+// queryOf names the query directory a path is inside of, if any
+func queryOf(path []string) (string, bool) {
+	if len(path) < 2 || path[0] != queryDirName {
+		return "", false
+	}
+	return path[1], true
+}
+
+// track records where a fid now points. The caller must hold the lock
+func (qt *queryTree) track(c go9p.Conn, fid uint32, path []string) {
+	qt.release(c, fid)
+	if qt.fids[c] == nil {
+		qt.fids[c] = map[uint32][]string{}
+	}
+	qt.fids[c][fid] = path
+	if name, ok := queryOf(path); ok {
+		qt.refs[name]++
+	}
+}
+
+// release forgets a fid, dropping the query directory it was in if it was the last one. The caller must hold the lock
+func (qt *queryTree) release(c go9p.Conn, fid uint32) {
+	path, ok := qt.fids[c][fid]
+	if !ok {
+		return
+	}
+	delete(qt.fids[c], fid)
+	if name, ok := queryOf(path); ok {
+		qt.unref(name)
+	}
+}
+
+// unref drops the query directory name once nothing refers to it. The caller must hold the lock
+func (qt *queryTree) unref(name string) {
+	qt.refs[name]--
+	if qt.refs[name] <= 0 {
+		delete(qt.refs, name)
+		qt.root.DeleteChild(name)
+	}
+}
+
+// dropConn releases every fid of a connection that went away
+func (qt *queryTree) dropConn(c go9p.Conn) {
+	qt.Lock()
+	defer qt.Unlock()
+	for fid := range qt.fids[c] {
+		qt.release(c, fid)
+	}
+	delete(qt.fids, c)
+}
+
+// querySrv follows fids through the tree so query directories can be dropped after their last clunk
+type querySrv struct {
+	go9p.Srv
+	tree *queryTree
+}
+
+func (s *querySrv) Attach(c go9p.Conn, t *proto.TAttach) (proto.FCall, error) {
+	reply, err := s.Srv.Attach(c, t)
+	if _, ok := reply.(*proto.RAttach); ok {
+		s.tree.Lock()
+		s.tree.track(c, t.Fid, []string{})
+		s.tree.Unlock()
+	}
+	return reply, err
+}
+
+func (s *querySrv) Walk(c go9p.Conn, t *proto.TWalk) (proto.FCall, error) {
+	// the walk may run a query, so the lock is not held across it. Instead the query directory
+	// the walk heads into is referred to until the new fid is tracked, so it cannot be dropped meanwhile
+	s.tree.Lock()
+	from := s.tree.fids[c][t.Fid]
+	into, pinned := "", false
+	if len(t.Wname) == 0 || t.Wname[0] != ".." {
+		into, pinned = queryOf(append(append([]string{}, from...), t.Wname...))
+	}
+	if pinned {
+		s.tree.refs[into]++
+	}
+	s.tree.Unlock()
+
+	reply, err := s.Srv.Walk(c, t)
+	if failed, ok := reply.(*proto.RError); ok && pinned && strings.HasSuffix(failed.Ename, "already exists") {
+		// another walk made the same query directory first, this one finds it there now
+		reply, err = s.Srv.Walk(c, t)
+	}
+
+	s.tree.Lock()
+	defer s.tree.Unlock()
+	if pinned {
+		defer s.tree.unref(into)
+	}
+	walked, ok := reply.(*proto.RWalk)
+	if !ok {
+		return reply, err
+	}
+	path := append([]string{}, from...)
+	switch {
+	case len(t.Wname) > 0 && t.Wname[0] == "..":
+		// the server only ever walks one step up
+		if len(path) > 0 {
+			path = path[:len(path)-1]
+		}
+	case int(walked.Nwqid) == len(t.Wname):
+		path = append(path, t.Wname...)
+	default:
+		// partial walks leave newfid unused
+		return reply, err
+	}
+	s.tree.track(c, t.Newfid, path)
+	return reply, err
+}
+
+func (s *querySrv) Clunk(c go9p.Conn, t *proto.TClunk) (proto.FCall, error) {
+	reply, err := s.Srv.Clunk(c, t)
+	s.tree.Lock()
+	s.tree.release(c, t.Fid)
+	s.tree.Unlock()
+	return reply, err
+}
+
+func (s *querySrv) Remove(c go9p.Conn, t *proto.TRemove) (proto.FCall, error) {
+	reply, err := s.Srv.Remove(c, t)
+	s.tree.Lock()
+	s.tree.release(c, t.Fid)
+	s.tree.Unlock()
+	return reply, err
+}
+
+// session serves one client. done releases whatever fids the client left behind
+func (s *querySrv) session() (go9p.Srv, func()) {
+	conns := &sessionConns{}
+	return &sessionSrv{querySrv: s, conns: conns}, func() {
+		conns.Lock()
+		defer conns.Unlock()
+		for _, c := range conns.list {
+			s.tree.dropConn(c)
+		}
+	}
+}
+
+type sessionConns struct {
+	list []go9p.Conn
+	sync.Mutex
+}
+
+type sessionSrv struct {
+	*querySrv
+	conns *sessionConns
+}
+
+func (s *sessionSrv) NewConn() go9p.Conn {
+	c := s.querySrv.NewConn()
+	s.conns.Lock()
+	s.conns.list = append(s.conns.list, c)
+	s.conns.Unlock()
+	return c
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/knusbaum/go9p/proto"
+)
+
+func TestQueryDirsOutliveConcurrentWalks(t *testing.T) {
+	vault, _ := testVault(t, map[string]string{"a.md": "apple", "b.md": "banana"})
+	_, srv := makeVaultCacheFS(vault, NewConfig())
+	qs := srv.(*querySrv)
+	c := qs.NewConn()
+	if reply, _ := qs.Attach(c, &proto.TAttach{Header: proto.Header{Type: proto.Tattach}, Fid: 0, Afid: ^uint32(0), Uname: User}); !isAttach(reply) {
+		t.Fatalf("Expected to attach, got %v", reply)
+	}
+
+	var wg sync.WaitGroup
+	for fid := uint32(1); fid <= 8; fid++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			walk := &proto.TWalk{Header: proto.Header{Type: proto.Twalk}, Fid: 0, Newfid: fid, Nwname: 2, Wname: []string{queryDirName, "apple"}}
+			if reply, _ := qs.Walk(c, walk); !isWalk(reply) {
+				t.Errorf("Expected fid %d to walk into the query, got %v", fid, reply)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := qs.tree.refs["apple"]; n != 8 {
+		t.Fatalf("Expected 8 fids in the query, got %d", n)
+	}
+
+	for fid := uint32(1); fid <= 8; fid++ {
+		qs.Clunk(c, &proto.TClunk{Header: proto.Header{Type: proto.Tclunk}, Fid: fid})
+	}
+	if _, ok := qs.tree.root.Children()["apple"]; ok {
+		t.Fatal("Expected the query directory to be dropped after the last clunk")
+	}
+}
+
+func isAttach(reply proto.FCall) bool {
+	_, ok := reply.(*proto.RAttach)
+	return ok
+}
+
+func isWalk(reply proto.FCall) bool {
+	walked, ok := reply.(*proto.RWalk)
+	return ok && walked.Nwqid == 2
+}
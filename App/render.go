@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"github.com/cowsed/Pumice/App/data"
+	"github.com/cowsed/Pumice/App/parser"
+	"github.com/cowsed/Pumice/App/snapshot"
+	"go.abhg.dev/goldmark/wikilink"
+)
+
+// htmlCache keeps the last rendering of each note.
+// It is keyed by the note's content, where its links resolve to and whether those notes exist, so edits to other notes that change those links invalidate it too
+type htmlCache struct {
+	rendered map[data.VaultLocation]renderedNote
+	sync.Mutex
+}
+
+type renderedNote struct {
+	key  string
+	html []byte
+}
+
+func newHTMLCache() *htmlCache {
+	return &htmlCache{rendered: map[data.VaultLocation]renderedNote{}}
+}
+
+// treeResolver points wikilinks at the html file of the linked note, relative to the note being rendered
+type treeResolver struct {
+	from  data.VaultLocation
+	links data.LinkResolver
+	notes map[data.VaultLocation]struct{}
+}
+
+func (tr treeResolver) ResolveWikilink(n *wikilink.Node) ([]byte, error) {
+	dest := ""
+	if len(n.Target) > 0 {
+		to := tr.links.Resolve(tr.from, data.Link(n.Target))
+		if _, ok := tr.notes[to]; !ok {
+			// render the link text alone, there is nothing to point at
+			return nil, nil
+		}
+		dest = relativeLink(tr.from, to)
+	}
+	if len(n.Fragment) > 0 {
+		dest += "#" + string(n.Fragment)
+	}
+	return []byte(dest), nil
+}
+
+// relativeLink is the path from the directory of note from to the html file of note to
+func relativeLink(from, to data.VaultLocation) string {
+	fromParts := strings.Split(string(from), "/")
+	toParts := strings.Split(string(to)+"/html", "/")
+	common := 0
+	for common < len(fromParts) && common < len(toParts)-1 && fromParts[common] == toParts[common] {
+		common++
+	}
+	return strings.Repeat("../", len(fromParts)-common) + strings.Join(toParts[common:], "/")
+}
+
+// RenderHTML renders a note with its wikilinks pointing into the served tree
+func (v *Vault) RenderHTML(loc data.VaultLocation) ([]byte, error) {
+	body, err := v.ReadBody(loc)
+	if err != nil {
+		return nil, err
+	}
+	notes := v.Notes()
+	locs := make([]data.VaultLocation, len(notes))
+	known := map[data.VaultLocation]struct{}{}
+	for i, n := range notes {
+		locs[i] = n.Path
+		known[n.Path] = struct{}{}
+	}
+	note, _ := v.Note(loc)
+	keyParts := []string{snapshot.Hash(body)}
+	for _, out := range note.Outlinks {
+		// a link to a missing note renders differently once the note is made
+		if _, ok := known[out]; ok {
+			keyParts = append(keyParts, string(out))
+		} else {
+			keyParts = append(keyParts, "?"+string(out))
+		}
+	}
+	key := strings.Join(keyParts, "\x00")
+
+	v.html.Lock()
+	cached, ok := v.html.rendered[loc]
+	v.html.Unlock()
+	if ok && cached.key == key {
+		return cached.html, nil
+	}
+
+	resolver := treeResolver{from: loc, links: data.NewLinkResolver(locs), notes: known}
+
+	buf := bytes.Buffer{}
+	err = parser.VaultMarkdown(resolver).Convert(body, &buf)
+	if err != nil {
+		return nil, err
+	}
+
+	v.html.Lock()
+	v.html.rendered[loc] = renderedNote{key: key, html: buf.Bytes()}
+	v.html.Unlock()
+	return buf.Bytes(), nil
+}
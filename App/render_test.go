@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderFollowsLinkTargets(t *testing.T) {
+	vault, _ := testVault(t, map[string]string{"a.md": "see [[b]]"})
+	render := func() string {
+		t.Helper()
+		html, err := vault.RenderHTML("a.md")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(html)
+	}
+
+	if html := render(); strings.Contains(html, "href") {
+		t.Fatalf("Expected a link to a missing note to have no target, got %s", html)
+	}
+	if _, err := vault.Create("b.md", []byte("bee")); err != nil {
+		t.Fatal(err)
+	}
+	if html := render(); !strings.Contains(html, `href="../b.md/html"`) {
+		t.Fatalf("Expected the link to point at the new note, got %s", html)
+	}
+	if _, err := vault.Trash("b.md"); err != nil {
+		t.Fatal(err)
+	}
+	if html := render(); strings.Contains(html, "href") {
+		t.Fatalf("Expected the link to lose its target with the note, got %s", html)
+	}
+}
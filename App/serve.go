@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/knusbaum/go9p"
+)
+
+// parseDialString turns `tcp!host!port` or `unix!/path` into a network and address for net.Listen.
+// A host of `*` listens on every interface, which listen warns about
+func parseDialString(addr string) (network, address string, err error) {
+	parts := strings.Split(addr, "!")
+	switch {
+	case len(parts) == 3 && parts[0] == "tcp":
+		host := parts[1]
+		if host == "*" {
+			host = ""
+		}
+		return "tcp", net.JoinHostPort(host, parts[2]), nil
+	case len(parts) == 2 && parts[0] == "unix" && parts[1] != "":
+		return "unix", parts[1], nil
+	}
+	return "", "", fmt.Errorf("bad listen address %q, want tcp!host!port or unix!/path", addr)
+}
+
+// listen opens every address. Stale unix sockets left behind by a previous run are replaced
+func listen(addrs []string) ([]net.Listener, error) {
+	listeners := []net.Listener{}
+	for _, addr := range addrs {
+		network, address, err := parseDialString(addr)
+		if err == nil && network == "unix" {
+			err = removeStaleSocket(address)
+		}
+		var l net.Listener
+		if err == nil {
+			l, err = net.Listen(network, address)
+		}
+		if err == nil && network == "tcp" && allInterfaces(address) {
+			slog.Warn("Listening on every interface, anyone who can reach it can use the vault without authentication", "addr", addr)
+		}
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// allInterfaces says whether a tcp address listens beyond this machine
+func allInterfaces(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return host == "" || (ip != nil && ip.IsUnspecified())
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	return os.Remove(path)
+}
+
+// sessionServer is a server that wants to know when a client goes away
+type sessionServer interface {
+	session() (go9p.Srv, func())
+}
+
+// postSrv posts srv as the plan9port service name. Clients share one connection through
+// 9pserve, which clunks the fids of each client that hangs up
+func postSrv(name string, srv go9p.Srv) error {
+	client, done := srv, func() {}
+	if ss, ok := srv.(sessionServer); ok {
+		client, done = ss.session()
+	}
+	defer done()
+	return go9p.PostSrv(name, client)
+}
+
+// serveListener serves srv to every connection on l until l is closed
+func serveListener(l net.Listener, srv go9p.Srv) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			slog.Info("Client connected", "addr", l.Addr(), "remote", conn.RemoteAddr())
+			client, done := srv, func() {}
+			if ss, ok := srv.(sessionServer); ok {
+				client, done = ss.session()
+			}
+			defer done()
+			err := go9p.ServeReadWriter(bufio.NewReader(conn), conn, client)
+			if err != nil {
+				slog.Debug("Client disconnected", "remote", conn.RemoteAddr(), "err", err)
+			}
+		}()
+	}
+}
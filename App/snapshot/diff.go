@@ -0,0 +1,115 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diff produces a line based diff between a and b.
+// Unchanged lines are prefixed with a space, removed lines with '-' and added lines with '+'
+func Diff(nameA, nameB string, a, b []byte) string {
+	linesA := splitLines(string(a))
+	linesB := splitLines(string(b))
+
+	out := strings.Builder{}
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+	i, j := 0, 0
+	for _, m := range commonLines(linesA, linesB) {
+		for ; i < m[0]; i++ {
+			out.WriteString("-" + linesA[i] + "\n")
+		}
+		for ; j < m[1]; j++ {
+			out.WriteString("+" + linesB[j] + "\n")
+		}
+		out.WriteString(" " + linesA[i] + "\n")
+		i++
+		j++
+	}
+	for ; i < len(linesA); i++ {
+		out.WriteString("-" + linesA[i] + "\n")
+	}
+	for ; j < len(linesB); j++ {
+		out.WriteString("+" + linesB[j] + "\n")
+	}
+	return out.String()
+}
+
+// commonLines is a longest common subsequence of a and b as pairs of line indexes, in order.
+// It is found with Hirschberg's algorithm, which keeps two rows of the table instead of
+// all of it so large notes do not need len(a)*len(b) memory
+func commonLines(a, b []string) [][2]int {
+	matched := [][2]int{}
+	var split func(offA, offB int, a, b []string)
+	split = func(offA, offB int, a, b []string) {
+		// lines the two ends share are always part of it, and most edits leave many
+		for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+			matched = append(matched, [2]int{offA, offB})
+			a, b = a[1:], b[1:]
+			offA++
+			offB++
+		}
+		end := [][2]int{}
+		for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+			end = append(end, [2]int{offA + len(a) - 1, offB + len(b) - 1})
+			a, b = a[:len(a)-1], b[:len(b)-1]
+		}
+		defer func() {
+			for k := len(end) - 1; k >= 0; k-- {
+				matched = append(matched, end[k])
+			}
+		}()
+
+		switch {
+		case len(a) == 0 || len(b) == 0:
+			return
+		case len(a) == 1:
+			for j := range b {
+				if b[j] == a[0] {
+					matched = append(matched, [2]int{offA, offB + j})
+					return
+				}
+			}
+			return
+		}
+
+		mid := len(a) / 2
+		forward := lcsLengths(len(a[:mid]), len(b), func(i, j int) bool { return a[i] == b[j] })
+		rest := a[mid:]
+		backward := lcsLengths(len(rest), len(b), func(i, j int) bool { return rest[len(rest)-1-i] == b[len(b)-1-j] })
+		// b is cut where the halves of a together keep the most lines in common
+		cut := 0
+		for k := range forward {
+			if forward[k]+backward[len(b)-k] > forward[cut]+backward[len(b)-cut] {
+				cut = k
+			}
+		}
+		split(offA, offB, a[:mid], b[:cut])
+		split(offA+mid, offB+cut, a[mid:], b[cut:])
+	}
+	split(0, 0, a, b)
+	return matched
+}
+
+// lcsLengths is the last row of the LCS table of n lines against m: entry j is the length
+// of the longest common subsequence of the n lines and the first j of the m
+func lcsLengths(n, m int, same func(i, j int) bool) []int {
+	prev, row := make([]int, m+1), make([]int, m+1)
+	for i := 0; i < n; i++ {
+		for j := 0; j < m; j++ {
+			if same(i, j) {
+				row[j+1] = prev[j] + 1
+			} else {
+				row[j+1] = max(prev[j+1], row[j])
+			}
+		}
+		prev, row = row, prev
+	}
+	return prev
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,301 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+var ErrNoSuchVersion = errors.New("no such version")
+var ErrAmbiguousVersion = errors.New("version prefix matches more than one snapshot")
+
+const indexFilename = "index.json"
+const objectsFolder = "objects"
+
+// Retention limits how many snapshots are kept for each note.
+// Zero values mean unlimited. The newest snapshot of a note is always kept.
+type Retention struct {
+	MaxCount   int `json:"max_count"`
+	MaxAgeDays int `json:"max_age_days"`
+}
+
+func DefaultRetention() Retention {
+	return Retention{
+		MaxCount:   50,
+		MaxAgeDays: 90,
+	}
+}
+
+type Version struct {
+	Hash string    `json:"hash"`
+	Time time.Time `json:"time"`
+	Size int       `json:"size"`
+}
+
+func (v Version) Short() string {
+	if len(v.Hash) < 12 {
+		return v.Hash
+	}
+	return v.Hash[:12]
+}
+
+// Store keeps content addressed copies of notes.
+// Blobs live in objects/xx/yyyy... and index.json maps each note to its versions (oldest first)
+type Store struct {
+	root      string
+	retention Retention
+	index     map[data.VaultLocation][]Version
+	now       func() time.Time
+	sync.Mutex
+}
+
+func Open(root string, retention Retention) (*Store, error) {
+	err := os.MkdirAll(filepath.Join(root, objectsFolder), 0777)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		root:      root,
+		retention: retention,
+		index:     map[data.VaultLocation][]Version{},
+		now:       time.Now,
+	}
+
+	bs, err := os.ReadFile(filepath.Join(root, indexFilename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bs, &s.index)
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshot index: %w", err)
+	}
+	return s, nil
+}
+
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Store) objectPath(hash string) string {
+	return filepath.Join(s.root, objectsFolder, hash[:2], hash[2:])
+}
+
+// Record stores content as the newest version of loc if it differs from the last recorded version.
+// The returned bool reports whether a new snapshot was made
+func (s *Store) Record(loc data.VaultLocation, content []byte) (Version, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	hash := Hash(content)
+	versions := s.index[loc]
+	if len(versions) > 0 && versions[len(versions)-1].Hash == hash {
+		return versions[len(versions)-1], false, nil
+	}
+
+	err := s.writeObject(hash, content)
+	if err != nil {
+		return Version{}, false, err
+	}
+
+	v := Version{
+		Hash: hash,
+		Time: s.now(),
+		Size: len(content),
+	}
+	s.index[loc] = append(versions, v)
+	removed := s.prune(loc)
+
+	err = s.saveIndex()
+	if err != nil {
+		return v, true, err
+	}
+	return v, true, s.collect(removed)
+}
+
+func (s *Store) writeObject(hash string, content []byte) error {
+	opath := s.objectPath(hash)
+	if _, err := os.Stat(opath); err == nil {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(opath), 0777)
+	if err != nil {
+		return err
+	}
+	return data.WriteAtomic(opath, content, 0444)
+}
+
+// prune applies the retention policy to loc and returns the hashes that were dropped
+func (s *Store) prune(loc data.VaultLocation) []string {
+	versions := s.index[loc]
+	keepFrom := 0
+	if s.retention.MaxCount > 0 && len(versions) > s.retention.MaxCount {
+		keepFrom = len(versions) - s.retention.MaxCount
+	}
+	if s.retention.MaxAgeDays > 0 {
+		cutoff := s.now().Add(-time.Duration(s.retention.MaxAgeDays) * 24 * time.Hour)
+		for keepFrom < len(versions)-1 && versions[keepFrom].Time.Before(cutoff) {
+			keepFrom++
+		}
+	}
+	removed := []string{}
+	for _, v := range versions[:keepFrom] {
+		removed = append(removed, v.Hash)
+	}
+	s.index[loc] = append([]Version{}, versions[keepFrom:]...)
+	return removed
+}
+
+// collect deletes objects no note refers to anymore
+func (s *Store) collect(hashes []string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	inUse := map[string]struct{}{}
+	for _, versions := range s.index {
+		for _, v := range versions {
+			inUse[v.Hash] = struct{}{}
+		}
+	}
+	for _, h := range hashes {
+		if _, used := inUse[h]; used {
+			continue
+		}
+		err := os.Remove(s.objectPath(h))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) saveIndex() error {
+	bs, err := json.Marshal(s.index)
+	if err != nil {
+		return err
+	}
+	return data.WriteAtomic(filepath.Join(s.root, indexFilename), bs, 0644)
+}
+
+// Versions lists the snapshots of loc, newest first
+func (s *Store) Versions(loc data.VaultLocation) []Version {
+	s.Lock()
+	defer s.Unlock()
+	versions := append([]Version{}, s.index[loc]...)
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	return versions
+}
+
+// Notes lists every note that has at least one snapshot
+func (s *Store) Notes() []data.VaultLocation {
+	s.Lock()
+	defer s.Unlock()
+	locs := make([]data.VaultLocation, 0, len(s.index))
+	for loc := range s.index {
+		locs = append(locs, loc)
+	}
+	sort.Slice(locs, func(i, j int) bool { return locs[i] < locs[j] })
+	return locs
+}
+
+// Resolve finds the version of loc whose hash starts with ref
+func (s *Store) Resolve(loc data.VaultLocation, ref string) (Version, error) {
+	s.Lock()
+	defer s.Unlock()
+	var found *Version
+	for i, v := range s.index[loc] {
+		if !strings.HasPrefix(v.Hash, ref) || ref == "" {
+			continue
+		}
+		if found != nil && found.Hash != v.Hash {
+			return Version{}, fmt.Errorf("%s: %w", ref, ErrAmbiguousVersion)
+		}
+		found = &s.index[loc][i]
+	}
+	if found == nil {
+		return Version{}, fmt.Errorf("%s@%s: %w", loc, ref, ErrNoSuchVersion)
+	}
+	return *found, nil
+}
+
+func (s *Store) Read(loc data.VaultLocation, ref string) ([]byte, error) {
+	v, err := s.Resolve(loc, ref)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(s.objectPath(v.Hash))
+}
+
+// Diff compares two versions of loc.
+func (s *Store) Diff(loc data.VaultLocation, from, to string) (string, error) {
+	a, err := s.Read(loc, from)
+	if err != nil {
+		return "", err
+	}
+	b, err := s.Read(loc, to)
+	if err != nil {
+		return "", err
+	}
+	return Diff(string(loc)+"@"+from, string(loc)+"@"+to, a, b), nil
+}
+
+// Restore writes a version of loc back into the vault.
+// The current content is snapshotted first so a restore can itself be undone
+func (s *Store) Restore(vault data.OSPath, loc data.VaultLocation, ref string) (Version, error) {
+	content, err := s.Read(loc, ref)
+	if err != nil {
+		return Version{}, err
+	}
+	notePath := data.ToOSPath(vault, loc)
+	current, err := os.ReadFile(notePath)
+	if err == nil {
+		_, _, err = s.Record(loc, current)
+		if err != nil {
+			return Version{}, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return Version{}, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(notePath), 0777)
+	if err != nil {
+		return Version{}, err
+	}
+	err = data.WriteAtomic(notePath, content, 0644)
+	if err != nil {
+		return Version{}, err
+	}
+	v, _, err := s.Record(loc, content)
+	return v, err
+}
+
+// Forget drops every snapshot of loc, for notes whose content must no longer be kept in the clear
+func (s *Store) Forget(loc data.VaultLocation) error {
+	s.Lock()
+	defer s.Unlock()
+	removed := []string{}
+	for _, v := range s.index[loc] {
+		removed = append(removed, v.Hash)
+	}
+	delete(s.index, loc)
+	err := s.saveIndex()
+	if err != nil {
+		return err
+	}
+	return s.collect(removed)
+}
@@ -0,0 +1,190 @@
+package snapshot
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+func TestRecordSkipsUnchanged(t *testing.T) {
+	s, err := Open(t.TempDir(), Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, changed, err := s.Record("Note.md", []byte("one"))
+	if err != nil || !changed {
+		t.Fatalf("Expected first record to snapshot, changed: %v err: %v", changed, err)
+	}
+	_, changed, err = s.Record("Note.md", []byte("one"))
+	if err != nil || changed {
+		t.Fatalf("Expected identical content to be skipped, changed: %v err: %v", changed, err)
+	}
+	_, changed, err = s.Record("Note.md", []byte("two"))
+	if err != nil || !changed {
+		t.Fatalf("Expected new content to snapshot, changed: %v err: %v", changed, err)
+	}
+
+	versions := s.Versions("Note.md")
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %v", versions)
+	}
+	if versions[0].Hash != Hash([]byte("two")) {
+		t.Errorf("Expected newest version first, got %v", versions)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	root := t.TempDir()
+	s, err := Open(root, Retention{MaxCount: 2, MaxAgeDays: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	s.Record("Note.md", []byte("a"))
+	s.Record("Note.md", []byte("b"))
+	s.Record("Note.md", []byte("c"))
+	if got := len(s.Versions("Note.md")); got != 2 {
+		t.Fatalf("Expected count retention to keep 2 versions, got %d", got)
+	}
+	if _, err := os.Stat(s.objectPath(Hash([]byte("a")))); !os.IsNotExist(err) {
+		t.Errorf("Expected pruned object to be removed, got %v", err)
+	}
+
+	now = now.Add(48 * time.Hour)
+	s.Record("Note.md", []byte("d"))
+	versions := s.Versions("Note.md")
+	if len(versions) != 1 || versions[0].Hash != Hash([]byte("d")) {
+		t.Errorf("Expected age retention to keep only the newest version, got %v", versions)
+	}
+
+	reopened, err := Open(root, Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(reopened.Versions("Note.md")); got != 1 {
+		t.Errorf("Expected index to persist, got %d versions", got)
+	}
+}
+
+func TestRestoreAndDiff(t *testing.T) {
+	vault := t.TempDir()
+	s, err := Open(filepath.Join(vault, ".cache", "snapshots"), Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loc data.VaultLocation = "dir/Note.md"
+	old, _, _ := s.Record(loc, []byte("line1\nline2\n"))
+
+	notePath := data.ToOSPath(data.OSPath(vault), loc)
+	os.MkdirAll(filepath.Dir(notePath), 0777)
+	os.WriteFile(notePath, []byte("line1\nchanged\n"), 0644)
+
+	_, err = s.Restore(data.OSPath(vault), loc, old.Short())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, _ := os.ReadFile(notePath)
+	if string(bs) != "line1\nline2\n" {
+		t.Errorf("Expected restored content, got %q", bs)
+	}
+
+	versions := s.Versions(loc)
+	if len(versions) != 3 {
+		t.Fatalf("Expected overwritten content to be snapshotted before restoring, got %v", versions)
+	}
+
+	diff, err := s.Diff(loc, versions[1].Hash, versions[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "-changed\n") || !strings.Contains(diff, "+line2\n") || !strings.Contains(diff, " line1\n") {
+		t.Errorf("Unexpected diff:\n%s", diff)
+	}
+}
+
+// sides rebuilds the two inputs of a diff and counts its unchanged lines
+func sides(diff string) (a, b string, same int) {
+	for _, line := range strings.SplitAfter(diff, "\n")[2:] {
+		switch {
+		case line == "":
+		case line[0] == ' ':
+			a, b, same = a+line[1:], b+line[1:], same+1
+		case line[0] == '-':
+			a += line[1:]
+		case line[0] == '+':
+			b += line[1:]
+		}
+	}
+	return a, b, same
+}
+
+func TestDiffKeepsLongestCommonLines(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	text := func() string {
+		out := ""
+		for n := r.Intn(12); n > 0; n-- {
+			out += string(rune('a'+r.Intn(4))) + "\n"
+		}
+		return out
+	}
+	for range 500 {
+		a, b := text(), text()
+		gotA, gotB, same := sides(Diff("a", "b", []byte(a), []byte(b)))
+		if gotA != a || gotB != b {
+			t.Fatalf("Diff of %q and %q does not rebuild them: %q, %q", a, b, gotA, gotB)
+		}
+		// the quadratic table, fine for notes this small
+		la, lb := splitLines(a), splitLines(b)
+		lcs := make([][]int, len(la)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(lb)+1)
+		}
+		for i := len(la) - 1; i >= 0; i-- {
+			for j := len(lb) - 1; j >= 0; j-- {
+				if la[i] == lb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		if same != lcs[0][0] {
+			t.Fatalf("Diff of %q and %q keeps %d lines, want %d", a, b, same, lcs[0][0])
+		}
+	}
+}
+
+func TestDiffLargeNotes(t *testing.T) {
+	a, b := strings.Builder{}, strings.Builder{}
+	for i := range 5000 {
+		fmt.Fprintf(&a, "line %d\n", i)
+		if i%10 == 0 {
+			fmt.Fprintf(&b, "changed %d\n", i)
+		} else {
+			fmt.Fprintf(&b, "line %d\n", i)
+		}
+	}
+	before := runtime.MemStats{}
+	runtime.ReadMemStats(&before)
+	diff := Diff("a", "b", []byte(a.String()), []byte(b.String()))
+	after := runtime.MemStats{}
+	runtime.ReadMemStats(&after)
+
+	// a full table would be 5000*5000 ints, 200MB
+	if used := after.TotalAlloc - before.TotalAlloc; used > 20<<20 {
+		t.Errorf("Diffing 5000 lines allocated %d bytes", used)
+	}
+	if _, _, same := sides(diff); same != 4500 {
+		t.Errorf("Expected 4500 unchanged lines, got %d", same)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/cowsed/Pumice/App/data"
+	fs9p "github.com/knusbaum/go9p/fs"
+	"github.com/knusbaum/go9p/proto"
+)
+
+// noteStat fills in the parts of a stat that follow the note a node was made from
+func noteStat(st *proto.Stat, vault *Vault, loc data.VaultLocation) {
+	if mtime, err := vault.ModTime(loc); err == nil {
+		st.Mtime = uint32(mtime.Unix())
+		st.Atime = st.Mtime
+	}
+	st.Qid.Vers = vault.Version(loc)
+}
+
+// noteFile is a file of a note directory whose stat tracks the note.
+// length reports how much a read of the file would return, files without one report 0
+type noteFile struct {
+	fs9p.File
+	vault  *Vault
+	loc    data.VaultLocation
+	length func() uint64
+}
+
+func (f *noteFile) Stat() proto.Stat {
+	st := f.File.Stat()
+	noteStat(&st, f.vault, f.loc)
+	st.Length = 0
+	if f.length != nil {
+		st.Length = f.length()
+	}
+	return st
+}
+
+// versionedLength remembers the length of content until version changes
+func versionedLength(version func() uint64, content func() []byte) func() uint64 {
+	var lock sync.Mutex
+	known := false
+	var seen uint64
+	var length uint64
+	return func() uint64 {
+		current := version()
+		lock.Lock()
+		defer lock.Unlock()
+		if !known || current != seen {
+			length = uint64(len(content()))
+			seen = current
+			known = true
+		}
+		return length
+	}
+}
+
+// noteDir is the directory of a note, stamped with the note's mtime and version
+type noteDir struct {
+	*fs9p.StaticDir
+	vault *Vault
+	loc   data.VaultLocation
+}
+
+func (d *noteDir) Stat() proto.Stat {
+	st := d.StaticDir.Stat()
+	noteStat(&st, d.vault, d.loc)
+	return st
+}
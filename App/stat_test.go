@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	fs9p "github.com/knusbaum/go9p/fs"
+	"github.com/knusbaum/go9p/proto"
+)
+
+func TestNoteFileLengths(t *testing.T) {
+	vault, ft := testVault(t, map[string]string{"a.md": "alpha #tag [[b]]"})
+	dir := ft.notedirs["a.md"]
+	file := func(name string) fs9p.File {
+		return dir.Children()[name].(fs9p.File)
+	}
+	// checks every file reports as its length what reading it gives
+	check := func() {
+		t.Helper()
+		for _, name := range []string{"body", "html", "tags", "outlinks", "inlinks", "metadata", "callouts"} {
+			f := file(name)
+			if err := f.Open(1, proto.Oread); err != nil {
+				t.Fatal(err)
+			}
+			content, err := f.Read(1, 0, 1<<20)
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+			f.Close(1)
+			if got := f.Stat().Length; got != uint64(len(content)) {
+				t.Errorf("Expected %s to be %d bytes, it says %d", name, len(content), got)
+			}
+		}
+	}
+
+	check()
+	if err := vault.WriteBody("a.md", []byte("alphabet #tag #more [[b]]")); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	// other notes change where links lead and what links here
+	if _, err := vault.Create("b.md", []byte("[[a]]")); err != nil {
+		t.Fatal(err)
+	}
+	if file("inlinks").Stat().Length == 0 {
+		t.Fatal("Expected inlinks to grow with the new link")
+	}
+	check()
+}
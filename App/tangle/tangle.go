@@ -0,0 +1,196 @@
+// Package tangle assembles code blocks marked with a `file=path` attribute into source files
+package tangle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+// FileAttr is the info string attribute naming the file a block belongs to
+const FileAttr = "file"
+
+var ErrBadPath = errors.New("tangle path must be relative, stay inside the output directory and not name a hidden file or a note")
+
+// badTarget reports whether a cleaned target could leave the output directory or,
+// as the output is the vault by default, overwrite a note or something like .git or .cache
+func badTarget(clean string) bool {
+	if path.IsAbs(clean) {
+		return true
+	}
+	for _, part := range strings.Split(clean, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	lower := strings.ToLower(clean)
+	return strings.HasSuffix(lower, ".md") || strings.HasSuffix(lower, ".md.enc")
+}
+
+type Chunk struct {
+	Note  data.VaultLocation
+	Block data.CodeBlock
+}
+
+// File is one output file and the blocks it is made of, in order
+type File struct {
+	Path   string
+	Chunks []Chunk
+}
+
+func chunkText(c Chunk) string {
+	text := c.Block.Content
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text
+}
+
+func (f File) Content() []byte {
+	buf := bytes.Buffer{}
+	for _, c := range f.Chunks {
+		buf.WriteString(chunkText(c))
+	}
+	return buf.Bytes()
+}
+
+// Plan collects the blocks of every note by target file.
+// Notes are taken in path order and blocks in the order they appear in each note
+func Plan(notes []data.NoteCache) ([]File, error) {
+	sorted := append([]data.NoteCache{}, notes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	files := map[string]*File{}
+	order := []string{}
+	for _, note := range sorted {
+		for _, block := range note.CodeBlocks {
+			target, ok := block.Attrs[FileAttr]
+			if !ok {
+				continue
+			}
+			clean := path.Clean(target)
+			if target == "" || badTarget(clean) {
+				return nil, fmt.Errorf("%s:%d: %q: %w", note.Path, block.StartLine, target, ErrBadPath)
+			}
+			f, ok := files[clean]
+			if !ok {
+				f = &File{Path: clean}
+				files[clean] = f
+				order = append(order, clean)
+			}
+			f.Chunks = append(f.Chunks, Chunk{Note: note.Path, Block: block})
+		}
+	}
+
+	planned := make([]File, 0, len(order))
+	for _, p := range order {
+		planned = append(planned, *files[p])
+	}
+	return planned, nil
+}
+
+// Write puts every file under dir. Files whose content is already up to date are left alone.
+// It returns the paths that were written
+func Write(dir string, files []File) ([]string, error) {
+	written := []string{}
+	for _, f := range files {
+		dest := filepath.Join(dir, filepath.FromSlash(f.Path))
+		content := f.Content()
+		if existing, err := os.ReadFile(dest); err == nil && bytes.Equal(existing, content) {
+			continue
+		}
+		err := os.MkdirAll(filepath.Dir(dest), 0777)
+		if err != nil {
+			return written, err
+		}
+		err = os.WriteFile(dest, content, 0644)
+		if err != nil {
+			return written, err
+		}
+		written = append(written, f.Path)
+	}
+	return written, nil
+}
+
+// Drift is a tangled file that no longer matches the notes it came from
+type Drift struct {
+	Path string `json:"path"`
+	// 1 based line of the file where it first differs. 0 if the file is missing
+	FileLine int `json:"file_line"`
+	// where the differing line comes from. Empty if the file has lines past the last block
+	Note     data.VaultLocation `json:"note"`
+	NoteLine int                `json:"note_line"`
+	Reason   string             `json:"reason"`
+}
+
+func (d Drift) String() string {
+	where := d.Path
+	if d.FileLine > 0 {
+		where = fmt.Sprintf("%s:%d", d.Path, d.FileLine)
+	}
+	if d.Note != "" {
+		return fmt.Sprintf("%s: %s (from %s:%d)", where, d.Reason, d.Note, d.NoteLine)
+	}
+	return fmt.Sprintf("%s: %s", where, d.Reason)
+}
+
+// Check compares the files in dir with what the notes would tangle to
+func Check(dir string, files []File) ([]Drift, error) {
+	drifts := []Drift{}
+	for _, f := range files {
+		existing, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(f.Path)))
+		if errors.Is(err, fs.ErrNotExist) {
+			drifts = append(drifts, Drift{Path: f.Path, Reason: "missing"})
+			continue
+		} else if err != nil {
+			return drifts, err
+		}
+		if d, drifted := compare(f, existing); drifted {
+			drifts = append(drifts, d)
+		}
+	}
+	return drifts, nil
+}
+
+// compare finds the first line of existing that differs from f and traces it back to its note
+func compare(f File, existing []byte) (Drift, bool) {
+	have := splitLines(string(existing))
+	fileLine := 0
+	for _, c := range f.Chunks {
+		for i, want := range splitLines(chunkText(c)) {
+			fileLine++
+			if fileLine > len(have) || have[fileLine-1] != want {
+				reason := "differs from note"
+				if fileLine > len(have) {
+					reason = "ends early"
+				}
+				return Drift{
+					Path:     f.Path,
+					FileLine: fileLine,
+					Note:     c.Note,
+					NoteLine: c.Block.StartLine + i,
+					Reason:   reason,
+				}, true
+			}
+		}
+	}
+	if len(have) > fileLine {
+		return Drift{Path: f.Path, FileLine: fileLine + 1, Reason: "has lines not in any note"}, true
+	}
+	return Drift{}, false
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
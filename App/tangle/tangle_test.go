@@ -0,0 +1,88 @@
+package tangle
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+func notes(t *testing.T, sources map[string]string) []data.NoteCache {
+	caches := []data.NoteCache{}
+	for name, src := range sources {
+		cache, _, err := data.MakeNoteCache(data.VaultLocation(name), []byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		caches = append(caches, cache)
+	}
+	return caches
+}
+
+func TestTangleAndCheck(t *testing.T) {
+	files, err := Plan(notes(t, map[string]string{
+		"b.md": "```go file=main.go\nfunc main() {}\n```\n",
+		"a.md": "# Intro\n\n```go file=main.go\npackage main\n```\n\n```sh\necho skipped\n```\n",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || string(files[0].Content()) != "package main\nfunc main() {}\n" {
+		t.Fatalf("Expected blocks of a.md before b.md, got %v", files)
+	}
+
+	dir := t.TempDir()
+	written, err := Write(dir, files)
+	if err != nil || len(written) != 1 {
+		t.Fatalf("Expected main.go to be written, got %v %v", written, err)
+	}
+	drifts, _ := Check(dir, files)
+	if len(drifts) != 0 {
+		t.Fatalf("Expected no drift right after tangling, got %v", drifts)
+	}
+
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\nfunc main() { panic(1) }\n"), 0644)
+	drifts, _ = Check(dir, files)
+	if len(drifts) != 1 {
+		t.Fatalf("Expected drift, got %v", drifts)
+	}
+	d := drifts[0]
+	if d.FileLine != 2 || d.Note != "b.md" || d.NoteLine != 2 {
+		t.Errorf("Expected drift traced to b.md:2, got %v", d)
+	}
+
+	os.Remove(filepath.Join(dir, "main.go"))
+	drifts, _ = Check(dir, files)
+	if len(drifts) != 1 || drifts[0].Reason != "missing" {
+		t.Errorf("Expected missing file, got %v", drifts)
+	}
+}
+
+func TestTangleRejectsEscapingPaths(t *testing.T) {
+	_, err := Plan(notes(t, map[string]string{
+		"a.md": "```sh file=../../etc/profile\nexit\n```\n",
+	}))
+	if !errors.Is(err, ErrBadPath) {
+		t.Errorf("Expected bad path error, got %v", err)
+	}
+}
+
+func TestTangleRejectsVaultFiles(t *testing.T) {
+	for _, target := range []string{".git/config", ".cache/data.json", "src/.hidden", "other-note.md", "Notes/Secret.MD", "secret.md.enc", "."} {
+		_, err := Plan(notes(t, map[string]string{
+			"a.md": "```sh file=" + target + "\nexit\n```\n",
+		}))
+		if !errors.Is(err, ErrBadPath) {
+			t.Errorf("Expected bad path error for %q, got %v", target, err)
+		}
+	}
+
+	files, err := Plan(notes(t, map[string]string{
+		"a.md": "```go file=src/main.go\npackage main\n```\n",
+	}))
+	if err != nil || len(files) != 1 || files[0].Path != "src/main.go" {
+		t.Errorf("Expected src/main.go to be planned, got %v, %v", files, err)
+	}
+}
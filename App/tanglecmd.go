@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cowsed/Pumice/App/data"
+	"github.com/cowsed/Pumice/App/tangle"
+	"github.com/cowsed/Pumice/App/vaultcrypt"
+)
+
+const tangleUsage = `usage: tangle [flags]
+
+Writes every code block with a file=path attribute into path under the output
+directory. Blocks for the same file are joined in note path order, then in the
+order they appear in each note. With -check nothing is written; files that no
+longer match their notes are reported and the exit code is 1. Targets must stay
+inside the output directory and may not be hidden or end in .md, so tangling
+into the vault cannot overwrite notes, .git or the cache.
+`
+
+// tangleMain runs the tangle subcommand and returns the exit code
+func tangleMain(args []string) int {
+	opts := cliOptions{}
+	flags := outputFlags("tangle", tangleUsage, &opts)
+	out := flags.String("out", "", "directory to write files into (defaults to the vault)")
+	check := flags.Bool("check", false, "report drifted files instead of writing them")
+	if !parseCommand(flags, args, 0, 0) {
+		return 2
+	}
+	opts.setupLogging()
+	if *out == "" {
+		*out = opts.vault
+	}
+
+	vaultPath := data.OSPath(opts.vault)
+	filesys := vaultFS(vaultPath)
+	// encrypted notes are never tangled, their code would end up on disk in the clear
+	keys := vaultcrypt.NewKeyring()
+	mds, err := vaultNotes(filesys, keys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	files, err := tangle.Plan(CacheAll(mds, filesys, nil, keys))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *check {
+		drifts, err := tangle.Check(*out, files)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		code := opts.print(drifts, func(w io.Writer) {
+			for _, d := range drifts {
+				fmt.Fprintln(w, d)
+			}
+		})
+		if code == 0 && len(drifts) > 0 {
+			return 1
+		}
+		return code
+	}
+
+	written, err := tangle.Write(*out, files)
+	code := opts.print(written, func(w io.Writer) {
+		for _, p := range written {
+			fmt.Fprintln(w, "wrote", p)
+		}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return code
+}
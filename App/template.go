@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+// noteFromTemplate is the initial content of a new note at loc.
+// The {{title}}, {{date}} and {{time}} placeholders Obsidian templates use are filled in
+func noteFromTemplate(vaultPath data.OSPath, template data.VaultLocation, loc data.VaultLocation, now time.Time) ([]byte, error) {
+	if template == "" {
+		return []byte{}, nil
+	}
+	bs, err := os.ReadFile(data.ToOSPath(vaultPath, template))
+	if err != nil {
+		return nil, err
+	}
+	title := strings.TrimSuffix(string(loc.Name()), ".md")
+	replacer := strings.NewReplacer(
+		"{{title}}", title,
+		"{{date}}", now.Format("2006-01-02"),
+		"{{time}}", now.Format("15:04"),
+	)
+	buf := bytes.Buffer{}
+	_, err = replacer.WriteString(&buf, string(bs))
+	return buf.Bytes(), err
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cowsed/Pumice/App/config"
+	"github.com/cowsed/Pumice/App/data"
+	"github.com/cowsed/Pumice/App/query"
+	"github.com/cowsed/Pumice/App/vaultcrypt"
+)
+
+var ErrNoSuchNote = errors.New("no such note")
+var ErrNoteExists = errors.New("note already exists")
+var ErrReadOnly = errors.New("vault is served read only")
+var ErrOutsideVault = errors.New("path is outside the vault")
+
+// contentSum identifies what a note held on disk, so rereading it can tell whether it changed
+type contentSum [sha256.Size]byte
+
+func sumOf(raw []byte) contentSum {
+	return sha256.Sum256(raw)
+}
+
+// cleanLocation tidies a path given by a client and refuses any that leave the vault
+func cleanLocation(loc data.VaultLocation) (data.VaultLocation, error) {
+	cleaned := path.Clean(string(loc))
+	if path.IsAbs(cleaned) || filepath.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%s: %w", loc, ErrOutsideVault)
+	}
+	return data.VaultLocation(cleaned), nil
+}
+
+// Vault is the live index of a vault. The 9P tree reads notes through it
+// so edits made by clients show up everywhere once the note is reparsed
+type Vault struct {
+	path    data.OSPath
+	changes *changeRecorder
+	keys    *vaultcrypt.Keyring
+	notes   map[data.VaultLocation]data.NoteCache
+	// bumped every time a note is parsed again, served as the qid version of its files
+	versions map[data.VaultLocation]uint32
+	// bumped every time links are resolved again, anything showing where links lead follows it
+	relinks uint32
+	// what each note last held on disk, missing for notes read before the vault knew
+	sums   map[data.VaultLocation]contentSum
+	events *EventBus
+	html   *htmlCache
+	// refuses every change made through the vault. Reindexing still picks up edits made on disk
+	readOnly bool
+	state    atomic.Int32
+	quit     chan struct{}
+	quitter  sync.Once
+	sync.RWMutex
+}
+
+func NewVault(vaultPath data.OSPath, caches []data.NoteCache, changes *changeRecorder, keys *vaultcrypt.Keyring) *Vault {
+	v := &Vault{
+		path:     vaultPath,
+		changes:  changes,
+		keys:     keys,
+		notes:    map[data.VaultLocation]data.NoteCache{},
+		versions: map[data.VaultLocation]uint32{},
+		sums:     map[data.VaultLocation]contentSum{},
+		events:   NewEventBus(),
+		html:     newHTMLCache(),
+		quit:     make(chan struct{}),
+	}
+	for _, cache := range caches {
+		v.notes[cache.Path] = cache
+	}
+	v.relink()
+	v.SetState(Ready)
+	return v
+}
+
+func (v *Vault) State() BackendState {
+	return BackendState(v.state.Load())
+}
+
+func (v *Vault) SetState(state BackendState) {
+	v.state.Store(int32(state))
+}
+
+// Quit asks the app to stop serving the vault
+func (v *Vault) Quit() {
+	v.quitter.Do(func() { close(v.quit) })
+}
+
+func (v *Vault) Done() <-chan struct{} {
+	return v.quit
+}
+
+// SaveCache writes the index to the on-disk cache
+func (v *Vault) SaveCache() error {
+	return saveWorkspaceCache(v.path, data.VaultCache{
+		Version: config.VERSION,
+		Notes:   v.Notes(),
+	})
+}
+
+// relink resolves the links of every note again after notes were added, removed or edited.
+// The caller must hold the lock
+func (v *Vault) relink() {
+	notes := make([]data.NoteCache, 0, len(v.notes))
+	for _, note := range v.notes {
+		notes = append(notes, note)
+	}
+	data.ResolveOutlinks(notes)
+	for _, note := range notes {
+		v.notes[note.Path] = note
+	}
+	v.relinks++
+}
+
+func (v *Vault) Note(loc data.VaultLocation) (data.NoteCache, bool) {
+	v.RLock()
+	defer v.RUnlock()
+	note, ok := v.notes[loc]
+	return note, ok
+}
+
+// Notes lists every indexed note in path order
+func (v *Vault) Notes() []data.NoteCache {
+	v.RLock()
+	defer v.RUnlock()
+	notes := make([]data.NoteCache, 0, len(v.notes))
+	for _, note := range v.notes {
+		notes = append(notes, note)
+	}
+	sort.Slice(notes, func(i, j int) bool { return notes[i].Path < notes[j].Path })
+	return notes
+}
+
+// Version counts how many times a note has been parsed again since the vault was opened
+func (v *Vault) Version(loc data.VaultLocation) uint32 {
+	v.RLock()
+	defer v.RUnlock()
+	return v.versions[loc]
+}
+
+// Relinks counts how often links were resolved again, which any change to the set of notes does
+func (v *Vault) Relinks() uint32 {
+	v.RLock()
+	defer v.RUnlock()
+	return v.relinks
+}
+
+// ModTime is when the note was last changed on disk
+func (v *Vault) ModTime(loc data.VaultLocation) (time.Time, error) {
+	info, err := os.Stat(data.ToOSPath(v.path, loc))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Inlinks lists the notes that link to loc
+func (v *Vault) Inlinks(loc data.VaultLocation) []data.VaultLocation {
+	return data.Inlinks(v.Notes(), loc)
+}
+
+// ReadBody returns the markdown of a note, decrypted if needed
+func (v *Vault) ReadBody(loc data.VaultLocation) ([]byte, error) {
+	note, ok := v.Note(loc)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", loc, ErrNoSuchNote)
+	}
+	bs, err := os.ReadFile(data.ToOSPath(v.path, loc))
+	if err != nil || !note.Encrypted {
+		return bs, err
+	}
+	return v.keys.Decrypt(bs)
+}
+
+// WriteBody replaces the markdown of a note on disk and reparses it.
+// A plaintext note whose new front matter asks for encryption is sealed to its .md.enc counterpart instead
+func (v *Vault) WriteBody(loc data.VaultLocation, content []byte) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+	v.Lock()
+	defer v.Unlock()
+	old, ok := v.notes[loc]
+	if !ok {
+		return fmt.Errorf("%s: %w", loc, ErrNoSuchNote)
+	}
+
+	cache, _, err := data.MakeNoteCache(loc, content)
+	if err != nil {
+		return err
+	}
+	cache.Encrypted = old.Encrypted
+	cache.LastChanged = old.LastChanged
+	if !old.Encrypted && vaultcrypt.WantsEncryption(cache.Metadata) {
+		// a note marked for encryption is sealed instead of written in the clear
+		sealedLoc, raw, err := sealNote(v.path, loc, content, v.keys)
+		if err != nil {
+			return fmt.Errorf("%s is marked encrypted: %w", loc, err)
+		}
+		v.indexSealed(loc, sealedLoc, cache, raw)
+		v.relink()
+		return nil
+	}
+
+	raw := content
+	var perm fs.FileMode = 0644
+	if old.Encrypted {
+		raw, err = v.keys.Encrypt(content)
+		if err != nil {
+			return err
+		}
+		perm = 0600
+	}
+	osPath := data.ToOSPath(v.path, loc)
+	if info, err := os.Stat(osPath); err == nil {
+		perm = info.Mode().Perm()
+	}
+	err = data.WriteAtomic(osPath, raw, perm)
+	if err != nil {
+		return err
+	}
+	v.changes.Record(loc, raw)
+	v.notes[loc] = cache
+	v.versions[loc]++
+	v.sums[loc] = sumOf(raw)
+	v.relink()
+	v.events.Publish(EventModified, string(loc))
+	v.events.publishTagChanges(loc, old.Tags, cache.Tags)
+	return nil
+}
+
+// Create writes a new note to disk and indexes it. A note marked for encryption is created sealed,
+// the returned cache says where
+func (v *Vault) Create(loc data.VaultLocation, content []byte) (data.NoteCache, error) {
+	if v.readOnly {
+		return data.NoteCache{}, ErrReadOnly
+	}
+	v.Lock()
+	defer v.Unlock()
+	if _, exists := v.notes[loc]; exists {
+		return data.NoteCache{}, fmt.Errorf("%s: %w", loc, ErrNoteExists)
+	}
+	cache, _, err := data.MakeNoteCache(loc, content)
+	if err != nil {
+		return cache, err
+	}
+
+	osPath := data.ToOSPath(v.path, loc)
+	err = os.MkdirAll(filepath.Dir(osPath), 0777)
+	if err != nil {
+		return cache, err
+	}
+	if vaultcrypt.WantsEncryption(cache.Metadata) {
+		// a note marked for encryption never touches the disk in the clear
+		sealedLoc, raw, err := writeSealed(v.path, loc, content, v.keys)
+		if errors.Is(err, fs.ErrExist) {
+			return cache, fmt.Errorf("%s: %w", sealedLoc, ErrNoteExists)
+		} else if err != nil {
+			return cache, fmt.Errorf("%s is marked encrypted: %w", loc, err)
+		}
+		cache.Path, cache.Encrypted = sealedLoc, true
+		loc, content = sealedLoc, raw
+	} else {
+		err = createExclusive(osPath, content)
+		if errors.Is(err, fs.ErrExist) {
+			return cache, fmt.Errorf("%s: %w", loc, ErrNoteExists)
+		} else if err != nil {
+			return cache, err
+		}
+	}
+
+	v.changes.Record(loc, content)
+	v.notes[loc] = cache
+	v.versions[loc]++
+	v.sums[loc] = sumOf(content)
+	v.relink()
+	v.events.Publish(EventCreated, string(loc))
+	v.events.publishTagChanges(loc, data.NewTagSet(), cache.Tags)
+	return v.notes[loc], nil
+}
+
+// createExclusive writes content to a new file at osPath, failing if there already is one
+func createExclusive(osPath string, content []byte) error {
+	f, err := os.OpenFile(osPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(osPath)
+	}
+	return err
+}
+
+// Trash moves a note into the vault's trash folder and drops it from the index.
+// It returns where the note ended up
+func (v *Vault) Trash(loc data.VaultLocation) (data.VaultLocation, error) {
+	if v.readOnly {
+		return "", ErrReadOnly
+	}
+	v.Lock()
+	defer v.Unlock()
+	if _, ok := v.notes[loc]; !ok {
+		return "", fmt.Errorf("%s: %w", loc, ErrNoSuchNote)
+	}
+
+	trashed := trashFolderName.Append(string(loc))
+	if _, err := os.Stat(data.ToOSPath(v.path, trashed)); err == nil {
+		// keep every trashed copy of a note that is removed more than once
+		ext := path.Ext(string(loc))
+		trashed = data.VaultLocation(fmt.Sprintf("%s.%d%s", strings.TrimSuffix(string(trashed), ext), time.Now().Unix(), ext))
+	}
+	err := os.MkdirAll(filepath.Dir(data.ToOSPath(v.path, trashed)), 0777)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(data.ToOSPath(v.path, loc), data.ToOSPath(v.path, trashed))
+	if err != nil {
+		return "", err
+	}
+
+	v.changes.Removed(loc)
+	delete(v.notes, loc)
+	delete(v.sums, loc)
+	v.relink()
+	v.events.Publish(EventRemoved, string(loc))
+	return trashed, nil
+}
+
+// Reindex reads every note from disk again, sealing notes marked encrypted while the vault is unlocked
+func (v *Vault) Reindex() (int, error) {
+	v.SetState(BuildingCache)
+	if v.keys.Unlocked() && !v.readOnly {
+		// notes marked encrypted behind the vault's back are sealed rather than left in the clear
+		if _, err := v.Seal(); err != nil {
+			slog.Error("Could not seal notes marked encrypted", "err", err)
+		}
+	}
+	filesys := vaultFS(v.path)
+	mds, err := vaultNotes(filesys, v.keys)
+	if err != nil {
+		v.SetState(Error)
+		return 0, err
+	}
+	caches, sums := cacheAll(mds, filesys, v.changes, v.keys)
+	v.changes.Annotate(caches)
+
+	v.Lock()
+	old, oldSums := v.notes, v.sums
+	v.notes = map[data.VaultLocation]data.NoteCache{}
+	v.sums = sums
+	for _, cache := range caches {
+		v.notes[cache.Path] = cache
+		v.versions[cache.Path]++
+		if before, existed := old[cache.Path]; existed {
+			if sum, known := oldSums[cache.Path]; !known || sum != sums[cache.Path] {
+				v.events.Publish(EventModified, string(cache.Path))
+			}
+			v.events.publishTagChanges(cache.Path, before.Tags, cache.Tags)
+		} else {
+			v.events.Publish(EventCreated, string(cache.Path))
+		}
+	}
+	for loc := range old {
+		if _, kept := v.notes[loc]; !kept {
+			v.events.Publish(EventRemoved, string(loc))
+		}
+	}
+	v.relink()
+	v.Unlock()
+
+	v.events.Publish(EventReindexComplete, fmt.Sprint(len(caches)))
+	v.SetState(Ready)
+	return len(caches), nil
+}
+
+// ReindexNote reads a single note from disk again. Notes that are gone from disk are dropped
+func (v *Vault) ReindexNote(loc data.VaultLocation) error {
+	loc, err := cleanLocation(loc)
+	if err != nil {
+		return err
+	}
+	raw, readErr := os.ReadFile(data.ToOSPath(v.path, loc))
+
+	v.Lock()
+	defer v.Unlock()
+	old, existed := v.notes[loc]
+	if errors.Is(readErr, fs.ErrNotExist) {
+		if !existed {
+			return fmt.Errorf("%s: %w", loc, ErrNoSuchNote)
+		}
+		delete(v.notes, loc)
+		delete(v.sums, loc)
+		v.relink()
+		v.events.Publish(EventRemoved, string(loc))
+		return nil
+	} else if readErr != nil {
+		return readErr
+	}
+
+	content := raw
+	encrypted := vaultcrypt.IsEncryptedPath(string(loc))
+	if encrypted {
+		content, err = v.keys.Decrypt(raw)
+		if err != nil {
+			return err
+		}
+	} else if path.Ext(string(loc)) != ".md" {
+		return fmt.Errorf("%s: not a note", loc)
+	} else if marked(loc, raw) {
+		return v.reindexMarked(loc, raw, existed)
+	}
+	cache, _, err := data.MakeNoteCache(loc, content)
+	if err != nil {
+		return err
+	}
+	cache.Encrypted = encrypted
+	cache.LastChanged = old.LastChanged
+	v.changes.Record(loc, raw)
+	v.notes[loc] = cache
+	v.versions[loc]++
+	oldSum, known := v.sums[loc]
+	v.sums[loc] = sumOf(raw)
+	v.relink()
+
+	if existed {
+		if !known || oldSum != v.sums[loc] {
+			v.events.Publish(EventModified, string(loc))
+		}
+	} else {
+		v.events.Publish(EventCreated, string(loc))
+	}
+	v.events.publishTagChanges(loc, old.Tags, cache.Tags)
+	return nil
+}
+
+// Rename moves a note within the vault. Links to it are resolved again but not rewritten
+func (v *Vault) Rename(from, to data.VaultLocation) error {
+	if v.readOnly {
+		return ErrReadOnly
+	}
+	from, err := cleanLocation(from)
+	if err != nil {
+		return err
+	}
+	to, err = cleanLocation(to)
+	if err != nil {
+		return err
+	}
+	v.Lock()
+	defer v.Unlock()
+	note, ok := v.notes[from]
+	if !ok {
+		return fmt.Errorf("%s: %w", from, ErrNoSuchNote)
+	}
+	if vaultcrypt.IsEncryptedPath(string(from)) != vaultcrypt.IsEncryptedPath(string(to)) || path.Ext(string(from)) != path.Ext(string(to)) {
+		return fmt.Errorf("%s: a renamed note must keep its extension", to)
+	}
+	if _, exists := v.notes[to]; exists {
+		return fmt.Errorf("%s: %w", to, ErrNoteExists)
+	}
+	toPath := data.ToOSPath(v.path, to)
+	if _, err := os.Stat(toPath); err == nil {
+		return fmt.Errorf("%s: %w", to, ErrNoteExists)
+	}
+	err = os.MkdirAll(filepath.Dir(toPath), 0777)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(data.ToOSPath(v.path, from), toPath)
+	if err != nil {
+		return err
+	}
+
+	v.changes.Removed(from)
+	if raw, err := os.ReadFile(toPath); err == nil {
+		v.changes.Record(to, raw)
+	}
+	delete(v.notes, from)
+	note.Path = to
+	v.notes[to] = note
+	v.versions[to] = v.versions[from] + 1
+	if sum, known := v.sums[from]; known {
+		v.sums[to] = sum
+		delete(v.sums, from)
+	}
+	v.relink()
+	v.events.Publish(EventRenamed, string(from), string(to))
+	return nil
+}
+
+// Retag renames a tag in every note that uses it and returns how many notes changed
+func (v *Vault) Retag(old, new data.Tag) (int, error) {
+	if v.readOnly {
+		return 0, ErrReadOnly
+	}
+	changed := 0
+	for _, note := range v.Notes() {
+		uses := false
+		for _, tag := range note.Tags.List() {
+			if tag == old || strings.HasPrefix(string(tag), string(old)+"/") {
+				uses = true
+			}
+		}
+		if !uses {
+			continue
+		}
+		body, err := v.ReadBody(note.Path)
+		if err != nil {
+			return changed, err
+		}
+		renamed := data.RenameTag(body, old, new)
+		if bytes.Equal(body, renamed) {
+			continue
+		}
+		err = v.WriteBody(note.Path, renamed)
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// Search lists the notes matching q in path order
+func (v *Vault) Search(q query.Query) []data.NoteCache {
+	return query.Run(q, v.Notes(), func(loc data.VaultLocation) []byte {
+		body, err := v.ReadBody(loc)
+		if err != nil {
+			slog.Warn("Could not read note while searching", "path", loc, "err", err)
+		}
+		return body
+	})
+}
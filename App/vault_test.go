@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// drain takes every event published so far
+func drain(sub *subscriber) []Event {
+	events := []Event{}
+	for len(sub.events) > 0 {
+		events = append(events, <-sub.events)
+	}
+	return events
+}
+
+func TestReindexPublishesModified(t *testing.T) {
+	vault, _ := testVault(t, map[string]string{"same.md": "same", "edited.md": "before"})
+	// learn what is on disk, testVault does not say
+	if _, err := vault.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	sub := vault.events.subscribe()
+
+	err := os.WriteFile(filepath.Join(string(vault.path), "edited.md"), []byte("after"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	modified := []string{}
+	for _, ev := range drain(sub) {
+		if ev.Kind == EventModified {
+			modified = append(modified, ev.Args[0])
+		}
+	}
+	if len(modified) != 1 || modified[0] != "edited.md" {
+		t.Fatalf("Expected only edited.md to be modified, got %v", modified)
+	}
+}
+
+func TestCtlRefusesPathsOutsideVault(t *testing.T) {
+	vault, ft := testVault(t, map[string]string{"a.md": "a"})
+	ctl := newCtlFile(ft.fs, vault, ft)
+	outside := filepath.Join(filepath.Dir(string(vault.path)), "outside.md")
+	if err := os.WriteFile(outside, []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmd := range []string{
+		"reindex ../outside.md",
+		"reindex " + outside,
+		"rename a.md ../moved.md",
+		"rename a.md /tmp/moved.md",
+		"rename ../outside.md b.md",
+	} {
+		if _, err := ctl.Write(1, 0, []byte(cmd)); !errors.Is(err, ErrOutsideVault) {
+			t.Errorf("Expected %q to fail with %v, got %v", cmd, ErrOutsideVault, err)
+		}
+	}
+
+	if _, err := ctl.Write(1, 0, []byte("rename ./a.md sub/../b.md")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vault.Note("b.md"); !ok {
+		t.Fatal("Expected the cleaned rename to reach b.md")
+	}
+}
@@ -0,0 +1,194 @@
+// Package vaultcrypt stores notes with authenticated encryption under a passphrase derived key
+package vaultcrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cowsed/Pumice/App/data"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Extension marks a note that is stored encrypted on disk
+const Extension = ".md.enc"
+
+// MetadataKey is the frontmatter key that asks for a note to be encrypted
+const MetadataKey = data.EncryptedKey
+
+var ErrLocked = errors.New("vault is locked")
+var ErrNotEncrypted = errors.New("not an encrypted note")
+var ErrDecrypt = errors.New("wrong passphrase or corrupted note")
+
+// file layout: magic[8] salt[16] nonce[12] ciphertext+tag
+var magic = []byte("PUMICE\x00\x01")
+
+const saltSize = 16
+const keySize = 32
+
+// scrypt cost parameters
+var (
+	costN = 1 << 15
+	costR = 8
+	costP = 1
+)
+
+func IsEncryptedPath(name string) bool {
+	return strings.HasSuffix(name, Extension)
+}
+
+// WantsEncryption reports whether frontmatter marks a note as encrypted
+func WantsEncryption(meta map[string]data.MetaDataValue) bool {
+	return data.WantsEncryption(meta)
+}
+
+// Keyring holds the passphrase while the vault is unlocked.
+// Derived keys are cached per salt since scrypt is deliberately slow
+type Keyring struct {
+	passphrase []byte
+	keys       map[string][]byte
+	// salt used for notes sealed during this session
+	salt []byte
+	mu   sync.Mutex
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[string][]byte{}}
+}
+
+// Unlock keeps passphrase for sealing and opening notes. sealed is any note already sealed in
+// the vault, or nil if there is none yet. A passphrase that cannot open it is refused and the
+// keyring stays locked, so a typo does not seal new notes under a key nobody knows
+func (k *Keyring) Unlock(passphrase string, sealed []byte) error {
+	if passphrase == "" {
+		return errors.New("empty passphrase")
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.passphrase = []byte(passphrase)
+	k.salt = salt
+	k.mu.Unlock()
+	if sealed == nil {
+		return nil
+	}
+	if _, err := k.Decrypt(sealed); err != nil {
+		k.Lock()
+		return fmt.Errorf("passphrase does not open the notes sealed before: %w", err)
+	}
+	return nil
+}
+
+// Lock forgets the passphrase and every derived key
+func (k *Keyring) Lock() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for i := range k.passphrase {
+		k.passphrase[i] = 0
+	}
+	for _, key := range k.keys {
+		for i := range key {
+			key[i] = 0
+		}
+	}
+	k.passphrase = nil
+	k.keys = map[string][]byte{}
+}
+
+func (k *Keyring) Unlocked() bool {
+	if k == nil {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.passphrase != nil
+}
+
+func (k *Keyring) keyFor(salt []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.passphrase == nil {
+		return nil, ErrLocked
+	}
+	if key, ok := k.keys[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key(k.passphrase, salt, costN, costR, costP, keySize)
+	if err != nil {
+		return nil, err
+	}
+	k.keys[string(salt)] = key
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (k *Keyring) Encrypt(plain []byte) ([]byte, error) {
+	if !k.Unlocked() {
+		return nil, ErrLocked
+	}
+	k.mu.Lock()
+	salt := k.salt
+	k.mu.Unlock()
+
+	key, err := k.keyFor(salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(magic)+saltSize+len(nonce)+len(plain)+aead.Overhead())
+	out = append(out, magic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plain, magic), nil
+}
+
+func (k *Keyring) Decrypt(sealed []byte) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, magic) {
+		return nil, ErrNotEncrypted
+	}
+	rest := sealed[len(magic):]
+	if len(rest) < saltSize {
+		return nil, fmt.Errorf("truncated header: %w", ErrDecrypt)
+	}
+	salt, rest := rest[:saltSize], rest[saltSize:]
+
+	key, err := k.keyFor(salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf("truncated header: %w", ErrDecrypt)
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, magic)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
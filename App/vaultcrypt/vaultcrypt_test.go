@@ -0,0 +1,94 @@
+package vaultcrypt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func init() {
+	// keep the tests fast
+	costN = 1 << 10
+}
+
+func TestRoundTrip(t *testing.T) {
+	k := NewKeyring()
+	if err := k.Unlock("hunter2", nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := []byte("# Secrets\n\npassword: swordfish\n")
+	sealed, err := k.Encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("swordfish")) {
+		t.Fatal("Plaintext leaked into sealed note")
+	}
+
+	// a fresh keyring with the same passphrase has to derive the key from the stored salt
+	other := NewKeyring()
+	other.Unlock("hunter2", nil)
+	got, err := other.Decrypt(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("Expected %q, got %q", plain, got)
+	}
+}
+
+func TestWrongPassphraseAndTampering(t *testing.T) {
+	k := NewKeyring()
+	k.Unlock("right", nil)
+	sealed, _ := k.Encrypt([]byte("hello"))
+
+	wrong := NewKeyring()
+	wrong.Unlock("wrong", nil)
+	if _, err := wrong.Decrypt(sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for the wrong passphrase, got %v", err)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := k.Decrypt(sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for a modified note, got %v", err)
+	}
+}
+
+func TestLocked(t *testing.T) {
+	k := NewKeyring()
+	k.Unlock("pass", nil)
+	sealed, _ := k.Encrypt([]byte("hello"))
+	k.Lock()
+
+	if k.Unlocked() {
+		t.Error("Expected keyring to be locked")
+	}
+	if _, err := k.Decrypt(sealed); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked, got %v", err)
+	}
+	if _, err := k.Decrypt([]byte("# plain")); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Expected ErrNotEncrypted, got %v", err)
+	}
+}
+
+func TestUnlockChecksPassphrase(t *testing.T) {
+	k := NewKeyring()
+	k.Unlock("right", nil)
+	sealed, _ := k.Encrypt([]byte("hello"))
+
+	typo := NewKeyring()
+	if err := typo.Unlock("rihgt", sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for a passphrase that cannot open the vault, got %v", err)
+	}
+	if typo.Unlocked() {
+		t.Error("Expected the keyring to stay locked")
+	}
+	if _, err := typo.Encrypt([]byte("new note")); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected sealing under the wrong passphrase to fail with ErrLocked, got %v", err)
+	}
+
+	right := NewKeyring()
+	if err := right.Unlock("right", sealed); err != nil {
+		t.Fatalf("Expected the right passphrase to unlock, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package vcs
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+// AutoCommitter commits the vault once it has been quiet for a while after a change
+type AutoCommitter struct {
+	repo    *Repo
+	delay   time.Duration
+	timer   *time.Timer
+	pending map[data.VaultLocation]struct{}
+	// Called with the result of every commit made in the background. May be nil
+	OnCommit func(committed bool, err error)
+	sync.Mutex
+}
+
+func NewAutoCommitter(repo *Repo, delay time.Duration) *AutoCommitter {
+	return &AutoCommitter{
+		repo:    repo,
+		delay:   delay,
+		pending: map[data.VaultLocation]struct{}{},
+	}
+}
+
+// Changed records a change to a note and restarts the debounce timer
+func (ac *AutoCommitter) Changed(loc data.VaultLocation) {
+	ac.Lock()
+	defer ac.Unlock()
+	ac.pending[loc] = struct{}{}
+	if ac.timer != nil {
+		ac.timer.Stop()
+	}
+	ac.timer = time.AfterFunc(ac.delay, func() {
+		committed, err := ac.Flush()
+		if err != nil {
+			slog.Error("Auto commit failed", "err", err)
+		}
+		if ac.OnCommit != nil {
+			ac.OnCommit(committed, err)
+		}
+	})
+}
+
+// Flush commits pending changes now
+func (ac *AutoCommitter) Flush() (bool, error) {
+	ac.Lock()
+	defer ac.Unlock()
+	if ac.timer != nil {
+		ac.timer.Stop()
+		ac.timer = nil
+	}
+	if len(ac.pending) == 0 {
+		return false, nil
+	}
+	locs := make([]data.VaultLocation, 0, len(ac.pending))
+	for loc := range ac.pending {
+		locs = append(locs, loc)
+	}
+	committed, err := ac.repo.Commit(locs, commitMessage)
+	if err != nil {
+		return false, err
+	}
+	ac.pending = map[data.VaultLocation]struct{}{}
+	return committed, nil
+}
+
+func commitMessage(changed []data.VaultLocation) string {
+	locs := make([]string, 0, len(changed))
+	for _, loc := range changed {
+		locs = append(locs, string(loc))
+	}
+	sort.Strings(locs)
+	if len(locs) == 1 {
+		return "pumice: update " + locs[0]
+	}
+	return fmt.Sprintf("pumice: update %d notes\n\n%s\n", len(locs), strings.Join(locs, "\n"))
+}
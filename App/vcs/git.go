@@ -0,0 +1,259 @@
+// Package vcs versions a vault with the local git binary
+package vcs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+var ErrNotARepo = errors.New("not a git repository")
+var ErrConflict = errors.New("pull left conflicts")
+
+var GitBinary = "git"
+
+type Repo struct {
+	Dir string
+}
+
+type GitError struct {
+	Args   []string
+	Stderr string
+	Err    error
+}
+
+func (ge GitError) Error() string {
+	return fmt.Sprintf("git %s: %v: %s", strings.Join(ge.Args, " "), ge.Err, strings.TrimSpace(ge.Stderr))
+}
+func (ge GitError) Unwrap() error {
+	return ge.Err
+}
+
+func Available() bool {
+	_, err := exec.LookPath(GitBinary)
+	return err == nil
+}
+
+// Open finds the repository the vault lives in
+func Open(dir string) (*Repo, error) {
+	r := &Repo{Dir: dir}
+	out, err := r.run("rev-parse", "--is-inside-work-tree")
+	if err != nil || strings.TrimSpace(out) != "true" {
+		return nil, fmt.Errorf("%s: %w", dir, ErrNotARepo)
+	}
+	return r, nil
+}
+
+func Init(dir string) (*Repo, error) {
+	r := &Repo{Dir: dir}
+	_, err := r.run("init", "--quiet")
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Repo) run(args ...string) (string, error) {
+	cmd := exec.Command(GitBinary, append([]string{"-C", r.Dir}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return stdout.String(), GitError{Args: args, Stderr: stderr.String(), Err: err}
+	}
+	return stdout.String(), nil
+}
+
+// CommitAll stages every change in the vault and commits it.
+// It reports false if there was nothing to commit
+func (r *Repo) CommitAll(message string) (bool, error) {
+	_, err := r.run("add", "--all")
+	if err != nil {
+		return false, err
+	}
+	status, err := r.run("status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	if strings.TrimSpace(status) == "" {
+		return false, nil
+	}
+	_, err = r.run("commit", "--quiet", "-m", message)
+	return err == nil, err
+}
+
+// Commit stages and commits only the given notes, paths are relative to the vault.
+// Notes that are gone are committed as removed. Anything else in the vault, staged or not, is left alone.
+// message is given the notes that did change. It reports false if none did
+func (r *Repo) Commit(locs []data.VaultLocation, message func(changed []data.VaultLocation) string) (bool, error) {
+	present, missing := []string{}, []string{}
+	for _, loc := range locs {
+		if _, err := os.Lstat(path.Join(r.Dir, string(loc))); err == nil {
+			present = append(present, string(loc))
+		} else {
+			missing = append(missing, string(loc))
+		}
+	}
+	// note names are not globs
+	if len(present) > 0 {
+		_, err := r.run(append([]string{"--literal-pathspecs", "add", "--all", "--"}, present...)...)
+		if err != nil {
+			return false, err
+		}
+	}
+	if len(missing) > 0 {
+		_, err := r.run(append([]string{"--literal-pathspecs", "rm", "--cached", "--quiet", "--ignore-unmatch", "--"}, missing...)...)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	out, err := r.run(append([]string{"--literal-pathspecs", "diff", "--cached", "--name-only", "--relative", "-z", "--"}, append(present, missing...)...)...)
+	if err != nil {
+		return false, err
+	}
+	changed := strings.Split(strings.TrimRight(out, "\x00"), "\x00")
+	if len(changed) == 0 || changed[0] == "" {
+		return false, nil
+	}
+	changedLocs := make([]data.VaultLocation, len(changed))
+	for i, p := range changed {
+		changedLocs[i] = data.VaultLocation(p)
+	}
+	_, err = r.run(append([]string{"--literal-pathspecs", "commit", "--quiet", "-m", message(changedLocs), "--"}, changed...)...)
+	return err == nil, err
+}
+
+func (r *Repo) Push(remote, branch string) error {
+	_, err := r.run("push", "--quiet", remote, branch)
+	return err
+}
+
+// Pull merges remote changes. If the merge leaves conflict markers in notes
+// those notes are returned along with ErrConflict
+func (r *Repo) Pull(remote, branch string) ([]data.VaultLocation, error) {
+	_, pullErr := r.run("pull", "--quiet", "--no-rebase", "--no-edit", remote, branch)
+	conflicts, err := r.ConflictedNotes()
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 {
+		return conflicts, fmt.Errorf("%d notes: %w", len(conflicts), ErrConflict)
+	}
+	return nil, pullErr
+}
+
+const fieldSep = "\x1f"
+const logFormat = "--format=%H" + fieldSep + "%an" + fieldSep + "%ae" + fieldSep + "%at" + fieldSep + "%s"
+
+func parseLog(out string) ([]data.Revision, error) {
+	revs := []data.Revision{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, fieldSep, 5)
+		if len(fields) != 5 {
+			return revs, fmt.Errorf("unexpected git log line %q", line)
+		}
+		unix, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return revs, err
+		}
+		revs = append(revs, data.Revision{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Time:    time.Unix(unix, 0),
+			Subject: fields[4],
+		})
+	}
+	return revs, nil
+}
+
+// History lists the commits that touched a note, newest first
+func (r *Repo) History(loc data.VaultLocation) ([]data.Revision, error) {
+	out, err := r.run("log", "--follow", logFormat, "--", string(loc))
+	if err != nil {
+		return nil, err
+	}
+	return parseLog(out)
+}
+
+// LastChanged is the newest commit that touched a note.
+// ok is false for notes that were never committed
+func (r *Repo) LastChanged(loc data.VaultLocation) (rev data.Revision, ok bool, err error) {
+	out, err := r.run("log", "-1", logFormat, "--", string(loc))
+	if err != nil {
+		return rev, false, err
+	}
+	revs, err := parseLog(out)
+	if err != nil || len(revs) == 0 {
+		return rev, false, err
+	}
+	return revs[0], true, nil
+}
+
+// Annotate fills in LastChanged for every note
+func (r *Repo) Annotate(caches []data.NoteCache) error {
+	for i := range caches {
+		rev, ok, err := r.LastChanged(caches[i].Path)
+		if err != nil {
+			return err
+		}
+		if ok {
+			caches[i].LastChanged = &rev
+		}
+	}
+	return nil
+}
+
+// ConflictedNotes finds markdown files that are unmerged or still contain conflict markers
+func (r *Repo) ConflictedNotes() ([]data.VaultLocation, error) {
+	out, err := r.run("ls-files", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	conflicts := []data.VaultLocation{}
+	seen := map[string]struct{}{}
+	for _, file := range strings.Split(out, "\n") {
+		if _, dup := seen[file]; dup || path.Ext(file) != ".md" {
+			continue
+		}
+		// unmerged files are listed once per stage
+		seen[file] = struct{}{}
+		bs, err := os.ReadFile(data.ToOSPath(data.OSPath(r.Dir), data.VaultLocation(file)))
+		if err != nil {
+			continue
+		}
+		if HasConflictMarkers(bs) {
+			conflicts = append(conflicts, data.VaultLocation(file))
+		}
+	}
+	return conflicts, nil
+}
+
+// HasConflictMarkers reports whether a file contains a complete set of merge conflict markers
+func HasConflictMarkers(content []byte) bool {
+	state := 0
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		switch {
+		case state == 0 && bytes.HasPrefix(line, []byte("<<<<<<< ")):
+			state = 1
+		case state == 1 && bytes.Equal(bytes.TrimRight(line, "\r"), []byte("=======")):
+			state = 2
+		case state == 2 && bytes.HasPrefix(line, []byte(">>>>>>> ")):
+			return true
+		}
+	}
+	return false
+}
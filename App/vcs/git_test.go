@@ -0,0 +1,199 @@
+package vcs
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cowsed/Pumice/App/data"
+)
+
+func setupGit(t *testing.T) {
+	if !Available() {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "Glenda")
+	t.Setenv("GIT_AUTHOR_EMAIL", "glenda@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Glenda")
+	t.Setenv("GIT_COMMITTER_EMAIL", "glenda@example.com")
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+}
+
+func git(t *testing.T, args ...string) {
+	out, err := exec.Command(GitBinary, args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+}
+
+// clone makes a working copy of a bare remote
+func clone(t *testing.T, remote string) *Repo {
+	dir := t.TempDir()
+	git(t, "clone", "--quiet", remote, dir)
+	git(t, "-C", dir, "checkout", "--quiet", "-B", "main")
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func write(t *testing.T, r *Repo, name, content string) {
+	err := os.WriteFile(filepath.Join(r.Dir, name), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoryAndLastChanged(t *testing.T) {
+	setupGit(t)
+	r, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, r, "Note.md", "one\n")
+	if ok, err := r.CommitAll("first"); !ok || err != nil {
+		t.Fatalf("Expected commit, got %v %v", ok, err)
+	}
+	write(t, r, "Note.md", "two\n")
+	r.CommitAll("second")
+	if ok, _ := r.CommitAll("nothing"); ok {
+		t.Error("Expected no commit for a clean tree")
+	}
+
+	revs, err := r.History("Note.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 || revs[0].Subject != "second" || revs[1].Subject != "first" {
+		t.Fatalf("Unexpected history %v", revs)
+	}
+
+	last, ok, err := r.LastChanged("Note.md")
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	if last.Hash != revs[0].Hash || last.Author != "Glenda" {
+		t.Errorf("Unexpected last change %v", last)
+	}
+
+	_, ok, _ = r.LastChanged("Missing.md")
+	if ok {
+		t.Error("Expected no revision for an uncommitted note")
+	}
+}
+
+func TestPullConflict(t *testing.T) {
+	setupGit(t)
+	remote := t.TempDir()
+	git(t, "init", "--quiet", "--bare", remote)
+
+	a := clone(t, remote)
+	write(t, a, "Note.md", "base\n")
+	a.CommitAll("base")
+	if err := a.Push("origin", "main"); err != nil {
+		t.Fatal(err)
+	}
+
+	b := clone(t, remote)
+	if _, err := b.Pull("origin", "main"); err != nil {
+		t.Fatal(err)
+	}
+
+	write(t, a, "Note.md", "from a\n")
+	a.CommitAll("a")
+	a.Push("origin", "main")
+
+	write(t, b, "Note.md", "from b\n")
+	b.CommitAll("b")
+	conflicts, err := b.Pull("origin", "main")
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected conflict error, got %v", err)
+	}
+	if len(conflicts) != 1 || conflicts[0] != "Note.md" {
+		t.Errorf("Expected Note.md to be conflicted, got %v", conflicts)
+	}
+}
+
+func TestAutoCommit(t *testing.T) {
+	setupGit(t)
+	r, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool, 1)
+	ac := NewAutoCommitter(r, 10*time.Millisecond)
+	ac.OnCommit = func(committed bool, err error) { done <- committed && err == nil }
+
+	write(t, r, "A.md", "a")
+	ac.Changed("A.md")
+	write(t, r, "B.md", "b")
+	ac.Changed("B.md")
+
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("Expected debounced commit to succeed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for auto commit")
+	}
+	revs, _ := r.History("B.md")
+	if len(revs) != 1 || revs[0].Subject != "pumice: update 2 notes" {
+		t.Errorf("Expected one commit covering both notes, got %v", revs)
+	}
+}
+
+func TestCommitOnlyNotes(t *testing.T) {
+	setupGit(t)
+	r, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, r, "Kept.md", "kept")
+	write(t, r, "Gone.md", "gone")
+	write(t, r, "Same.md", "same")
+	r.CommitAll("base")
+
+	write(t, r, "Kept.md", "changed")
+	write(t, r, "[draft] *.md", "odd name")
+	write(t, r, "unrelated.txt", "not a note")
+	if err := os.Remove(filepath.Join(r.Dir, "Gone.md")); err != nil {
+		t.Fatal(err)
+	}
+	var subjects []data.VaultLocation
+	ok, err := r.Commit([]data.VaultLocation{"Kept.md", "Gone.md", "[draft] *.md", "Same.md", "Never.md"}, func(changed []data.VaultLocation) string {
+		subjects = changed
+		return "notes"
+	})
+	if !ok || err != nil {
+		t.Fatalf("Expected commit, got %v %v", ok, err)
+	}
+	if len(subjects) != 3 {
+		t.Errorf("Expected the message to cover the three changed notes, got %v", subjects)
+	}
+	status, err := r.run("status", "--porcelain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "?? unrelated.txt\n" {
+		t.Errorf("Expected only unrelated.txt left over, got %q", status)
+	}
+
+	if ok, err := r.Commit([]data.VaultLocation{"Kept.md"}, func([]data.VaultLocation) string { return "again" }); ok || err != nil {
+		t.Errorf("Expected nothing to commit, got %v %v", ok, err)
+	}
+}
+
+func TestConflictMarkers(t *testing.T) {
+	if !HasConflictMarkers([]byte("a\n<<<<<<< HEAD\nb\n=======\nc\n>>>>>>> origin\n")) {
+		t.Error("Expected markers to be found")
+	}
+	if HasConflictMarkers([]byte("# heading\n=======\n")) {
+		t.Error("Setext headings are not conflicts")
+	}
+}
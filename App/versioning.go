@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/cowsed/Pumice/App/data"
+	"github.com/cowsed/Pumice/App/snapshot"
+	"github.com/cowsed/Pumice/App/vcs"
+)
+
+// changeRecorder is told about the content of every note as it is indexed
+// and keeps the snapshot store and git up to date
+type changeRecorder struct {
+	snaps     *snapshot.Store
+	repo      *vcs.Repo
+	committer *vcs.AutoCommitter
+}
+
+func setupVersioning(vaultPath data.OSPath, cfg Config) *changeRecorder {
+	cr := &changeRecorder{}
+
+	snaps, err := openSnapshotStore(vaultPath, cfg)
+	if err != nil {
+		slog.Error("Unable to open snapshot store, history is disabled", "err", err)
+	} else {
+		cr.snaps = snaps
+	}
+
+	if !vcs.Available() {
+		return cr
+	}
+	repo, err := vcs.Open(vaultPath.String())
+	if err != nil {
+		slog.Debug("Vault is not version controlled", "err", err)
+		return cr
+	}
+	cr.repo = repo
+
+	conflicts, err := repo.ConflictedNotes()
+	if err != nil {
+		slog.Warn("Could not check for merge conflicts", "err", err)
+	}
+	for _, loc := range conflicts {
+		slog.Warn("Note has unresolved merge conflicts", "path", loc)
+	}
+
+	if cfg.Git.AutoCommit {
+		cr.committer = vcs.NewAutoCommitter(repo, time.Duration(cfg.Git.DebounceSeconds)*time.Second)
+	}
+	return cr
+}
+
+// Repo is the git repository of the vault, nil if it is not version controlled
+func (cr *changeRecorder) Repo() *vcs.Repo {
+	if cr == nil {
+		return nil
+	}
+	return cr.repo
+}
+
+// Record snapshots a note and queues it for the next auto commit.
+// Without snapshots every note is queued, git leaves out the ones that did not change
+func (cr *changeRecorder) Record(loc data.VaultLocation, content []byte) {
+	if cr == nil {
+		return
+	}
+	if marked(loc, content) {
+		// the vault seals these before they get here, plaintext of one must not be kept either way
+		slog.Warn("Not recording a note marked encrypted that is not sealed", "path", loc)
+		return
+	}
+	changed := true
+	if cr.snaps != nil {
+		v, snapped, err := cr.snaps.Record(loc, content)
+		if err != nil {
+			slog.Warn("Could not snapshot note", "path", loc, "err", err)
+		} else if changed = snapped; snapped {
+			slog.Debug("Snapshotted note", "path", loc, "version", v.Short())
+		}
+	}
+	if changed && cr.committer != nil {
+		cr.committer.Changed(loc)
+	}
+}
+
+// Removed tells git about a note that was moved out of the vault.
+// Its snapshots are kept so it can still be restored
+func (cr *changeRecorder) Removed(loc data.VaultLocation) {
+	if cr == nil || cr.committer == nil {
+		return
+	}
+	cr.committer.Changed(loc)
+}
+
+func (cr *changeRecorder) Annotate(caches []data.NoteCache) {
+	if cr == nil || cr.repo == nil {
+		return
+	}
+	err := cr.repo.Annotate(caches)
+	if err != nil {
+		slog.Warn("Could not read git history", "err", err)
+	}
+}
+
+// Close commits anything still waiting on the debounce timer
+func (cr *changeRecorder) Close() {
+	if cr == nil || cr.committer == nil {
+		return
+	}
+	_, err := cr.committer.Flush()
+	if err != nil {
+		slog.Error("Final auto commit failed", "err", err)
+	}
+}
+
+// Forget drops the snapshots of a note that is now stored encrypted
+func (cr *changeRecorder) Forget(loc data.VaultLocation) {
+	if cr == nil || cr.snaps == nil {
+		return
+	}
+	err := cr.snaps.Forget(loc)
+	if err != nil {
+		slog.Warn("Could not drop plaintext snapshots", "path", loc, "err", err)
+	}
+}
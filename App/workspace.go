@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"log/slog"
@@ -10,11 +11,17 @@ import (
 
 	"github.com/cowsed/Pumice/App/config"
 	"github.com/cowsed/Pumice/App/data"
+	"github.com/cowsed/Pumice/App/snapshot"
 )
 
 var cacheFolderName data.VaultLocation = ".cache"
 var dataCacheFilename string = "data.json"
 var dataCachePath data.VaultLocation = cacheFolderName.Append(dataCacheFilename)
+var snapshotFolderName string = "snapshots"
+var snapshotPath data.VaultLocation = cacheFolderName.Append(snapshotFolderName)
+
+// removed notes are moved here rather than deleted
+var trashFolderName data.VaultLocation = ".trash"
 
 type BackendState int
 
@@ -29,6 +36,28 @@ const (
 	Error
 )
 
+func (bs BackendState) String() string {
+	switch bs {
+	case Opening:
+		return "opening"
+	case LoadingConfig:
+		return "loading-config"
+	case LoadingThemes:
+		return "loading-themes"
+	case LoadingExtensions:
+		return "loading-extensions"
+	case LoadingCache:
+		return "loading-cache"
+	case BuildingCache:
+		return "building-cache"
+	case Ready:
+		return "ready"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("BackendState(%d)", int(bs))
+}
+
 type BackendUpdate interface {
 	Describe() string
 }
@@ -72,6 +101,10 @@ func loadWorkspace(flags Flags, updates chan BackendUpdate) {
 
 }
 
+func openSnapshotStore(vault_location data.OSPath, cfg Config) (*snapshot.Store, error) {
+	return snapshot.Open(data.ToOSPath(vault_location, snapshotPath), cfg.Snapshots)
+}
+
 func loadWorkspaceCache(vault_location data.OSPath) (*data.VaultCache, error) {
 	var cache_folder data.OSPath = data.OSPath(data.ToOSPath(vault_location, cacheFolderName))
 	err := os.MkdirAll(string(cache_folder), 0777)
@@ -106,5 +139,20 @@ func loadWorkspaceCache(vault_location data.OSPath) (*data.VaultCache, error) {
 		return nil, err
 	}
 
-	return &data.VaultCache{}, nil
+	return &dc, nil
+}
+
+// saveWorkspaceCache writes the index to .cache/data.json. Encrypted notes are redacted
+func saveWorkspaceCache(vault_location data.OSPath, dc data.VaultCache) error {
+	var cache_folder data.OSPath = data.OSPath(data.ToOSPath(vault_location, cacheFolderName))
+	err := os.MkdirAll(string(cache_folder), 0777)
+	if err != nil {
+		return err
+	}
+
+	bs, err := json.Marshal(dc.Redacted())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(data.ToOSPath(vault_location, dataCachePath), bs, 0644)
 }
@@ -0,0 +1,319 @@
+// Package client talks 9P2000 to a server over one connection.
+// Any number of goroutines may use a Client at once, their requests share the connection.
+// A request is flushed when the context of its Fid is done, so a server that never answers does not hang the caller
+package client
+
+import (
+	"PumiceBackend/proto9p"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"sync"
+)
+
+var Version = "9P2000"
+
+// DefaultMSize is the msize asked for, the server may agree to less
+var DefaultMSize uint32 = 65536
+
+var (
+	ErrClosed     = errors.New("client closed")
+	ErrVersion    = errors.New("server does not speak " + Version)
+	ErrNoTags     = errors.New("too many requests in flight")
+	ErrNoFids     = errors.New("out of fids")
+	ErrBadReply   = errors.New("unexpected reply")
+	ErrShortWrite = errors.New("server wrote less than asked")
+	ErrSeek       = errors.New("seek before the start of the file")
+)
+
+// Error is an Rerror from the server
+type Error struct {
+	Ename string
+}
+
+func (e *Error) Error() string {
+	return e.Ename
+}
+
+// enameErrors are the io/fs errors that common enames mean
+var enameErrors = map[string]error{
+	"file does not exist":       fs.ErrNotExist,
+	"no such file or directory": fs.ErrNotExist,
+	"file already exists":       fs.ErrExist,
+	"file exists":               fs.ErrExist,
+	"permission denied":         fs.ErrPermission,
+	"invalid argument":          fs.ErrInvalid,
+}
+
+// Is lets errors.Is see the io/fs error an ename stands for
+func (e *Error) Is(target error) bool {
+	err, known := enameErrors[e.Ename]
+	return known && err == target
+}
+
+// request is any T-message, the client picks its tag
+type request interface {
+	proto9p.FCall
+	SetMessageTag(proto9p.Tag)
+}
+
+type Client struct {
+	conn  io.ReadWriteCloser
+	msize uint32
+
+	// one message is written at a time
+	writing sync.Mutex
+
+	lock    sync.Mutex
+	pending map[proto9p.Tag]chan proto9p.FCall
+	// tags of flushed requests, kept out of use until their Rflush
+	flushing map[proto9p.Tag]bool
+	nextTag  proto9p.Tag
+	nextFid  proto9p.Fid
+	// fids clunked and free to use again
+	freeFids []proto9p.Fid
+	// why replies stopped coming, once they have
+	err error
+}
+
+// Dial connects to a server, network and addr are as for net.Dial
+func Dial(network, addr string) (*Client, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient agrees on a version with the server at the other end of conn
+func NewClient(conn io.ReadWriteCloser) (*Client, error) {
+	c := &Client{
+		conn:     conn,
+		msize:    DefaultMSize,
+		pending:  map[proto9p.Tag]chan proto9p.FCall{},
+		flushing: map[proto9p.Tag]bool{},
+	}
+	// nothing else is in flight yet, so the reply is read right here
+	err := c.write(&proto9p.TVersion{Tag: proto9p.NOTAG, MSize: DefaultMSize, Version: Version})
+	if err != nil {
+		return nil, err
+	}
+	reply, err := proto9p.ParseFCallMax(conn, DefaultMSize)
+	if err != nil {
+		return nil, err
+	}
+	switch reply := reply.(type) {
+	case *proto9p.RVersion:
+		if reply.Version != Version {
+			return nil, fmt.Errorf("%w, it offered %s", ErrVersion, reply.Version)
+		}
+		if reply.Msize > DefaultMSize || reply.Msize <= proto9p.IOHDRSZ {
+			return nil, fmt.Errorf("%w: msize %d", ErrBadReply, reply.Msize)
+		}
+		c.msize = reply.Msize
+	case *proto9p.RError:
+		return nil, &Error{Ename: reply.Ename}
+	default:
+		return nil, fmt.Errorf("%w %v to Tversion", ErrBadReply, reply)
+	}
+	go c.readReplies()
+	return c, nil
+}
+
+// MSize is the largest message either side sends
+func (c *Client) MSize() uint32 {
+	return c.msize
+}
+
+// Close hangs up. Requests still waiting for a reply fail with ErrClosed
+func (c *Client) Close() error {
+	c.lock.Lock()
+	if c.err == nil {
+		c.err = ErrClosed
+	}
+	c.lock.Unlock()
+	return c.conn.Close()
+}
+
+// Attach starts using the file tree aname of the server as uname. Authentication is not supported.
+// Requests made through the Fid, and the Fids walked from it, stop waiting once ctx is done
+func (c *Client) Attach(ctx context.Context, uname, aname string) (*Fid, error) {
+	fid, err := c.newFid()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.rpc(ctx, &proto9p.TAttach{Fid: fid, Afid: proto9p.NOFID, Uname: uname, Aname: aname})
+	if err != nil {
+		c.freeFid(fid)
+		return nil, err
+	}
+	attach, ok := reply.(*proto9p.RAttach)
+	if !ok {
+		c.freeFid(fid)
+		return nil, fmt.Errorf("%w %v to Tattach", ErrBadReply, reply)
+	}
+	return &Fid{c: c, ctx: ctx, fid: fid, qid: attach.Qid}, nil
+}
+
+// rpc sends call and waits for its reply. An Rerror is returned as an *Error.
+// Once ctx is done the request is flushed, and ctx.Err() returned unless the reply beat the Rflush
+func (c *Client) rpc(ctx context.Context, call request) (proto9p.FCall, error) {
+	tag, replies, err := c.newTag()
+	if err != nil {
+		return nil, err
+	}
+	call.SetMessageTag(tag)
+	err = c.write(call)
+	if err != nil {
+		c.lock.Lock()
+		delete(c.pending, tag)
+		c.lock.Unlock()
+		return nil, err
+	}
+
+	var reply proto9p.FCall
+	ok := true
+	select {
+	case reply, ok = <-replies:
+	case <-ctx.Done():
+		if !c.flush(tag) {
+			return nil, ctx.Err()
+		}
+		select {
+		case reply, ok = <-replies:
+		default:
+			return nil, ctx.Err()
+		}
+	}
+	if !ok {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return nil, c.err
+	}
+	if rerr, isErr := reply.(*proto9p.RError); isErr {
+		return nil, &Error{Ename: rerr.Ename}
+	}
+	return reply, nil
+}
+
+// flush asks the server to drop the request tagged oldtag and waits for it to agree.
+// oldtag is not used again until then. It says whether the connection lasted that long
+func (c *Client) flush(oldtag proto9p.Tag) bool {
+	c.lock.Lock()
+	c.flushing[oldtag] = true
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.flushing, oldtag)
+		delete(c.pending, oldtag)
+		c.lock.Unlock()
+	}()
+
+	tag, replies, err := c.newTag()
+	if err != nil {
+		return false
+	}
+	err = c.write(&proto9p.TFlush{Tag: tag, Oldtag: oldtag})
+	if err != nil {
+		c.lock.Lock()
+		delete(c.pending, tag)
+		c.lock.Unlock()
+		return false
+	}
+	// a server has to answer every Tflush, a dead one is ended by Close
+	_, ok := <-replies
+	return ok
+}
+
+func (c *Client) write(call proto9p.FCall) error {
+	bs, err := proto9p.WriteFCall(call)
+	if err != nil {
+		return err
+	}
+	if uint32(len(bs)) > c.msize {
+		return fmt.Errorf("%v is %d bytes, more than msize %d: %w", call.Type(), len(bs), c.msize, proto9p.ErrMessageSize)
+	}
+	c.writing.Lock()
+	defer c.writing.Unlock()
+	_, err = c.conn.Write(bs)
+	return err
+}
+
+// readReplies hands every reply to the request waiting for it, until the connection fails
+func (c *Client) readReplies() {
+	for {
+		reply, err := proto9p.ParseFCallMax(c.conn, c.msize)
+		if err != nil {
+			c.lock.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			for tag, replies := range c.pending {
+				close(replies)
+				delete(c.pending, tag)
+			}
+			c.lock.Unlock()
+			return
+		}
+		c.lock.Lock()
+		replies, exists := c.pending[reply.MessageTag()]
+		delete(c.pending, reply.MessageTag())
+		c.lock.Unlock()
+		// a reply nobody asked for is dropped
+		if exists {
+			replies <- reply
+		}
+	}
+}
+
+// newTag picks a tag no request in flight has
+func (c *Client) newTag() (proto9p.Tag, chan proto9p.FCall, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	// NOTAG is only for Tversion
+	if len(c.pending)+len(c.flushing) >= int(proto9p.NOTAG) {
+		return 0, nil, ErrNoTags
+	}
+	for {
+		tag := c.nextTag
+		c.nextTag = (c.nextTag + 1) % proto9p.NOTAG
+		if _, inUse := c.pending[tag]; !inUse && !c.flushing[tag] {
+			replies := make(chan proto9p.FCall, 1)
+			c.pending[tag] = replies
+			return tag, replies, nil
+		}
+	}
+}
+
+func (c *Client) newFid() (proto9p.Fid, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if n := len(c.freeFids); n > 0 {
+		fid := c.freeFids[n-1]
+		c.freeFids = c.freeFids[:n-1]
+		return fid, nil
+	}
+	if c.nextFid == proto9p.NOFID {
+		return 0, ErrNoFids
+	}
+	fid := c.nextFid
+	c.nextFid++
+	return fid, nil
+}
+
+func (c *Client) freeFid(fid proto9p.Fid) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.freeFids = append(c.freeFids, fid)
+}
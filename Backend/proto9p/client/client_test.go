@@ -0,0 +1,219 @@
+package client
+
+import (
+	"PumiceBackend/proto9p"
+	pfs "PumiceBackend/proto9p/fs"
+	"PumiceBackend/server"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// serveDir serves a directory holding files and attaches to it
+func serveDir(t *testing.T, files map[string]string) (*Client, *Fid, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv, err := pfs.NewServer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, serverConn := net.Pipe()
+	go server.Serve(serverConn, serverConn, &srv)
+
+	c, err := NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	root, err := c.Attach(context.Background(), "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, root, dir
+}
+
+func TestFS(t *testing.T) {
+	_, root, _ := serveDir(t, map[string]string{
+		"a.md":           "alpha",
+		"notes/b.md":     "beta",
+		"notes/deep/c":   "gamma",
+		"notes/empty.md": "",
+	})
+	err := fstest.TestFS(NewFS(root), "a.md", "notes/b.md", "notes/deep/c", "notes/empty.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewFS(root).Open("missing.md")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("opening a missing file gave %v, want %v", err, fs.ErrNotExist)
+	}
+	_, err = fs.Stat(NewFS(root), "notes/missing/deeper")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("a partial walk gave %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 20000)
+	_, root, _ := serveDir(t, map[string]string{"big": string(content)})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bs, err := fs.ReadFile(NewFS(root), "big")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(bs, content) {
+				t.Errorf("read %d bytes that differ from the file", len(bs))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestChanges(t *testing.T) {
+	_, root, dir := serveDir(t, map[string]string{"old.md": "old"})
+
+	fid, err := root.Walk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := fid.Create("new.md", 0644, proto9p.Ordwr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("new "), 50000)
+	if n, err := file.Write(content); err != nil || n != len(content) {
+		t.Fatalf("wrote %d bytes: %v", n, err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := io.ReadAll(file)
+	if err != nil || !bytes.Equal(bs, content) {
+		t.Fatalf("read back %d bytes: %v", len(bs), err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := root.Walk("old.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Wstat(proto9p.DontTouch.WithName("renamed.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Stat(); err == nil {
+		t.Fatal("fid still usable after remove")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "new.md" {
+		t.Fatalf("directory holds %v, want only new.md", entries)
+	}
+
+	if _, err := root.Walk("old.md"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("walking to a removed file gave %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	c, root, _ := serveDir(t, map[string]string{"a.md": "a"})
+	c.Close()
+	if _, err := root.Walk("a.md"); err == nil {
+		t.Fatal("walked after closing")
+	}
+}
+
+// stall answers Tversion and Tattach, then leaves every other request but Tflush hanging
+func stall(t *testing.T, conn net.Conn, flushed chan<- proto9p.Tag) {
+	for {
+		call, err := proto9p.ParseFCall(conn)
+		if err != nil {
+			return
+		}
+		var reply proto9p.FCall
+		switch call := call.(type) {
+		case *proto9p.TVersion:
+			reply = &proto9p.RVersion{Tag: call.Tag, Msize: call.MSize, Version: call.Version}
+		case *proto9p.TAttach:
+			reply = &proto9p.RAttach{Tag: call.Tag}
+		case *proto9p.TFlush:
+			flushed <- call.Oldtag
+			reply = &proto9p.RFlush{Tag: call.Tag}
+		default:
+			continue
+		}
+		bs, err := proto9p.WriteFCall(reply)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Write(bs)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	conn, serverConn := net.Pipe()
+	flushed := make(chan proto9p.Tag, 1)
+	go stall(t, serverConn, flushed)
+	c, err := NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	root, err := c.Attach(context.Background(), "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	walked := make(chan error, 1)
+	go func() {
+		_, err := root.WalkContext(ctx, "a.md")
+		walked <- err
+	}()
+	cancel()
+	if err := <-walked; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled walk gave %v, want %v", err, context.Canceled)
+	}
+	select {
+	case <-flushed:
+	default:
+		t.Fatal("cancelled walk was not flushed")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.pending) != 0 || len(c.flushing) != 0 {
+		t.Fatalf("tags still held after the flush: %v pending, %v flushing", c.pending, c.flushing)
+	}
+}
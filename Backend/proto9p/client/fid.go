@@ -0,0 +1,322 @@
+package client
+
+import (
+	"PumiceBackend/proto9p"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+)
+
+// Fid is a file on the server. Walking from it gives new Fids, opening it makes it a File
+type Fid struct {
+	c *Client
+	// requests for the fid stop waiting once it is done, the ones that free the fid excepted
+	ctx context.Context
+	fid proto9p.Fid
+	qid proto9p.Qid
+	// set once opened, the most data one read or write carries
+	iounit uint32
+	// set by the first clunk or remove, the fid number may belong to another Fid after it
+	gone atomic.Bool
+}
+
+func (f *Fid) Qid() proto9p.Qid {
+	return f.qid
+}
+
+// Walk gives a new Fid for the file names leads to from f. No names clones f
+func (f *Fid) Walk(names ...string) (*Fid, error) {
+	return f.WalkContext(f.ctx, names...)
+}
+
+// WalkContext is Walk, with the new Fid and the walk itself bound to ctx instead of the context of f
+func (f *Fid) WalkContext(ctx context.Context, names ...string) (*Fid, error) {
+	fid, err := f.c.newFid()
+	if err != nil {
+		return nil, err
+	}
+	walked := &Fid{c: f.c, ctx: ctx, fid: fid, qid: f.qid}
+	from := f.fid
+	for first := true; first || len(names) > 0; first = false {
+		step := names[:min(len(names), proto9p.MaxWElem)]
+		names = names[len(step):]
+		err = walked.walkStep(from, step)
+		if err != nil {
+			if first {
+				// newfid only comes to be when the whole walk works
+				f.c.freeFid(fid)
+			} else {
+				walked.Clunk()
+			}
+			return nil, err
+		}
+		from = fid
+	}
+	return walked, nil
+}
+
+// walkStep walks from the fid from to f by at most MaxWElem names
+func (f *Fid) walkStep(from proto9p.Fid, names []string) error {
+	reply, err := f.c.rpc(f.ctx, &proto9p.TWalk{Fid: from, NewFid: f.fid, WNames: names})
+	if err != nil {
+		return err
+	}
+	walk, ok := reply.(*proto9p.RWalk)
+	if !ok || len(walk.Wqids) > len(names) {
+		return fmt.Errorf("%w %v to Twalk", ErrBadReply, reply)
+	}
+	if len(walk.Wqids) < len(names) {
+		// the server only says why when the first name fails
+		return fmt.Errorf("walk stopped at %s: %w", names[len(walk.Wqids)], fs.ErrNotExist)
+	}
+	if len(walk.Wqids) > 0 {
+		f.qid = walk.Wqids[len(walk.Wqids)-1]
+	}
+	return nil
+}
+
+// Open opens f, which is then read and written through the File
+func (f *Fid) Open(mode proto9p.Mode) (*File, error) {
+	reply, err := f.c.rpc(f.ctx, &proto9p.TOpen{Fid: f.fid, Mode: mode})
+	if err != nil {
+		return nil, err
+	}
+	open, ok := reply.(*proto9p.ROpen)
+	if !ok {
+		return nil, fmt.Errorf("%w %v to Topen", ErrBadReply, reply)
+	}
+	f.opened(open.Qid, open.IOUnit)
+	return &File{fid: f}, nil
+}
+
+// Create makes the file name in the directory f and opens it. f is the new file afterwards
+func (f *Fid) Create(name string, perm proto9p.Perm, mode proto9p.Mode) (*File, error) {
+	reply, err := f.c.rpc(f.ctx, &proto9p.TCreate{Fid: f.fid, Name: name, Perm: perm, Mode: mode})
+	if err != nil {
+		return nil, err
+	}
+	create, ok := reply.(*proto9p.RCreate)
+	if !ok {
+		return nil, fmt.Errorf("%w %v to Tcreate", ErrBadReply, reply)
+	}
+	f.opened(create.Qid, create.IOUnit)
+	return &File{fid: f}, nil
+}
+
+func (f *Fid) opened(qid proto9p.Qid, iounit uint32) {
+	f.qid = qid
+	most := f.c.msize - proto9p.IOHDRSZ
+	if iounit == 0 || iounit > most {
+		iounit = most
+	}
+	f.iounit = iounit
+}
+
+// ReadAt reads len(p) bytes from off, in as many requests as it takes.
+// It reads less only at the end of the file, and then says io.EOF
+func (f *Fid) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		count := min(len(p)-n, int(f.iounit))
+		reply, err := f.c.rpc(f.ctx, &proto9p.TRead{Fid: f.fid, Offset: uint64(off) + uint64(n), Count: uint32(count)})
+		if err != nil {
+			return n, err
+		}
+		read, ok := reply.(*proto9p.RRead)
+		if !ok || len(read.Data) > count {
+			return n, fmt.Errorf("%w %v to Tread", ErrBadReply, reply)
+		}
+		if len(read.Data) == 0 {
+			return n, io.EOF
+		}
+		n += copy(p[n:], read.Data)
+	}
+	return n, nil
+}
+
+// WriteAt writes p at off, in as many requests as it takes
+func (f *Fid) WriteAt(p []byte, off int64) (int, error) {
+	n := 0
+	for first := true; first || n < len(p); first = false {
+		count := min(len(p)-n, int(f.iounit))
+		reply, err := f.c.rpc(f.ctx, &proto9p.TWrite{Fid: f.fid, Offset: uint64(off) + uint64(n), Data: p[n : n+count]})
+		if err != nil {
+			return n, err
+		}
+		write, ok := reply.(*proto9p.RWrite)
+		if !ok || int(write.Count) > count {
+			return n, fmt.Errorf("%w %v to Twrite", ErrBadReply, reply)
+		}
+		n += int(write.Count)
+		if int(write.Count) < count {
+			return n, ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+// ReadDir lists the open directory f from the start
+func (f *Fid) ReadDir() ([]proto9p.Stat, error) {
+	stats := []proto9p.Stat{}
+	offset := uint64(0)
+	for {
+		reply, err := f.c.rpc(f.ctx, &proto9p.TRead{Fid: f.fid, Offset: offset, Count: f.iounit})
+		if err != nil {
+			return stats, err
+		}
+		read, ok := reply.(*proto9p.RRead)
+		if !ok {
+			return stats, fmt.Errorf("%w %v to Tread", ErrBadReply, reply)
+		}
+		if len(read.Data) == 0 {
+			return stats, nil
+		}
+		offset += uint64(len(read.Data))
+		entries := bytes.NewReader(read.Data)
+		r := proto9p.TypedReader{Reader: entries}
+		for entries.Len() > 0 {
+			st, err := r.ReadStat()
+			if err != nil {
+				return stats, err
+			}
+			stats = append(stats, st)
+		}
+	}
+}
+
+func (f *Fid) Stat() (proto9p.Stat, error) {
+	reply, err := f.c.rpc(f.ctx, &proto9p.TStat{Fid: f.fid})
+	if err != nil {
+		return proto9p.Stat{}, err
+	}
+	stat, ok := reply.(*proto9p.RStat)
+	if !ok {
+		return proto9p.Stat{}, fmt.Errorf("%w %v to Tstat", ErrBadReply, reply)
+	}
+	return stat.Stat, nil
+}
+
+// Wstat changes what st sets, start from proto9p.DontTouch
+func (f *Fid) Wstat(st proto9p.Stat) error {
+	reply, err := f.c.rpc(f.ctx, &proto9p.TWStat{Fid: f.fid, Stat: st})
+	if err != nil {
+		return err
+	}
+	if _, ok := reply.(*proto9p.RWStat); !ok {
+		return fmt.Errorf("%w %v to Twstat", ErrBadReply, reply)
+	}
+	return nil
+}
+
+// Remove removes the file and clunks f, even if the file could not be removed
+func (f *Fid) Remove() error {
+	if f.gone.Swap(true) {
+		return fs.ErrClosed
+	}
+	reply, err := f.c.rpc(context.WithoutCancel(f.ctx), &proto9p.TRemove{Fid: f.fid})
+	f.c.freeFid(f.fid)
+	if err != nil {
+		return err
+	}
+	if _, ok := reply.(*proto9p.RRemove); !ok {
+		return fmt.Errorf("%w %v to Tremove", ErrBadReply, reply)
+	}
+	return nil
+}
+
+// Clunk tells the server f is no longer used
+func (f *Fid) Clunk() error {
+	if f.gone.Swap(true) {
+		return fs.ErrClosed
+	}
+	reply, err := f.c.rpc(context.WithoutCancel(f.ctx), &proto9p.TClunk{Fid: f.fid})
+	// the fid is gone even if the server says otherwise
+	f.c.freeFid(f.fid)
+	if err != nil {
+		return err
+	}
+	if _, ok := reply.(*proto9p.RClunk); !ok {
+		return fmt.Errorf("%w %v to Tclunk", ErrBadReply, reply)
+	}
+	return nil
+}
+
+// File is an open Fid, read and written like an *os.File
+type File struct {
+	fid *Fid
+
+	lock   sync.Mutex
+	offset int64
+}
+
+var (
+	_ io.ReadWriteCloser = &File{}
+	_ io.ReaderAt        = &File{}
+	_ io.WriterAt        = &File{}
+	_ io.Seeker          = &File{}
+)
+
+func (f *File) Fid() *Fid {
+	return f.fid
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	n, err := f.fid.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	n, err := f.fid.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	return f.fid.ReadAt(p, off)
+}
+
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	return f.fid.WriteAt(p, off)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		st, err := f.fid.Stat()
+		if err != nil {
+			return f.offset, err
+		}
+		offset += int64(st.Length())
+	}
+	if offset < 0 {
+		return f.offset, ErrSeek
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *File) Stat() (proto9p.Stat, error) {
+	return f.fid.Stat()
+}
+
+// Close clunks the fid of the file
+func (f *File) Close() error {
+	return f.fid.Clunk()
+}
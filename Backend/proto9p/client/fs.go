@@ -0,0 +1,148 @@
+package client
+
+import (
+	"PumiceBackend/proto9p"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// FS reads the tree under a Fid through io/fs, so anything taking an fs.FS can use a 9P server
+type FS struct {
+	root *Fid
+}
+
+var (
+	_ fs.FS     = FS{}
+	_ fs.StatFS = FS{}
+)
+
+// NewFS serves the tree under root, usually the Fid Attach gave
+func NewFS(root *Fid) FS {
+	return FS{root: root}
+}
+
+// walk gives a Fid for name, which the caller clunks
+func (f FS) walk(op, name string) (*Fid, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	names := []string{}
+	if name != "." {
+		names = strings.Split(name, "/")
+	}
+	fid, err := f.root.Walk(names...)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return fid, nil
+}
+
+func (f FS) Open(name string) (fs.File, error) {
+	fid, err := f.walk("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fid.Open(proto9p.Oread)
+	if err != nil {
+		fid.Clunk()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &fsFile{File: file, name: name}, nil
+}
+
+func (f FS) Stat(name string) (fs.FileInfo, error) {
+	fid, err := f.walk("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	defer fid.Clunk()
+	st, err := fid.Stat()
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return fileInfo{st: st, name: path.Base(name)}, nil
+}
+
+// fsFile is a File opened through FS
+type fsFile struct {
+	*File
+	name string
+	// entries of a directory not yet returned by ReadDir, listed on the first call
+	entries []fs.DirEntry
+	listed  bool
+}
+
+var _ fs.ReadDirFile = &fsFile{}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	st, err := f.File.Stat()
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: err}
+	}
+	return fileInfo{st: st, name: path.Base(f.name)}, nil
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return n, err
+}
+
+func (f *fsFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.listed {
+		stats, err := f.fid.ReadDir()
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: err}
+		}
+		for _, st := range stats {
+			f.entries = append(f.entries, fs.FileInfoToDirEntry(fileInfo{st: st, name: st.Name()}))
+		}
+		f.listed = true
+	}
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	entries := f.entries[:min(n, len(f.entries))]
+	f.entries = f.entries[len(entries):]
+	return entries, nil
+}
+
+// fileInfo is a stat from the server. name is the one asked for, the server calls its root /
+type fileInfo struct {
+	st   proto9p.Stat
+	name string
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return int64(fi.st.Length()) }
+func (fi fileInfo) ModTime() time.Time { return fi.st.Mtime() }
+func (fi fileInfo) IsDir() bool        { return fi.st.IsDir() }
+func (fi fileInfo) Sys() any           { return fi.st }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	perm := fi.st.Mode()
+	mode := fs.FileMode(perm) & fs.ModePerm
+	if perm&proto9p.DMDIR != 0 {
+		mode |= fs.ModeDir
+	}
+	if perm&proto9p.DMAPPEND != 0 {
+		mode |= fs.ModeAppend
+	}
+	if perm&proto9p.DMEXCL != 0 {
+		mode |= fs.ModeExclusive
+	}
+	if perm&proto9p.DMTMP != 0 {
+		mode |= fs.ModeTemporary
+	}
+	return mode
+}
@@ -3,17 +3,57 @@ package fs
 import (
 	"PumiceBackend/proto9p"
 	"PumiceBackend/server"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
+	"hash/fnv"
+	"io"
+	iofs "io/fs"
 	"log/slog"
+	"math"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"sync"
 )
 
 var Version = "9P2000"
 
+// smallest msize agreed to, room for a directory entry with short names
+const minMSize = 256
+
+// owner reported for files when the system cannot say who owns them
+var defaultOwner = "none"
+
+var (
+	ErrUnknownFid    = errors.New("unknown fid")
+	ErrAlreadyOpen   = errors.New("file already open")
+	ErrNotOpen       = errors.New("file not open")
+	ErrNotReadable   = errors.New("file not open for reading")
+	ErrWalkOpen      = errors.New("cannot walk from an open fid")
+	ErrTooManyWNames = errors.New("too many names in walk")
+	ErrNotDir        = errors.New("not a directory")
+	ErrIsDir         = errors.New("is a directory")
+	ErrBadName       = errors.New("bad file name")
+	ErrReadOnly      = errors.New("read-only file system")
+	ErrBadDirOffset  = errors.New("bad offset in directory read")
+	ErrShortDirRead  = errors.New("count too small for a directory entry")
+	ErrTruncRead     = errors.New("cannot truncate a file opened for reading")
+	ErrNotWritable   = errors.New("file not open for writing")
+	ErrExists        = errors.New("file already exists")
+	ErrRemoveRoot    = errors.New("cannot remove the root")
+	ErrWstat         = errors.New("wstat cannot change that")
+	ErrMSizeTooSmall = errors.New("msize too small")
+	ErrNoAuth        = errors.New("authentication not required")
+)
+
+// rerror answers a request with err, named the same way the server names errors handlers return
+func rerror(tag proto9p.Tag, err error) *proto9p.RError {
+	return &proto9p.RError{Tag: tag, Ename: server.Ename(err)}
+}
+
 func NewServer(root string) (FS, error) {
 
 	if _, err := os.Stat(root); errors.Is(err, os.ErrNotExist) {
@@ -21,194 +61,598 @@ func NewServer(root string) (FS, error) {
 	}
 
 	return FS{
-		maxSize:  65535,
-		root:     root,
-		qids:     map[ServedPath]proto9p.Qid{},
-		qidCount: 0,
-		clients:  map[string]ClientEntry{},
-		Mutex:    sync.Mutex{},
+		maxSize: 65535,
+		root:    root,
 	}, nil
 }
 
+// FidEntry is what a fid refers to. It is locked while a request uses it,
+// so requests on the same fid never run at the same time
 type FidEntry struct {
-	path string
+	sync.Mutex
+	// set once the fid is gone, for requests that were waiting for it
+	clunked bool
+
+	// not updated when a directory above it is renamed through another fid, see Wstat
+	path ServedPath
+	qid  proto9p.Qid
+	// set once the fid is opened
+	file *os.File
+	mode proto9p.Mode
+	dir  *dirCursor
+	// remove the file when the fid is clunked
+	rclose bool
 }
-type ClientEntry struct {
-	attachPoint ServedPath
+
+func (fe *FidEntry) opened() bool {
+	return fe.file != nil
 }
+
+// conn is the session of one client. Nothing in it is shared with other connections
 type conn struct {
+	fs *FS
+	// guards uname and fids, never held while waiting for a FidEntry
+	lock  sync.Mutex
 	uname string
-	fids  map[proto9p.Fid]FidEntry
+	msize uint32
+	fids  map[proto9p.Fid]*FidEntry
+}
+
+// MSize is the largest message of the session, the server maximum until a Tversion says otherwise
+func (conn *conn) MSize() uint32 {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	return conn.msize
+}
+
+func (conn *conn) setMSize(msize uint32) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	conn.msize = msize
+}
+
+// iounit is the most data a Rread or Twrite of the session can carry
+func (conn *conn) iounit() uint32 {
+	return conn.MSize() - proto9p.IOHDRSZ
 }
 
 func (conn *conn) SetUsername(s string) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
 	conn.uname = s
 }
 func (conn *conn) Username() string {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
 	return conn.uname
 }
 
 func (conn *conn) FidInUse(f proto9p.Fid) bool {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
 	_, exists := conn.fids[f]
 	return exists
 }
 
-var _ server.Conn = &conn{}
+// reset clunks every fid of the session
+func (conn *conn) reset() {
+	conn.lock.Lock()
+	fids := conn.fids
+	conn.fids = map[proto9p.Fid]*FidEntry{}
+	conn.uname = ""
+	conn.lock.Unlock()
+
+	for _, fe := range fids {
+		fe.Lock()
+		fe.clunked = true
+		conn.fs.release(fe)
+		fe.Unlock()
+	}
+}
 
-type FS struct {
-	maxSize  uint32
-	root     string // location in server OS filesystem that this server is serving
-	qids     map[ServedPath]proto9p.Qid
-	qidCount uint64
+func (conn *conn) Close() {
+	conn.reset()
+}
 
-	clients map[string]ClientEntry
-	sync.Mutex
+// fid finds the entry of f and locks it. The caller unlocks it when done
+func (conn *conn) fid(f proto9p.Fid) (*FidEntry, error) {
+	conn.lock.Lock()
+	fe, exists := conn.fids[f]
+	conn.lock.Unlock()
+	if !exists {
+		return nil, ErrUnknownFid
+	}
+	fe.Lock()
+	if fe.clunked {
+		fe.Unlock()
+		return nil, ErrUnknownFid
+	}
+	return fe, nil
 }
 
-func (fs *FS) QidFor(rpath ServedPath) (proto9p.Qid, error) {
-	fs.Lock()
-	defer fs.Unlock()
-	q, exists := fs.qids[rpath]
-	if exists {
-		return q, nil
+// claim makes f refer to fe, unless f is already in use
+func (conn *conn) claim(f proto9p.Fid, fe *FidEntry) error {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if _, exists := conn.fids[f]; exists {
+		return fmt.Errorf("fid %v already in use", f)
 	}
+	conn.fids[f] = fe
+	return nil
+}
+
+// clunk forgets f. fe is the locked entry of f
+func (conn *conn) clunk(f proto9p.Fid, fe *FidEntry) {
+	conn.lock.Lock()
+	delete(conn.fids, f)
+	conn.lock.Unlock()
+	fe.clunked = true
+	conn.fs.release(fe)
+}
 
-	q.Qtype = 0
-	q.Vers = 0
+var _ server.Conn = &conn{}
 
-	fs.qidCount++
-	q.Uid = fs.qidCount
-	fs.qids[rpath] = q
+type FS struct {
+	// the largest msize agreed to
+	maxSize uint32
+	root    string // location in server OS filesystem that this server is serving
+	// refuse every request that would change the served directory
+	ReadOnly bool
+}
 
-	return q, nil
+func (f *FS) QidFor(rpath ServedPath) (proto9p.Qid, error) {
+	info, err := os.Lstat(f.osPath(rpath))
+	if err != nil {
+		return proto9p.Qid{}, err
+	}
+	return qidOf(rpath, info), nil
+}
 
+// qidOf is the qid of a file. The path comes from the device and inode where the system
+// has them, so it stays the same across restarts and renames, and the version changes
+// whenever the mtime or size does
+func qidOf(rpath ServedPath, info iofs.FileInfo) proto9p.Qid {
+	id, ok := fileID(info)
+	if !ok {
+		h := fnv.New64a()
+		h.Write([]byte(rpath))
+		id = h.Sum64()
+	}
+	vers := fnv.New32a()
+	binary.Write(vers, binary.LittleEndian, info.ModTime().UnixNano())
+	binary.Write(vers, binary.LittleEndian, info.Size())
+	return proto9p.Qid{
+		// the qid type bits are the top byte of the mode
+		Qtype: proto9p.QType(permOf(info) >> 24),
+		Vers:  vers.Sum32(),
+		Uid:   id,
+	}
 }
 
+// ServedPath is a path relative to the served directory, "" for the directory itself
 type ServedPath string
 
+// servedPath cleans a path given by a client. It can never lead above the served directory
+func servedPath(p string) ServedPath {
+	return ServedPath(strings.TrimPrefix(path.Clean("/"+p), "/"))
+}
+
+func (f *FS) osPath(rpath ServedPath) string {
+	return filepath.Join(f.root, filepath.FromSlash(string(rpath)))
+}
+
 func (f *FS) FileExists(rpath ServedPath) bool {
-	if _, err := os.Stat(path.Join(f.root, string(rpath))); errors.Is(err, os.ErrNotExist) {
+	if _, err := os.Stat(f.osPath(rpath)); errors.Is(err, os.ErrNotExist) {
 		return false
 	}
 	return true
 }
 
 // 9p.io/magic/man2html/5/attach
-func (f *FS) Attach(conn server.Conn, p *proto9p.TAttach) (proto9p.FCall, error) {
+func (f *FS) Attach(ctx context.Context, c server.Conn, p *proto9p.TAttach) (proto9p.FCall, error) {
 	if p.Afid != proto9p.NOFID {
 		return &proto9p.RError{
 			Tag:   p.Tag,
 			Ename: "this server does not support authentication",
 		}, nil
 	}
-	if !f.FileExists(ServedPath(p.Aname)) {
+	aname := servedPath(p.Aname)
+	if c.FidInUse(p.Fid) {
+		return &proto9p.RError{Tag: p.Tag, Ename: fmt.Sprintf("fid %v already in use", p.Fid)}, nil
+	}
+	aname, err := f.resolve(aname)
+	if err != nil {
 		return &proto9p.RError{
 			Tag:   p.Tag,
 			Ename: fmt.Sprintf("requested path `%s` not found", p.Aname),
 		}, nil
 	}
-	if _, exists := f.clients[p.Uname]; exists {
-		return &proto9p.RError{Tag: p.Tag, Ename: "server does not support multiple users of same uname right now"}, nil
-	}
-	f.clients[p.Uname] = ClientEntry{
-		attachPoint: ServedPath(p.Aname),
-	}
-	conn.SetUsername(p.Uname)
+	c.SetUsername(p.Uname)
 
-	q, err := f.QidFor(ServedPath(p.Aname))
+	q, err := f.QidFor(aname)
 	if err != nil {
 		return nil, err
 	}
+	err = c.(*conn).claim(p.Fid, &FidEntry{path: aname, qid: q})
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
 	return &proto9p.RAttach{
 		Tag: p.Tag,
 		Qid: q,
 	}, nil
 }
 
-// Auth implements server.Server.
-func (f *FS) Auth(server.Conn, *proto9p.TAuth) (proto9p.FCall, error) {
-	panic("unimplemented")
+// http://9p.io/magic/man2html/5/attach
+func (f *FS) Auth(ctx context.Context, c server.Conn, p *proto9p.TAuth) (proto9p.FCall, error) {
+	return rerror(p.Tag, ErrNoAuth), nil
 }
 
-// Clunk implements server.Server.
-func (f *FS) Clunk(server.Conn, *proto9p.TClunk) (proto9p.FCall, error) {
-	panic("unimplemented")
+// http://9p.io/magic/man2html/5/clunk
+func (f *FS) Clunk(ctx context.Context, c server.Conn, p *proto9p.TClunk) (proto9p.FCall, error) {
+	cn := c.(*conn)
+	fe, err := cn.fid(p.Fid)
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	defer fe.Unlock()
+	cn.clunk(p.Fid, fe)
+	return &proto9p.RClunk{Tag: p.Tag}, nil
 }
 
-// Create implements server.Server.
-func (f *FS) Create(server.Conn, *proto9p.TCreate) (proto9p.FCall, error) {
-	panic("unimplemented")
+func (f *FS) NewConnection() server.Conn {
+	return &conn{
+		fs:    f,
+		uname: "",
+		msize: f.maxSize,
+		fids:  map[proto9p.Fid]*FidEntry{},
+	}
 }
 
-// Flush implements server.Server.
-func (f *FS) Flush(server.Conn, *proto9p.TFlush) (proto9p.FCall, error) {
-	panic("unimplemented")
+// http://9p.io/magic/man2html/5/open
+func (f *FS) Open(ctx context.Context, c server.Conn, p *proto9p.TOpen) (proto9p.FCall, error) {
+	fe, err := c.(*conn).fid(p.Fid)
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	defer fe.Unlock()
+	if fe.opened() {
+		return rerror(p.Tag, ErrAlreadyOpen), nil
+	}
+	info, err := os.Lstat(f.osPath(fe.path))
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	flag, err := f.openFlag(p.Mode, info.IsDir())
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	file, err := os.OpenFile(f.osPath(fe.path), flag, 0)
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	q, err := f.QidFor(fe.path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	fe.qid = q
+	fe.opening(file, p.Mode, info.IsDir())
+	return &proto9p.ROpen{Tag: p.Tag, Qid: q}, nil
 }
 
-func (f *FS) NewConnection() server.Conn {
-	return &conn{
-		uname: "",
+func (fe *FidEntry) opening(file *os.File, mode proto9p.Mode, isDir bool) {
+	fe.file = file
+	fe.mode = mode
+	fe.rclose = mode&proto9p.Orclose != 0
+	if isDir {
+		fe.dir = &dirCursor{}
 	}
 }
 
-// Open implements server.Server.
-func (f *FS) Open(server.Conn, *proto9p.TOpen) (proto9p.FCall, error) {
-	panic("unimplemented")
+// openFlag checks a 9P open mode against the file and turns it into flags for os.OpenFile
+func (f *FS) openFlag(mode proto9p.Mode, isDir bool) (int, error) {
+	changes := mode&3 == proto9p.Owrite || mode&3 == proto9p.Ordwr || mode&(proto9p.Otrunc|proto9p.Orclose) != 0
+	if changes && f.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	if isDir && (mode&3 != proto9p.Oread && mode&3 != proto9p.Oexec || mode&proto9p.Otrunc != 0) {
+		return 0, ErrIsDir
+	}
+
+	var flag int
+	switch mode & 3 {
+	case proto9p.Oread, proto9p.Oexec:
+		flag = os.O_RDONLY
+	case proto9p.Owrite:
+		flag = os.O_WRONLY
+	case proto9p.Ordwr:
+		flag = os.O_RDWR
+	}
+	if mode&proto9p.Otrunc != 0 {
+		if flag == os.O_RDONLY {
+			return 0, ErrTruncRead
+		}
+		flag |= os.O_TRUNC
+	}
+	return flag, nil
+}
+
+// release closes the file of a fid that is going away, removing it if it was opened with ORCLOSE
+func (f *FS) release(fe *FidEntry) {
+	if !fe.opened() {
+		return
+	}
+	fe.file.Close()
+	if fe.rclose {
+		err := os.Remove(f.osPath(fe.path))
+		if err != nil {
+			slog.Warn("Could not remove file on clunk", "path", fe.path, "err", err)
+		}
+	}
+}
+
+// http://9p.io/magic/man2html/5/read
+func (f *FS) Read(ctx context.Context, c server.Conn, p *proto9p.TRead) (proto9p.FCall, error) {
+	fe, err := c.(*conn).fid(p.Fid)
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	defer fe.Unlock()
+	if !fe.opened() {
+		return rerror(p.Tag, ErrNotOpen), nil
+	}
+	if fe.mode&3 == proto9p.Owrite {
+		return rerror(p.Tag, ErrNotReadable), nil
+	}
+	count := min(p.Count, c.(*conn).iounit())
+	if fe.dir != nil {
+		data, err := f.readDir(ctx, fe, p.Offset, count)
+		if err != nil {
+			return rerror(p.Tag, err), nil
+		}
+		return &proto9p.RRead{Tag: p.Tag, Data: data}, nil
+	}
+	if p.Offset > math.MaxInt64 {
+		return &proto9p.RRead{Tag: p.Tag, Data: []byte{}}, nil
+	}
+	buf := make([]byte, count)
+	n, err := fe.file.ReadAt(buf, int64(p.Offset))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return rerror(p.Tag, err), nil
+	}
+	return &proto9p.RRead{Tag: p.Tag, Data: buf[:n]}, nil
+}
+
+// dirCursor is how far a fid has read through a directory.
+// A directory can only be read from the start or from where the last read ended
+type dirCursor struct {
+	offset uint64
+	// packed stats not yet returned
+	entries [][]byte
 }
 
-// Read implements server.Server.
-func (f *FS) Read(server.Conn, *proto9p.TRead) (proto9p.FCall, error) {
-	panic("unimplemented")
+// readDir returns as many whole directory entries as fit in count
+func (f *FS) readDir(ctx context.Context, fe *FidEntry, offset uint64, count uint32) ([]byte, error) {
+	if offset == 0 {
+		entries, err := f.dirEntries(ctx, fe.path)
+		if err != nil {
+			return nil, err
+		}
+		fe.dir = &dirCursor{entries: entries}
+	} else if offset != fe.dir.offset {
+		return nil, ErrBadDirOffset
+	}
+
+	data := []byte{}
+	for len(fe.dir.entries) > 0 && len(data)+len(fe.dir.entries[0]) <= int(count) {
+		data = append(data, fe.dir.entries[0]...)
+		fe.dir.entries = fe.dir.entries[1:]
+	}
+	if len(data) == 0 && len(fe.dir.entries) > 0 {
+		return nil, ErrShortDirRead
+	}
+	fe.dir.offset += uint64(len(data))
+	return data, nil
 }
 
-// Remove implements server.Server.
-func (f *FS) Remove(server.Conn, *proto9p.TRemove) (proto9p.FCall, error) {
-	panic("unimplemented")
+// dirEntries packs the stat of every file in a directory, in name order
+func (f *FS) dirEntries(ctx context.Context, dir ServedPath) ([][]byte, error) {
+	list, err := os.ReadDir(f.osPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	entries := [][]byte{}
+	for _, entry := range list {
+		// a big directory is not worth finishing for a flushed read
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !served(entry.Type()) {
+			continue
+		}
+		st, err := f.stat(servedPath(path.Join(string(dir), entry.Name())))
+		if errors.Is(err, iofs.ErrNotExist) {
+			// removed since the directory was listed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		bs, err := proto9p.WriteStat(st)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, bs)
+	}
+	return entries, nil
 }
 
-// Stat implements server.Server.
-func (f *FS) Stat(server.Conn, *proto9p.TStat) (proto9p.FCall, error) {
-	panic("unimplemented")
+// http://9p.io/magic/man2html/5/stat
+func (f *FS) Stat(ctx context.Context, c server.Conn, p *proto9p.TStat) (proto9p.FCall, error) {
+	fe, err := c.(*conn).fid(p.Fid)
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	defer fe.Unlock()
+	st, err := f.stat(fe.path)
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	return &proto9p.RStat{Tag: p.Tag, Stat: st}, nil
+}
+
+func (f *FS) stat(rpath ServedPath) (proto9p.Stat, error) {
+	info, err := os.Lstat(f.osPath(rpath))
+	if err != nil {
+		return proto9p.Stat{}, err
+	}
+	q := qidOf(rpath, info)
+	name := info.Name()
+	if rpath == "" {
+		name = "/"
+	}
+	var length uint64
+	if !info.IsDir() {
+		length = uint64(info.Size())
+	}
+	uid, gid := fileOwner(info)
+	return proto9p.NewStat(q, permOf(info), info.ModTime(), info.ModTime(), length, name, uid, gid, uid), nil
+}
+
+// permOf is the 9P mode of a file
+func permOf(info iofs.FileInfo) proto9p.Perm {
+	mode := info.Mode()
+	perm := proto9p.Perm(mode.Perm())
+	if mode.IsDir() {
+		perm |= proto9p.DMDIR
+	}
+	if mode&iofs.ModeAppend != 0 {
+		perm |= proto9p.DMAPPEND
+	}
+	if mode&iofs.ModeExclusive != 0 {
+		perm |= proto9p.DMEXCL
+	}
+	if mode&iofs.ModeTemporary != 0 {
+		perm |= proto9p.DMTMP
+	}
+	return perm
+}
+
+// served tells if a file of this type is shown to clients. Symlinks could lead out of the served directory
+func served(mode iofs.FileMode) bool {
+	return mode.IsDir() || mode.IsRegular()
 }
 
 // http://9p.io/magic/man2html/5/version
-func (f *FS) Version(c server.Conn, vers *proto9p.TVersion) (proto9p.FCall, error) {
+func (f *FS) Version(ctx context.Context, c server.Conn, vers *proto9p.TVersion) (proto9p.FCall, error) {
 	if vers.Tag != proto9p.NOTAG {
 		slog.Warn("version tag should be ~0 (65535)", "tag", vers.Tag)
 	}
-	f.maxSize = vers.MSize
+	// a new session starts, whatever the version
+	cn := c.(*conn)
+	cn.reset()
+	if vers.MSize < minMSize {
+		return rerror(vers.Tag, ErrMSizeTooSmall), nil
+	}
+	msize := min(vers.MSize, f.maxSize)
+	cn.setMSize(msize)
 	if vers.Version != Version {
 		return &proto9p.RVersion{
 			Tag:     vers.Tag,
-			Msize:   f.maxSize,
+			Msize:   msize,
 			Version: "unknown",
 		}, nil
 	}
-	// TODO version resets the connection. if we have received a connection already, we should reset
 	return &proto9p.RVersion{
 		Tag:     vers.Tag,
-		Msize:   f.maxSize,
+		Msize:   msize,
 		Version: Version,
 	}, nil
 }
 
 // https://9p.io/magic/man2html/5/walk
-func (f *FS) Walk(c server.Conn, p *proto9p.TWalk) (proto9p.FCall, error) {
-	if p.Fid != p.NewFid && c.FidInUse(p.Fid) {
+func (f *FS) Walk(ctx context.Context, c server.Conn, p *proto9p.TWalk) (proto9p.FCall, error) {
+	cn := c.(*conn)
+	fe, err := cn.fid(p.Fid)
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	defer fe.Unlock()
+	if fe.opened() {
+		return rerror(p.Tag, ErrWalkOpen), nil
+	}
+	if p.Fid != p.NewFid && cn.FidInUse(p.NewFid) {
 		return &proto9p.RError{Tag: p.Tag, Ename: fmt.Sprintf("fid %v already in use", p.NewFid)}, nil
 	}
-	log.Println("wnames: ", p.WNames)
-	panic("unimplemented")
+	if len(p.WNames) > proto9p.MaxWElem {
+		return rerror(p.Tag, ErrTooManyWNames), nil
+	}
+
+	at, atQid := fe.path, fe.qid
+	qids := []proto9p.Qid{}
+	for i, name := range p.WNames {
+		next, err := f.walkOne(at, name)
+		if err != nil && i == 0 {
+			return rerror(p.Tag, err), nil
+		} else if err != nil {
+			// newfid is left alone when only part of the walk succeeds
+			return &proto9p.RWalk{Tag: p.Tag, Wqids: qids}, nil
+		}
+		q, err := f.QidFor(next)
+		if err != nil {
+			return nil, err
+		}
+		qids = append(qids, q)
+		at, atQid = next, q
+	}
+	if p.Fid == p.NewFid {
+		fe.path, fe.qid = at, atQid
+	} else if err := cn.claim(p.NewFid, &FidEntry{path: at, qid: atQid}); err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	return &proto9p.RWalk{Tag: p.Tag, Wqids: qids}, nil
 }
 
-// Write implements server.Server.
-func (f *FS) Write(server.Conn, *proto9p.TWrite) (proto9p.FCall, error) {
-	panic("unimplemented")
+// resolve walks from the root to rpath a name at a time, so it only reaches what walkOne serves
+func (f *FS) resolve(rpath ServedPath) (ServedPath, error) {
+	at := servedPath("")
+	if rpath == at {
+		return at, nil
+	}
+	for _, name := range strings.Split(string(rpath), "/") {
+		next, err := f.walkOne(at, name)
+		if err != nil {
+			return "", err
+		}
+		at = next
+	}
+	return at, nil
 }
 
-// Wstat implements server.Server.
-func (f *FS) Wstat(server.Conn, *proto9p.TWStat) (proto9p.FCall, error) {
-	panic("unimplemented")
+// walkOne steps from the directory at to the file name in it. .. at the root stays at the root
+func (f *FS) walkOne(at ServedPath, name string) (ServedPath, error) {
+	info, err := os.Lstat(f.osPath(at))
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", ErrNotDir
+	}
+	if name == ".." {
+		return servedPath(path.Dir(string(at))), nil
+	}
+	if name == "" || name == "." || strings.Contains(name, "/") {
+		return "", ErrBadName
+	}
+	next := servedPath(path.Join(string(at), name))
+	info, err = os.Lstat(f.osPath(next))
+	if err != nil {
+		return "", err
+	}
+	if !served(info.Mode()) {
+		return "", iofs.ErrNotExist
+	}
+	return next, nil
 }
 
 var _ server.Server = &FS{}
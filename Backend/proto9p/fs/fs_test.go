@@ -0,0 +1,491 @@
+package fs
+
+import (
+	"PumiceBackend/proto9p"
+	"PumiceBackend/server"
+	"bytes"
+	"errors"
+	iofs "io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	// gets what Serve returned
+	served chan error
+}
+
+// serveDir serves a directory holding files and attaches to it as fid 0
+func serveDir(t *testing.T, files map[string]string) *testClient {
+	t.Helper()
+	return serveFS(t, t.TempDir(), files, false)
+}
+
+func serveFS(t *testing.T, dir string, files map[string]string, readOnly bool) *testClient {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv, err := NewServer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.ReadOnly = readOnly
+	return connect(t, &srv)
+}
+
+// connect starts a session with srv and attaches to its root as fid 0
+func connect(t *testing.T, srv *FS) *testClient {
+	t.Helper()
+	client, conn := net.Pipe()
+	tc := &testClient{t: t, conn: client, served: make(chan error, 1)}
+	go func() {
+		tc.served <- server.Serve(conn, conn, srv)
+	}()
+	t.Cleanup(func() { client.Close() })
+
+	tc.rpc(&proto9p.TVersion{Tag: proto9p.NOTAG, MSize: 8192, Version: Version})
+	tc.ok(&proto9p.TAttach{Tag: 1, Fid: 0, Afid: proto9p.NOFID, Uname: "glenda"})
+	return tc
+}
+
+func (tc *testClient) rpc(fc proto9p.FCall) proto9p.FCall {
+	tc.t.Helper()
+	bs, err := proto9p.WriteFCall(fc)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	if _, err := tc.conn.Write(bs); err != nil {
+		tc.t.Fatal(err)
+	}
+	reply, err := proto9p.ParseFCall(tc.conn)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	return reply
+}
+
+// ok sends fc and fails the test if the server answers with an error
+func (tc *testClient) ok(fc proto9p.FCall) proto9p.FCall {
+	tc.t.Helper()
+	reply := tc.rpc(fc)
+	if rerr, isErr := reply.(*proto9p.RError); isErr {
+		tc.t.Fatalf("%v failed: %s", fc, rerr.Ename)
+	}
+	return reply
+}
+
+// fails sends fc and fails the test unless the server answers with an error
+func (tc *testClient) fails(fc proto9p.FCall) string {
+	tc.t.Helper()
+	reply := tc.rpc(fc)
+	rerr, isErr := reply.(*proto9p.RError)
+	if !isErr {
+		tc.t.Fatalf("%v should have failed, got %v", fc, reply)
+	}
+	return rerr.Ename
+}
+
+func TestWalkOpenRead(t *testing.T) {
+	tc := serveDir(t, map[string]string{"notes/todo.md": "buy milk\n"})
+
+	walk := tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"notes", "todo.md"}}).(*proto9p.RWalk)
+	if len(walk.Wqids) != 2 {
+		t.Fatalf("walked %d names, want 2", len(walk.Wqids))
+	}
+	tc.ok(&proto9p.TOpen{Tag: 1, Fid: 1, Mode: proto9p.Oread})
+	read := tc.ok(&proto9p.TRead{Tag: 1, Fid: 1, Offset: 4, Count: 100}).(*proto9p.RRead)
+	if string(read.Data) != "milk\n" {
+		t.Fatalf("read %q, want %q", read.Data, "milk\n")
+	}
+	read = tc.ok(&proto9p.TRead{Tag: 1, Fid: 1, Offset: 100, Count: 100}).(*proto9p.RRead)
+	if len(read.Data) != 0 {
+		t.Fatalf("read %q past the end", read.Data)
+	}
+
+	st := tc.ok(&proto9p.TStat{Tag: 1, Fid: 1}).(*proto9p.RStat)
+	if st.Name() != "todo.md" || st.Length() != 9 || st.IsDir() {
+		t.Fatalf("bad stat %v", st.Stat)
+	}
+
+	tc.ok(&proto9p.TClunk{Tag: 1, Fid: 1})
+	tc.fails(&proto9p.TRead{Tag: 1, Fid: 1, Count: 100})
+
+	// .. never leads out of the served directory
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 2, WNames: []string{"..", "notes", "..", ".."}})
+	st = tc.ok(&proto9p.TStat{Tag: 1, Fid: 2}).(*proto9p.RStat)
+	if st.Name() != "/" || !st.IsDir() {
+		t.Fatalf("bad stat of the root %v", st.Stat)
+	}
+}
+
+func TestAttachName(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "passwd"), []byte("root"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(outside, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skip("cannot make symlinks here:", err)
+	}
+	tc := serveFS(t, dir, map[string]string{"notes/todo.md": ""}, false)
+
+	for _, aname := range []string{"link", "link/passwd", "link/sub", "missing"} {
+		tc.fails(&proto9p.TAttach{Tag: 1, Fid: 1, Afid: proto9p.NOFID, Uname: "glenda", Aname: aname})
+	}
+	tc.ok(&proto9p.TAttach{Tag: 1, Fid: 1, Afid: proto9p.NOFID, Uname: "glenda", Aname: "notes"})
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 1, NewFid: 2, WNames: []string{"todo.md"}})
+}
+
+func TestPartialWalk(t *testing.T) {
+	tc := serveDir(t, map[string]string{"notes/todo.md": ""})
+
+	walk := tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"notes", "missing", "more"}}).(*proto9p.RWalk)
+	if len(walk.Wqids) != 1 {
+		t.Fatalf("walked %d names, want 1", len(walk.Wqids))
+	}
+	tc.fails(&proto9p.TStat{Tag: 1, Fid: 1})
+
+	tc.fails(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"missing"}})
+	walk = tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"notes", "todo.md", "deeper"}}).(*proto9p.RWalk)
+	if len(walk.Wqids) != 2 {
+		t.Fatalf("walked %d names through a file, want 2", len(walk.Wqids))
+	}
+	tc.fails(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 0, WNames: make([]string, proto9p.MaxWElem+1)})
+
+	// walking no names clones the fid
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1})
+	tc.fails(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1})
+}
+
+func TestOpenModes(t *testing.T) {
+	tc := serveFS(t, t.TempDir(), map[string]string{"todo.md": ""}, true)
+
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"todo.md"}})
+	tc.fails(&proto9p.TOpen{Tag: 1, Fid: 1, Mode: proto9p.Owrite})
+	tc.fails(&proto9p.TOpen{Tag: 1, Fid: 1, Mode: proto9p.Oread | proto9p.Otrunc})
+	tc.ok(&proto9p.TOpen{Tag: 1, Fid: 1, Mode: proto9p.Oread})
+	tc.fails(&proto9p.TOpen{Tag: 1, Fid: 1, Mode: proto9p.Oread})
+	tc.fails(&proto9p.TWalk{Tag: 1, Fid: 1, NewFid: 2})
+
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 2})
+	tc.fails(&proto9p.TOpen{Tag: 1, Fid: 2, Mode: proto9p.Ordwr})
+}
+
+func TestReadDir(t *testing.T) {
+	tc := serveDir(t, map[string]string{"a.md": "a", "b.md": "bb", "sub/c.md": ""})
+
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1})
+	tc.ok(&proto9p.TOpen{Tag: 1, Fid: 1, Mode: proto9p.Oread})
+
+	// small reads still return whole entries, one at a time
+	names := []string{}
+	offset := uint64(0)
+	for {
+		read := tc.ok(&proto9p.TRead{Tag: 1, Fid: 1, Offset: offset, Count: 80}).(*proto9p.RRead)
+		if len(read.Data) == 0 {
+			break
+		}
+		r := proto9p.TypedReader{Reader: bytes.NewReader(read.Data)}
+		st, err := r.ReadStat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, st.Name())
+		offset += uint64(len(read.Data))
+	}
+	if want := []string{"a.md", "b.md", "sub"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("listed %v, want %v", names, want)
+	}
+
+	tc.fails(&proto9p.TRead{Tag: 1, Fid: 1, Offset: 3, Count: 8192})
+	tc.fails(&proto9p.TRead{Tag: 1, Fid: 1, Offset: 0, Count: 10})
+
+	// reading from 0 starts over
+	read := tc.ok(&proto9p.TRead{Tag: 1, Fid: 1, Offset: 0, Count: 8192}).(*proto9p.RRead)
+	r := proto9p.TypedReader{Reader: bytes.NewReader(read.Data)}
+	for _, want := range names {
+		st, err := r.ReadStat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Name() != want {
+			t.Fatalf("listed %s, want %s", st.Name(), want)
+		}
+	}
+}
+
+func TestCreateWrite(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	tc := serveFS(t, dir, nil, false)
+
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1})
+	tc.ok(&proto9p.TCreate{Tag: 1, Fid: 1, Name: "todo.md", Perm: 0666, Mode: proto9p.Ordwr})
+	tc.ok(&proto9p.TWrite{Tag: 1, Fid: 1, Offset: 0, Data: []byte("buy milk\n")})
+	tc.ok(&proto9p.TWrite{Tag: 1, Fid: 1, Offset: 4, Data: []byte("eggs")})
+	read := tc.ok(&proto9p.TRead{Tag: 1, Fid: 1, Offset: 0, Count: 100}).(*proto9p.RRead)
+	if string(read.Data) != "buy eggs\n" {
+		t.Fatalf("read %q, want %q", read.Data, "buy eggs\n")
+	}
+	tc.ok(&proto9p.TClunk{Tag: 1, Fid: 1})
+
+	info, err := os.Stat(filepath.Join(dir, "todo.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("created with %v, want the directory's -rw-r-----", info.Mode().Perm())
+	}
+
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1})
+	tc.fails(&proto9p.TCreate{Tag: 1, Fid: 1, Name: "todo.md", Perm: 0666, Mode: proto9p.Owrite})
+	tc.fails(&proto9p.TCreate{Tag: 1, Fid: 1, Name: "../escape", Perm: 0666, Mode: proto9p.Owrite})
+	tc.ok(&proto9p.TCreate{Tag: 1, Fid: 1, Name: "sub", Perm: proto9p.DMDIR | 0777, Mode: proto9p.Oread})
+	st := tc.ok(&proto9p.TStat{Tag: 1, Fid: 1}).(*proto9p.RStat)
+	if !st.IsDir() || st.Mode() != proto9p.DMDIR|0750 {
+		t.Fatalf("bad stat of the new directory %v", st.Stat)
+	}
+
+	// truncating on open
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 2, WNames: []string{"todo.md"}})
+	tc.fails(&proto9p.TWrite{Tag: 1, Fid: 2, Data: []byte("x")})
+	tc.ok(&proto9p.TOpen{Tag: 1, Fid: 2, Mode: proto9p.Owrite | proto9p.Otrunc})
+	st = tc.ok(&proto9p.TStat{Tag: 1, Fid: 2}).(*proto9p.RStat)
+	if st.Length() != 0 {
+		t.Fatalf("truncated file is %d bytes long", st.Length())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	dir := t.TempDir()
+	tc := serveFS(t, dir, map[string]string{"a.md": "", "b.md": ""}, false)
+
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"a.md"}})
+	tc.ok(&proto9p.TRemove{Tag: 1, Fid: 1})
+	tc.fails(&proto9p.TStat{Tag: 1, Fid: 1})
+	if _, err := os.Stat(filepath.Join(dir, "a.md")); !os.IsNotExist(err) {
+		t.Fatalf("a.md is still there: %v", err)
+	}
+
+	// removing on clunk
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"b.md"}})
+	tc.ok(&proto9p.TOpen{Tag: 1, Fid: 1, Mode: proto9p.Oread | proto9p.Orclose})
+	tc.ok(&proto9p.TClunk{Tag: 1, Fid: 1})
+	if _, err := os.Stat(filepath.Join(dir, "b.md")); !os.IsNotExist(err) {
+		t.Fatalf("b.md is still there: %v", err)
+	}
+
+	// the root stays, but the fid is gone all the same
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1})
+	tc.fails(&proto9p.TRemove{Tag: 1, Fid: 1})
+	tc.fails(&proto9p.TStat{Tag: 1, Fid: 1})
+}
+
+func TestWstat(t *testing.T) {
+	dir := t.TempDir()
+	tc := serveFS(t, dir, map[string]string{"a.md": "hello", "taken.md": ""}, false)
+
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"a.md"}})
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tc.ok(&proto9p.TWStat{Tag: 1, Fid: 1, Stat: proto9p.DontTouch.WithName("b.md").WithLength(2).WithMode(0600).WithMtime(mtime)})
+
+	st := tc.ok(&proto9p.TStat{Tag: 1, Fid: 1}).(*proto9p.RStat)
+	if st.Name() != "b.md" || st.Length() != 2 || st.Mode() != 0600 || !st.Mtime().Equal(mtime) {
+		t.Fatalf("bad stat after wstat %v", st.Stat)
+	}
+	if bs, err := os.ReadFile(filepath.Join(dir, "b.md")); err != nil || string(bs) != "he" {
+		t.Fatalf("read %q, %v", bs, err)
+	}
+
+	// nothing changes when part of the wstat is refused
+	tc.fails(&proto9p.TWStat{Tag: 1, Fid: 1, Stat: proto9p.DontTouch.WithName("taken.md").WithLength(0)})
+	tc.fails(&proto9p.TWStat{Tag: 1, Fid: 1, Stat: proto9p.DontTouch.WithMode(proto9p.DMDIR | 0700).WithLength(0)})
+	st = tc.ok(&proto9p.TStat{Tag: 1, Fid: 1}).(*proto9p.RStat)
+	if st.Name() != "b.md" || st.Length() != 2 {
+		t.Fatalf("refused wstat changed %v", st.Stat)
+	}
+}
+
+func TestRenameNoReplace(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	mkdir := func(name string) string {
+		p := filepath.Join(dir, name)
+		if err := os.Mkdir(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	a, taken := file("a", "a"), file("taken", "taken")
+	if err := renameNoReplace(a, taken, false); !errors.Is(err, iofs.ErrExist) {
+		t.Fatalf("renamed a file onto another: %v", err)
+	}
+	if bs, _ := os.ReadFile(taken); string(bs) != "taken" {
+		t.Fatalf("replaced file reads %q", bs)
+	}
+	if err := renameNoReplace(a, filepath.Join(dir, "b"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(a); !errors.Is(err, iofs.ErrNotExist) {
+		t.Fatalf("old name still there: %v", err)
+	}
+
+	// rename on its own would replace an empty directory
+	d, empty := mkdir("d"), mkdir("empty")
+	if err := renameNoReplace(d, empty, true); !errors.Is(err, iofs.ErrExist) {
+		t.Fatalf("renamed a directory onto another: %v", err)
+	}
+	if err := renameNoReplace(d, filepath.Join(dir, "e"), true); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{empty, filepath.Join(dir, "e")} {
+		if info, err := os.Stat(p); err != nil || !info.IsDir() {
+			t.Fatalf("%s is not a directory: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(d); !errors.Is(err, iofs.ErrNotExist) {
+		t.Fatalf("old directory still there: %v", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	tc := serveFS(t, dir, map[string]string{"a.md": "hello"}, true)
+
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1})
+	tc.fails(&proto9p.TCreate{Tag: 1, Fid: 1, Name: "new.md", Perm: 0666, Mode: proto9p.Owrite})
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 2, WNames: []string{"a.md"}})
+	tc.fails(&proto9p.TWStat{Tag: 1, Fid: 2, Stat: proto9p.DontTouch.WithLength(0)})
+	tc.fails(&proto9p.TOpen{Tag: 1, Fid: 2, Mode: proto9p.Oread | proto9p.Orclose})
+	tc.fails(&proto9p.TRemove{Tag: 1, Fid: 2})
+
+	if bs, err := os.ReadFile(filepath.Join(dir, "a.md")); err != nil || string(bs) != "hello" {
+		t.Fatalf("read %q, %v", bs, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.md")); !os.IsNotExist(err) {
+		t.Fatalf("new.md was created: %v", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.md"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same user twice, with the same fids
+	first, second := connect(t, &srv), connect(t, &srv)
+	first.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"a.md"}})
+	second.fails(&proto9p.TStat{Tag: 1, Fid: 1})
+	second.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1})
+	st := first.ok(&proto9p.TStat{Tag: 1, Fid: 1}).(*proto9p.RStat)
+	if st.Name() != "a.md" {
+		t.Fatalf("fid 1 of the first connection is %s, want a.md", st.Name())
+	}
+
+	// version starts over
+	first.ok(&proto9p.TOpen{Tag: 1, Fid: 1, Mode: proto9p.Oread | proto9p.Orclose})
+	first.rpc(&proto9p.TVersion{Tag: proto9p.NOTAG, MSize: 8192, Version: Version})
+	first.fails(&proto9p.TStat{Tag: 1, Fid: 0})
+	if _, err := os.Stat(filepath.Join(dir, "a.md")); !os.IsNotExist(err) {
+		t.Fatalf("a.md was not removed by the version: %v", err)
+	}
+	second.ok(&proto9p.TStat{Tag: 1, Fid: 1})
+
+	// hanging up clunks everything
+	second.ok(&proto9p.TCreate{Tag: 1, Fid: 1, Name: "b.md", Perm: 0644, Mode: proto9p.Owrite | proto9p.Orclose})
+	second.conn.Close()
+	if err := <-second.served; err != nil {
+		t.Fatalf("serving ended with %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.md")); !os.IsNotExist(err) {
+		t.Fatalf("b.md was not removed when the connection closed: %v", err)
+	}
+}
+
+func TestMSize(t *testing.T) {
+	tc := serveDir(t, map[string]string{"big.md": string(make([]byte, 10000))})
+
+	version := tc.rpc(&proto9p.TVersion{Tag: proto9p.NOTAG, MSize: 1 << 30, Version: Version}).(*proto9p.RVersion)
+	if version.Msize != 65535 {
+		t.Fatalf("agreed to msize %d, want the server maximum", version.Msize)
+	}
+	tc.fails(&proto9p.TVersion{Tag: proto9p.NOTAG, MSize: 16, Version: Version})
+	version = tc.rpc(&proto9p.TVersion{Tag: proto9p.NOTAG, MSize: 4096, Version: Version}).(*proto9p.RVersion)
+	if version.Msize != 4096 {
+		t.Fatalf("agreed to msize %d, want 4096", version.Msize)
+	}
+	tc.ok(&proto9p.TAttach{Tag: 1, Fid: 0, Afid: proto9p.NOFID, Uname: "glenda"})
+
+	tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"big.md"}})
+	tc.ok(&proto9p.TOpen{Tag: 1, Fid: 1, Mode: proto9p.Oread})
+	read := tc.ok(&proto9p.TRead{Tag: 1, Fid: 1, Count: 8192}).(*proto9p.RRead)
+	if len(read.Data) != 4096-proto9p.IOHDRSZ {
+		t.Fatalf("read %d bytes, want what fits in msize", len(read.Data))
+	}
+
+	// a message over msize ends the connection
+	bs, err := proto9p.WriteFCall(&proto9p.TWrite{Tag: 1, Fid: 1, Data: make([]byte, 5000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server stops reading after the size, leaving this write hanging until the pipe closes
+	go tc.conn.Write(bs)
+	if err := <-tc.served; !errors.Is(err, proto9p.ErrMessageSize) {
+		t.Fatalf("serving ended with %v, want %v", err, proto9p.ErrMessageSize)
+	}
+}
+
+func TestQids(t *testing.T) {
+	dir := t.TempDir()
+	tc := serveFS(t, dir, map[string]string{"notes/a.md": "a"}, false)
+	walk := tc.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"notes", "a.md"}}).(*proto9p.RWalk)
+	notes, a := walk.Wqids[0], walk.Wqids[1]
+	if notes.Qtype != proto9p.QTDIR || a.Qtype != proto9p.QTFILE {
+		t.Fatalf("qid types %v and %v, want a directory and a file", notes, a)
+	}
+
+	// the same after a restart
+	again := serveFS(t, dir, nil, false)
+	walk = again.ok(&proto9p.TWalk{Tag: 1, Fid: 0, NewFid: 1, WNames: []string{"notes", "a.md"}}).(*proto9p.RWalk)
+	if walk.Wqids[0] != notes || walk.Wqids[1] != a {
+		t.Fatalf("qids %v after a restart, want %v", walk.Wqids, []proto9p.Qid{notes, a})
+	}
+
+	// changes bump the version, renames keep the path
+	tc.ok(&proto9p.TOpen{Tag: 1, Fid: 1, Mode: proto9p.Owrite})
+	tc.ok(&proto9p.TWrite{Tag: 1, Fid: 1, Offset: 1, Data: []byte("bc")})
+	tc.ok(&proto9p.TWStat{Tag: 1, Fid: 1, Stat: proto9p.DontTouch.WithName("b.md")})
+	st := tc.ok(&proto9p.TStat{Tag: 1, Fid: 1}).(*proto9p.RStat)
+	if st.Qid().Uid != a.Uid || st.Qid().Vers == a.Vers {
+		t.Fatalf("qid %v after writing and renaming, was %v", st.Qid(), a)
+	}
+}
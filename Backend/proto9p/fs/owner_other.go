@@ -0,0 +1,9 @@
+//go:build !unix
+
+package fs
+
+import iofs "io/fs"
+
+func fileOwner(info iofs.FileInfo) (uid, gid string) {
+	return defaultOwner, defaultOwner
+}
@@ -0,0 +1,48 @@
+//go:build unix
+
+package fs
+
+import (
+	iofs "io/fs"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+var ownerNames sync.Map
+
+// fileOwner names the user and group owning a file, falling back to their ids
+func fileOwner(info iofs.FileInfo) (uid, gid string) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return defaultOwner, defaultOwner
+	}
+	return lookupName("u"+strconv.Itoa(int(st.Uid)), func(id string) (string, error) {
+			u, err := user.LookupId(id)
+			if err != nil {
+				return "", err
+			}
+			return u.Username, nil
+		}),
+		lookupName("g"+strconv.Itoa(int(st.Gid)), func(id string) (string, error) {
+			g, err := user.LookupGroupId(id)
+			if err != nil {
+				return "", err
+			}
+			return g.Name, nil
+		})
+}
+
+// lookupName caches lookups of user and group ids, the key is the id prefixed by its kind
+func lookupName(key string, lookup func(id string) (string, error)) string {
+	if name, ok := ownerNames.Load(key); ok {
+		return name.(string)
+	}
+	name, err := lookup(key[1:])
+	if err != nil {
+		name = key[1:]
+	}
+	ownerNames.Store(key, name)
+	return name
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package fs
+
+import iofs "io/fs"
+
+func fileID(info iofs.FileInfo) (uint64, bool) {
+	return 0, false
+}
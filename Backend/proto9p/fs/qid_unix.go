@@ -0,0 +1,17 @@
+//go:build unix
+
+package fs
+
+import (
+	iofs "io/fs"
+	"syscall"
+)
+
+// fileID tells files apart by device and inode
+func fileID(info iofs.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Ino) ^ uint64(st.Dev)<<48, true
+}
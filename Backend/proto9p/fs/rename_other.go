@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fs
+
+import "os"
+
+// renameDir moves a directory, which never replaces an existing one here
+func renameDir(from, to string) error {
+	return os.Rename(from, to)
+}
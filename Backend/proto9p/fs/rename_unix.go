@@ -0,0 +1,24 @@
+//go:build unix
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// renameDir moves a directory without replacing anything. rename(2) replaces an empty
+// directory, so the new name is claimed with one first. os.Rename refuses any directory
+// it finds there, which is why the system call is made directly
+func renameDir(from, to string) error {
+	err := os.Mkdir(to, 0700)
+	if err != nil {
+		return err
+	}
+	err = syscall.Rename(from, to)
+	if err != nil {
+		os.Remove(to)
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package fs
+
+import (
+	"PumiceBackend/proto9p"
+	"PumiceBackend/server"
+	"context"
+	"errors"
+	iofs "io/fs"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// http://9p.io/magic/man2html/5/open
+func (f *FS) Create(ctx context.Context, c server.Conn, p *proto9p.TCreate) (proto9p.FCall, error) {
+	fe, err := c.(*conn).fid(p.Fid)
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	defer fe.Unlock()
+	if f.ReadOnly {
+		return rerror(p.Tag, ErrReadOnly), nil
+	}
+	if fe.opened() {
+		return rerror(p.Tag, ErrAlreadyOpen), nil
+	}
+	if p.Name == "" || p.Name == "." || p.Name == ".." || strings.Contains(p.Name, "/") {
+		return rerror(p.Tag, ErrBadName), nil
+	}
+	dir, err := os.Lstat(f.osPath(fe.path))
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	if !dir.IsDir() {
+		return rerror(p.Tag, ErrNotDir), nil
+	}
+
+	created := servedPath(path.Join(string(fe.path), p.Name))
+	isDir := p.Perm&proto9p.DMDIR != 0
+	perm := inheritPerm(p.Perm, dir.Mode().Perm(), isDir)
+	var file *os.File
+	if isDir {
+		if p.Mode&3 != proto9p.Oread || p.Mode&proto9p.Otrunc != 0 {
+			return rerror(p.Tag, ErrIsDir), nil
+		}
+		err = os.Mkdir(f.osPath(created), perm)
+		if err == nil {
+			file, err = os.Open(f.osPath(created))
+		}
+	} else {
+		var flag int
+		flag, err = f.openFlag(p.Mode&^proto9p.Otrunc, false)
+		if err != nil {
+			return rerror(p.Tag, err), nil
+		}
+		file, err = os.OpenFile(f.osPath(created), flag|os.O_CREATE|os.O_EXCL, perm)
+	}
+	if errors.Is(err, iofs.ErrExist) {
+		return rerror(p.Tag, ErrExists), nil
+	} else if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	// the umask may have taken bits away
+	err = file.Chmod(perm)
+	if err != nil {
+		file.Close()
+		return rerror(p.Tag, err), nil
+	}
+
+	q, err := f.QidFor(created)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	fe.path, fe.qid = created, q
+	fe.opening(file, p.Mode, isDir)
+	return &proto9p.RCreate{Tag: p.Tag, Qid: q}, nil
+}
+
+// inheritPerm limits the permissions of a new file to those of its directory, as create(5) says:
+// files get perm & (~0666 | (dir.perm & 0666)), directories perm & (~0777 | (dir.perm & 0777))
+func inheritPerm(perm proto9p.Perm, dir iofs.FileMode, isDir bool) iofs.FileMode {
+	var mask proto9p.Perm = 0666
+	if isDir {
+		mask = 0777
+	}
+	return iofs.FileMode(perm&(^mask|proto9p.Perm(dir)&mask)) & iofs.ModePerm
+}
+
+// http://9p.io/magic/man2html/5/read
+func (f *FS) Write(ctx context.Context, c server.Conn, p *proto9p.TWrite) (proto9p.FCall, error) {
+	fe, err := c.(*conn).fid(p.Fid)
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	defer fe.Unlock()
+	if !fe.opened() {
+		return rerror(p.Tag, ErrNotOpen), nil
+	}
+	if fe.mode&3 != proto9p.Owrite && fe.mode&3 != proto9p.Ordwr {
+		return rerror(p.Tag, ErrNotWritable), nil
+	}
+	if p.Offset > math.MaxInt64 {
+		return rerror(p.Tag, iofs.ErrInvalid), nil
+	}
+	data := p.Data
+	if iounit := c.(*conn).iounit(); uint32(len(data)) > iounit {
+		data = data[:iounit]
+	}
+	n, err := fe.file.WriteAt(data, int64(p.Offset))
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	return &proto9p.RWrite{Tag: p.Tag, Count: uint32(n)}, nil
+}
+
+// http://9p.io/magic/man2html/5/remove
+func (f *FS) Remove(ctx context.Context, c server.Conn, p *proto9p.TRemove) (proto9p.FCall, error) {
+	cn := c.(*conn)
+	fe, err := cn.fid(p.Fid)
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	defer fe.Unlock()
+	// the fid is clunked even if the remove fails
+	fe.rclose = false
+	cn.clunk(p.Fid, fe)
+
+	if f.ReadOnly {
+		return rerror(p.Tag, ErrReadOnly), nil
+	}
+	if fe.path == "" {
+		return rerror(p.Tag, ErrRemoveRoot), nil
+	}
+	err = os.Remove(f.osPath(fe.path))
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	return &proto9p.RRemove{Tag: p.Tag}, nil
+}
+
+// http://9p.io/magic/man2html/5/stat
+//
+// Renames within the directory, truncates, changes permissions and sets the mtime.
+// Every change is checked before any is made, and the rename goes first so a name
+// taken in the meantime fails the wstat with nothing changed. Should one of the later
+// changes fail, the ones before it stay made.
+//
+// Only the fid given is moved by a rename. Other fids inside a renamed directory, on this
+// connection or any other, keep the old path: they fail with file not found, or reach
+// whatever is created at the old path later, until they are walked again. Open files are
+// not affected, they stay on the file they opened
+func (f *FS) Wstat(ctx context.Context, c server.Conn, p *proto9p.TWStat) (proto9p.FCall, error) {
+	fe, err := c.(*conn).fid(p.Fid)
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	defer fe.Unlock()
+	if f.ReadOnly {
+		return rerror(p.Tag, ErrReadOnly), nil
+	}
+	info, err := os.Lstat(f.osPath(fe.path))
+	if err != nil {
+		return rerror(p.Tag, err), nil
+	}
+	st := p.Stat
+	renamed := fe.path
+	if st.SetsName() && st.Name() != info.Name() {
+		if fe.path == "" || st.Name() == "." || st.Name() == ".." || strings.Contains(st.Name(), "/") {
+			return rerror(p.Tag, ErrBadName), nil
+		}
+		renamed = servedPath(path.Join(path.Dir(string(fe.path)), st.Name()))
+		if f.FileExists(renamed) {
+			return rerror(p.Tag, ErrExists), nil
+		}
+	}
+	if st.SetsLength() && info.IsDir() && st.Length() != 0 {
+		return rerror(p.Tag, ErrIsDir), nil
+	}
+	if st.SetsLength() && st.Length() > math.MaxInt64 {
+		return rerror(p.Tag, iofs.ErrInvalid), nil
+	}
+	if st.SetsMode() && (st.Mode()&proto9p.DMDIR != 0) != info.IsDir() {
+		return rerror(p.Tag, ErrWstat), nil
+	}
+	uid, gid := fileOwner(info)
+	if st.SetsUid() && st.Uid() != uid || st.SetsGid() && st.Gid() != gid {
+		return rerror(p.Tag, ErrWstat), nil
+	}
+
+	if renamed != fe.path {
+		err = renameNoReplace(f.osPath(fe.path), f.osPath(renamed), info.IsDir())
+		if errors.Is(err, iofs.ErrExist) {
+			return rerror(p.Tag, ErrExists), nil
+		} else if err != nil {
+			return rerror(p.Tag, err), nil
+		}
+		fe.path = renamed
+	}
+	osPath := f.osPath(fe.path)
+	if st.SetsLength() && !info.IsDir() {
+		err = os.Truncate(osPath, int64(st.Length()))
+		if err != nil {
+			return rerror(p.Tag, err), nil
+		}
+	}
+	if st.SetsMode() {
+		err = os.Chmod(osPath, iofs.FileMode(st.Mode())&iofs.ModePerm)
+		if err != nil {
+			return rerror(p.Tag, err), nil
+		}
+	}
+	if st.SetsMtime() {
+		// a zero atime leaves it alone
+		err = os.Chtimes(osPath, time.Time{}, st.Mtime())
+		if err != nil {
+			return rerror(p.Tag, err), nil
+		}
+	}
+	return &proto9p.RWStat{Tag: p.Tag}, nil
+}
+
+// renameNoReplace moves from to to, failing with fs.ErrExist rather than replacing
+// anything at to. A file is linked under its new name and then unlinked, a directory,
+// which cannot be linked, is left to renameDir
+func renameNoReplace(from, to string, isDir bool) error {
+	if isDir {
+		return renameDir(from, to)
+	}
+	err := os.Link(from, to)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(from)
+	if err != nil {
+		os.Remove(to)
+	}
+	return err
+}
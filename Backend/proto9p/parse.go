@@ -9,12 +9,29 @@ import (
 
 var ErrUnknownType = errors.New("9p packet type not recognized")
 
+// ParseFCall reads one message of up to MaxMessageSize bytes from r
 func ParseFCall(r io.Reader) (FCall, error) {
+	return ParseFCallMax(r, MaxMessageSize)
+}
+
+// ParseFCallMax reads one message from r, refusing it before reading any further if it is
+// larger than msize. It returns io.EOF if r ends before the message starts
+func ParseFCallMax(r io.Reader, msize uint32) (FCall, error) {
 	wire_reader := TypedReader{r}
-	size, err := wire_reader.Read32()
+	head := make([]byte, 4)
+	n, err := io.ReadFull(r, head)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, NewErrBufferTooShort(len(head), n)
+	} else if err != nil {
+		return nil, err
+	}
+	size, err := (&TypedReader{bytes.NewReader(head)}).Read32()
 	if err != nil {
 		return nil, err
 	}
+	if size < minMessageSize || size > msize {
+		return nil, fmt.Errorf("%d bytes, at most %d: %w", size, msize, ErrMessageSize)
+	}
 	// size - 4 since size takes up 4 bytes
 	wanted := size - 4
 	bs, err := wire_reader.ReadN(int(wanted))
@@ -109,7 +126,7 @@ func (t *RStat) fillFrom(r TypedReader) (FCall, error) {
 	if err != nil {
 		return nil, err
 	}
-	t.Stat, err = r.ReadStat()
+	t.Stat, err = r.ReadCountedStat()
 	if err != nil {
 		return nil, err
 	}
@@ -126,7 +143,7 @@ func (t *TWStat) fillFrom(r TypedReader) (FCall, error) {
 	if err != nil {
 		return nil, err
 	}
-	t.Stat, err = r.ReadStat()
+	t.Stat, err = r.ReadCountedStat()
 	if err != nil {
 		return nil, err
 	}
@@ -202,7 +219,7 @@ func (t *TCreate) fillFrom(r TypedReader) (FCall, error) {
 	if err != nil {
 		return nil, err
 	}
-	t.perm = Perm(perm32)
+	t.Perm = Perm(perm32)
 
 	m8, err := r.Read8()
 	if err != nil {
@@ -20,6 +20,18 @@ func WriteFCall(fc FCall) ([]byte, error) {
 	return tw.Finish(), nil
 }
 
+// writes a Stat to the wire format, as found in directory reads (including 2 bytes of size at the front)
+func WriteStat(st Stat) ([]byte, error) {
+	var tw = TypedWriter{
+		ReadWriter: &bytes.Buffer{},
+	}
+	err := tw.WriteStat(st)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(tw)
+}
+
 type TypedReader struct {
 	io.Reader
 }
@@ -67,12 +79,17 @@ func (tr *TypedReader) ReadTag() (Tag, error) {
 }
 
 func (tr *TypedReader) ReadN(n int) ([]byte, error) {
+	// a count from the wire may promise more than the message holds
+	if sized, ok := tr.Reader.(interface{ Len() int }); ok && sized.Len() < n {
+		return []byte{}, NewErrBufferTooShort(n, sized.Len())
+	}
 	bs := make([]byte, n)
-	read, err := tr.Read(bs)
-	if read < n {
+	// a stream may hand over a message in several pieces
+	read, err := io.ReadFull(tr, bs)
+	if errors.Is(err, io.ErrUnexpectedEOF) || (errors.Is(err, io.EOF) && n > 0) {
 		return []byte{}, NewErrBufferTooShort(n, read)
 	}
-	if err != nil && !errors.Is(err, io.EOF) {
+	if err != nil {
 		return []byte{}, err
 	}
 	return bs, nil
@@ -137,10 +154,10 @@ func (tr *TypedReader) ReadStat() (Stat, error) {
 		return st, err
 	}
 	m32, err := tr.Read32()
-	st.mode = Mode(m32)
 	if err != nil {
 		return st, err
 	}
+	st.mode = Perm(m32)
 	atime32, err := tr.Read32()
 	if err != nil {
 		return st, err
@@ -176,6 +193,27 @@ func (tr *TypedReader) ReadStat() (Stat, error) {
 	return st, nil
 }
 
+// stat[n] in Rstat and Twstat is a count followed by the stat, which has a size of its own
+func (tr *TypedReader) ReadCountedStat() (Stat, error) {
+	_, err := tr.Read16()
+	if err != nil {
+		return Stat{}, err
+	}
+	return tr.ReadStat()
+}
+
+func (tw *TypedWriter) WriteCountedStat(stat Stat) error {
+	bs, err := WriteStat(stat)
+	if err != nil {
+		return err
+	}
+	err = tw.Write16(uint16(len(bs)))
+	if err != nil {
+		return err
+	}
+	return tw.WriteN(bs)
+}
+
 func (tw *TypedWriter) WriteType(t Type) error {
 	return tw.Write8(uint8(t))
 }
@@ -351,7 +389,8 @@ func (outer *TypedWriter) WriteStat(stat Stat) error {
 	if err != nil {
 		return err
 	}
-	err = outer.Write16(uint16(len(bs) + 4))
+	// size does not count itself
+	err = outer.Write16(uint16(len(bs)))
 	if err != nil {
 		return err
 	}
@@ -2,8 +2,11 @@ package proto9p
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 )
 
 func RoundTrip[T comparable](t *testing.T, thing T, enc func(TypedWriter, T) error, dec func(TypedReader) (T, error)) {
@@ -60,3 +63,50 @@ func FuzzQidEncoding(f *testing.F) {
 		)
 	})
 }
+
+func TestStatSizeExcludesItself(t *testing.T) {
+	st := NewStat(Qid{Qtype: QTDIR, Uid: 3}, DMDIR|0755, time.Unix(1, 0), time.Unix(2, 0), 0, "dir", "glenda", "glenda", "")
+	bs, err := WriteStat(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int(binary.LittleEndian.Uint16(bs))
+	if size != len(bs)-2 {
+		t.Fatalf("stat size is %d, but %d bytes follow it", size, len(bs)-2)
+	}
+	got, err := (&TypedReader{bytes.NewReader(bs)}).ReadStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != st || !got.IsDir() {
+		t.Fatalf("Mismatch round tripping stat: Wanted %v, got %v", st, got)
+	}
+}
+
+func TestMessageSizeLimits(t *testing.T) {
+	bs, err := WriteFCall(&TWrite{Tag: 1, Fid: 2, Offset: 0, Data: make([]byte, 100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseFCallMax(bytes.NewReader(bs), uint32(len(bs))); err != nil {
+		t.Fatalf("message of exactly msize refused: %v", err)
+	}
+	if _, err := ParseFCallMax(bytes.NewReader(bs), uint32(len(bs)-1)); !errors.Is(err, ErrMessageSize) {
+		t.Fatalf("got %v for a message over msize, want %v", err, ErrMessageSize)
+	}
+
+	// a size prefix promising 4GB, with nothing after it
+	huge := []byte{0xff, 0xff, 0xff, 0xff}
+	if _, err := ParseFCall(bytes.NewReader(huge)); !errors.Is(err, ErrMessageSize) {
+		t.Fatalf("got %v for a 4GB message, want %v", err, ErrMessageSize)
+	}
+	if _, err := ParseFCall(bytes.NewReader([]byte{3, 0, 0, 0})); !errors.Is(err, ErrMessageSize) {
+		t.Fatalf("got %v for a message shorter than its header, want %v", err, ErrMessageSize)
+	}
+
+	// a Twrite count larger than the message that carries it
+	binary.LittleEndian.PutUint32(bs[len(bs)-104:], 1<<31)
+	if _, err := ParseFCall(bytes.NewReader(bs)); !errors.Is(err, ErrBufferTooShort) {
+		t.Fatalf("got %v for a Twrite count past the message, want %v", err, ErrBufferTooShort)
+	}
+}
@@ -55,7 +55,7 @@ func FuzzRoundTripTCreate(f *testing.F) {
 			Tag:  Tag(tag),
 			Fid:  Fid(fid),
 			Name: name,
-			perm: Perm(perm),
+			Perm: Perm(perm),
 			Mode: Mode(mode),
 		})
 
@@ -96,8 +96,8 @@ func FuzzRoundTripTStat(f *testing.F) {
 }
 
 func FuzzRoundTripRStat(f *testing.F) {
-	f.Add(uint16(1), uint16(2), uint32(3), uint8(4), uint32(5), uint64(6), uint8(7), uint32(8), uint32(9), uint64(10), "file.txt", "name", "group", "name")
-	f.Fuzz(func(t *testing.T, tag uint16, type_ uint16, dev uint32, qidT uint8, qidV uint32, qidU uint64, mode uint8, atime uint32, mtime uint32, length uint64, name string, uid string, gid string, muid string) {
+	f.Add(uint16(1), uint16(2), uint32(3), uint8(4), uint32(5), uint64(6), uint32(0x80000007), uint32(8), uint32(9), uint64(10), "file.txt", "name", "group", "name")
+	f.Fuzz(func(t *testing.T, tag uint16, type_ uint16, dev uint32, qidT uint8, qidV uint32, qidU uint64, mode uint32, atime uint32, mtime uint32, length uint64, name string, uid string, gid string, muid string) {
 		RoundTripFCall(t, &RStat{
 			Tag: Tag(tag),
 			Stat: Stat{
@@ -108,7 +108,7 @@ func FuzzRoundTripRStat(f *testing.F) {
 					Vers:  qidV,
 					Uid:   qidU,
 				},
-				mode:   Mode(mode),
+				mode:   Perm(mode),
 				atime:  time.Unix(int64(atime), 0),
 				mtime:  time.Unix(int64(mtime), 0),
 				length: length,
@@ -123,8 +123,8 @@ func FuzzRoundTripRStat(f *testing.F) {
 }
 
 func FuzzRoundTripWTStat(f *testing.F) {
-	f.Add(uint16(1), uint32(1), uint16(1), uint32(1), uint8(1), uint32(1), uint64(1), uint8(1), uint32(1), uint32(1), uint64(1), "file.txt", "name", "group", "name")
-	f.Fuzz(func(t *testing.T, tag uint16, fid uint32, type_ uint16, dev uint32, qidT uint8, qidV uint32, qidU uint64, mode uint8, atime uint32, mtime uint32, length uint64, name string, uid string, gid string, muid string) {
+	f.Add(uint16(1), uint32(1), uint16(1), uint32(1), uint8(1), uint32(1), uint64(1), uint32(0x80000001), uint32(1), uint32(1), uint64(1), "file.txt", "name", "group", "name")
+	f.Fuzz(func(t *testing.T, tag uint16, fid uint32, type_ uint16, dev uint32, qidT uint8, qidV uint32, qidU uint64, mode uint32, atime uint32, mtime uint32, length uint64, name string, uid string, gid string, muid string) {
 		RoundTripFCall(t, &TWStat{
 			Tag: Tag(tag),
 			Fid: Fid(fid),
@@ -136,7 +136,7 @@ func FuzzRoundTripWTStat(f *testing.F) {
 					Vers:  qidV,
 					Uid:   qidU,
 				},
-				mode:   Mode(mode),
+				mode:   Perm(mode),
 				atime:  time.Unix(int64(atime), 0),
 				mtime:  time.Unix(int64(mtime), 0),
 				length: length,
@@ -10,7 +10,21 @@ import (
 
 const MaxStrSize = 65535
 
+// most names a single Twalk may hold
+const MaxWElem = 16
+
+// IOHDRSZ is room for the header of a Twrite or Rread.
+// msize minus IOHDRSZ is the most data one message can carry
+const IOHDRSZ = 24
+
+// MaxMessageSize is the largest message ParseFCall reads
+const MaxMessageSize = 1 << 20
+
+// smallest message there is: size[4] type[1] tag[2]
+const minMessageSize = 7
+
 var ErrBufferTooShort = errors.New("buffer does not contain all the bytes needed to parse this element")
+var ErrMessageSize = errors.New("message size out of bounds")
 
 func NewErrBufferTooShort(wanted, got int) error {
 	return fmt.Errorf("wanted: %d, got: %d: %w", wanted, got, ErrBufferTooShort)
@@ -25,10 +39,21 @@ type FCall interface {
 	fillFrom(r TypedReader) (FCall, error)
 	writeTo(w TypedWriter) error
 	Type() Type
+	MessageTag() Tag
 }
 type Type uint8
 type Tag uint16
 
+// MessageTag is promoted to every message, as they all embed their Tag
+func (t Tag) MessageTag() Tag {
+	return t
+}
+
+// SetMessageTag is promoted to every message, for whoever hands out the tags
+func (t *Tag) SetMessageTag(tag Tag) {
+	*t = tag
+}
+
 func (f Tag) String() string {
 	return fmt.Sprintf("%d", f)
 }
@@ -44,7 +69,7 @@ type Stat struct {
 	type_  uint16
 	dev    uint32
 	qid    Qid
-	mode   Mode
+	mode   Perm
 	atime  time.Time
 	mtime  time.Time
 	length uint64
@@ -54,11 +79,63 @@ type Stat struct {
 	muid   string
 }
 
+func NewStat(qid Qid, mode Perm, atime, mtime time.Time, length uint64, name, uid, gid, muid string) Stat {
+	return Stat{
+		qid:    qid,
+		mode:   mode,
+		atime:  atime,
+		mtime:  mtime,
+		length: length,
+		name:   name,
+		uid:    uid,
+		gid:    gid,
+		muid:   muid,
+	}
+}
+
+func (s Stat) Qid() Qid         { return s.qid }
+func (s Stat) Mode() Perm       { return s.mode }
+func (s Stat) Atime() time.Time { return s.atime }
+func (s Stat) Mtime() time.Time { return s.mtime }
+func (s Stat) Length() uint64   { return s.length }
+func (s Stat) Name() string     { return s.name }
+func (s Stat) Uid() string      { return s.uid }
+func (s Stat) Gid() string      { return s.gid }
+func (s Stat) Muid() string     { return s.muid }
+func (s Stat) IsDir() bool      { return s.mode&DMDIR != 0 }
+
+// DontTouch is a stat for Twstat that leaves the file alone. The With methods pick what to change
+var DontTouch = Stat{
+	type_:  ^uint16(0),
+	dev:    ^uint32(0),
+	qid:    Qid{Qtype: ^QType(0), Vers: ^uint32(0), Uid: ^uint64(0)},
+	mode:   ^Perm(0),
+	atime:  dontTouchTime,
+	mtime:  dontTouchTime,
+	length: ^uint64(0),
+}
+
+var dontTouchTime = time.Unix(int64(^uint32(0)), 0)
+
+func (s Stat) WithName(name string) Stat      { s.name = name; return s }
+func (s Stat) WithMode(mode Perm) Stat        { s.mode = mode; return s }
+func (s Stat) WithLength(length uint64) Stat  { s.length = length; return s }
+func (s Stat) WithMtime(mtime time.Time) Stat { s.mtime = mtime; return s }
+
+// The Sets methods tell which fields of a Twstat stat are meant to change
+func (s Stat) SetsName() bool   { return s.name != "" }
+func (s Stat) SetsMode() bool   { return s.mode != ^Perm(0) }
+func (s Stat) SetsLength() bool { return s.length != ^uint64(0) }
+func (s Stat) SetsMtime() bool  { return !s.mtime.Equal(dontTouchTime) }
+func (s Stat) SetsAtime() bool  { return !s.atime.Equal(dontTouchTime) }
+func (s Stat) SetsUid() bool    { return s.uid != "" }
+func (s Stat) SetsGid() bool    { return s.gid != "" }
+
 func (s Stat) String() string {
-	return fmt.Sprintf("Stat{type:%v dev:%v qid:%v mode:%v atime:%v mtime:%v length:%d name:%s uid:%s gid:%s muid:%s}", s.type_, s.dev, s.qid, s.mode, s.atime, s.mode, s.length, s.name, s.uid, s.gid, s.muid)
+	return fmt.Sprintf("Stat{type:%v dev:%v qid:%v mode:%v atime:%v mtime:%v length:%d name:%s uid:%s gid:%s muid:%s}", s.type_, s.dev, s.qid, s.mode, s.atime, s.mtime, s.length, s.name, s.uid, s.gid, s.muid)
 }
 
-const NOTAG Tag = 0
+const NOTAG Tag = Tag(^uint16(0))
 const NOFID Fid = Fid(^uint32(0))
 
 var (
@@ -123,6 +200,7 @@ const (
 func (t Type) String() string {
 	switch t {
 	case Rattach:
+		return "Rattach"
 	case Rauth:
 		return "Rauth"
 	case Rclunk:
@@ -303,7 +381,7 @@ type RRemove struct {
 }
 
 func (t *RRemove) Type() Type {
-	return Tremove
+	return Rremove
 }
 
 // http://9p.io/magic/man2html/5/open
@@ -315,12 +393,12 @@ type TCreate struct {
 	Tag
 	Fid
 	Name string
-	perm Perm
+	Perm Perm
 	Mode
 }
 
 func (ts *TCreate) String() string {
-	return fmt.Sprintf("%v tag:%v fid:%v name:%s perm:%v mode:%v", ts.Type(), ts.Tag, ts.Fid, ts.Name, ts.perm, ts.Mode)
+	return fmt.Sprintf("%v tag:%v fid:%v name:%s perm:%v mode:%v", ts.Type(), ts.Tag, ts.Fid, ts.Name, ts.Perm, ts.Mode)
 }
 
 func (t *TCreate) Type() Type {
@@ -611,6 +689,15 @@ func (r *RWrite) Type() Type {
 
 type Perm uint32
 
+// the top bits of a Perm, mirrored in the QType of the file's qid
+const (
+	DMDIR    Perm = 0x80000000
+	DMAPPEND Perm = 0x40000000
+	DMEXCL   Perm = 0x20000000
+	DMAUTH   Perm = 0x08000000
+	DMTMP    Perm = 0x04000000
+)
+
 type Mode uint8
 
 const (
@@ -625,6 +712,15 @@ const (
 
 type QType uint8
 
+const (
+	QTDIR    QType = 0x80
+	QTAPPEND QType = 0x40
+	QTEXCL   QType = 0x20
+	QTAUTH   QType = 0x08
+	QTTMP    QType = 0x04
+	QTFILE   QType = 0x00
+)
+
 // https://9fans.github.io/plan9port/man/man9/intro.html
 type Qid struct {
 	Qtype QType
@@ -24,7 +24,7 @@ func (tv *RStat) writeTo(tw TypedWriter) error {
 	if err != nil {
 		return err
 	}
-	err = tw.WriteStat(tv.Stat)
+	err = tw.WriteCountedStat(tv.Stat)
 	if err != nil {
 		return err
 	}
@@ -43,7 +43,7 @@ func (tv *TWStat) writeTo(tw TypedWriter) error {
 	if err != nil {
 		return err
 	}
-	err = tw.WriteStat(tv.Stat)
+	err = tw.WriteCountedStat(tv.Stat)
 	if err != nil {
 		return err
 	}
@@ -132,7 +132,7 @@ func (tv *TCreate) writeTo(tw TypedWriter) error {
 	if err != nil {
 		return err
 	}
-	err = tw.Write32(uint32(tv.perm))
+	err = tw.Write32(uint32(tv.Perm))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// ErrorNamer is implemented by servers that choose the ename sent for the errors their handlers return.
+// Servers that do not implement it get Ename
+type ErrorNamer interface {
+	Ename(err error) string
+}
+
+// errorNames are the enames of errors a client can do something about, checked in order
+var errorNames = []struct {
+	err   error
+	ename string
+}{
+	{fs.ErrNotExist, "file does not exist"},
+	{fs.ErrExist, "file already exists"},
+	{fs.ErrPermission, "permission denied"},
+	{fs.ErrClosed, "file already closed"},
+	{fs.ErrInvalid, "invalid argument"},
+	{syscall.ENOTEMPTY, "directory not empty"},
+	{syscall.ENOTDIR, "not a directory"},
+	{syscall.EISDIR, "is a directory"},
+	{syscall.ENOSPC, "no space left on device"},
+	{syscall.EROFS, "read-only file system"},
+	{context.Canceled, "interrupted"},
+	{context.DeadlineExceeded, "timed out"},
+}
+
+// Ename names err for an Rerror. Common os and io/fs errors get the same name whatever the
+// system says, and paths on the server are never sent to the client
+func Ename(err error) string {
+	for _, known := range errorNames {
+		if errors.Is(err, known.err) {
+			return known.ename
+		}
+	}
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	var sysErr *os.SyscallError
+	switch {
+	case errors.As(err, &pathErr):
+		err = pathErr.Err
+	case errors.As(err, &linkErr):
+		err = linkErr.Err
+	case errors.As(err, &sysErr):
+		err = sysErr.Err
+	}
+	return err.Error()
+}
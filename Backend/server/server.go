@@ -2,14 +2,22 @@ package server
 
 import (
 	"PumiceBackend/proto9p"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"runtime/debug"
 	"sync"
 )
 
-var ErrServerReceivedRMessage = errors.New("server received R message")
+var (
+	ErrServerReceivedRMessage = errors.New("server received R message")
+	ErrTagInUse               = errors.New("tag already in use")
+	ErrNoReply                = errors.New("handler gave no reply")
+	// sent in place of a panic, whose value is only logged as it may show internal state
+	ErrPanicked = errors.New("internal server error")
+)
 
 func NewErrServerRMessage(fc proto9p.FCall) error {
 	return fmt.Errorf("got %v (type %T): %w", fc, fc, ErrServerReceivedRMessage)
@@ -19,29 +27,32 @@ type Conn interface {
 	Username() string
 	SetUsername(string)
 	FidInUse(f proto9p.Fid) bool
+	// MSize is the largest message the connection takes, header included
+	MSize() uint32
+	// Close releases everything the session holds. It is called once the connection is gone
+	Close()
 }
 
+// Server answers the requests of a connection. Requests are handled concurrently,
+// so every method may be called while others are still running on the same Conn.
+// ctx is cancelled when the request is flushed or the connection goes away
 type Server interface {
 	NewConnection() Conn
-	Version(Conn, *proto9p.TVersion) (proto9p.FCall, error)
-	Auth(Conn, *proto9p.TAuth) (proto9p.FCall, error)
-	Attach(Conn, *proto9p.TAttach) (proto9p.FCall, error)
-	Walk(Conn, *proto9p.TWalk) (proto9p.FCall, error)
-	Open(Conn, *proto9p.TOpen) (proto9p.FCall, error)
-	Create(Conn, *proto9p.TCreate) (proto9p.FCall, error)
-	Flush(Conn, *proto9p.TFlush) (proto9p.FCall, error)
-	Read(Conn, *proto9p.TRead) (proto9p.FCall, error)
-	Write(Conn, *proto9p.TWrite) (proto9p.FCall, error)
-	Clunk(Conn, *proto9p.TClunk) (proto9p.FCall, error)
-	Remove(Conn, *proto9p.TRemove) (proto9p.FCall, error)
-	Stat(Conn, *proto9p.TStat) (proto9p.FCall, error)
-	Wstat(Conn, *proto9p.TWStat) (proto9p.FCall, error)
+	Version(context.Context, Conn, *proto9p.TVersion) (proto9p.FCall, error)
+	Auth(context.Context, Conn, *proto9p.TAuth) (proto9p.FCall, error)
+	Attach(context.Context, Conn, *proto9p.TAttach) (proto9p.FCall, error)
+	Walk(context.Context, Conn, *proto9p.TWalk) (proto9p.FCall, error)
+	Open(context.Context, Conn, *proto9p.TOpen) (proto9p.FCall, error)
+	Create(context.Context, Conn, *proto9p.TCreate) (proto9p.FCall, error)
+	Read(context.Context, Conn, *proto9p.TRead) (proto9p.FCall, error)
+	Write(context.Context, Conn, *proto9p.TWrite) (proto9p.FCall, error)
+	Clunk(context.Context, Conn, *proto9p.TClunk) (proto9p.FCall, error)
+	Remove(context.Context, Conn, *proto9p.TRemove) (proto9p.FCall, error)
+	Stat(context.Context, Conn, *proto9p.TStat) (proto9p.FCall, error)
+	Wstat(context.Context, Conn, *proto9p.TWStat) (proto9p.FCall, error)
 }
 
-func writeToWire(w io.Writer, toWire chan proto9p.FCall, wg *sync.WaitGroup) {
-	defer wg.Done()
-	defer close(toWire)
-
+func writeToWire(w io.Writer, toWire chan proto9p.FCall) {
 	for call := range toWire {
 		slog.Debug("Writing fcall to wire", "fcall", call)
 
@@ -62,80 +73,217 @@ func writeToWire(w io.Writer, toWire chan proto9p.FCall, wg *sync.WaitGroup) {
 	}
 }
 
-func takeCalls(fromWire, toWire chan proto9p.FCall, server Server, conn Conn, wg *sync.WaitGroup) {
+// request is a request that has not been answered yet
+type request struct {
+	cancel context.CancelFunc
+	// the answer is dropped once the request is flushed
+	flushed bool
+	// closed once the request is answered or dropped
+	done chan struct{}
+}
 
-	defer wg.Done()
-
-	for call := range fromWire {
-		call, err := handleFCall(call, server, conn)
-		if err != nil {
-			slog.Error("Error handling fcall", "err", err)
-			continue
-		}
-		slog.Debug("Received from wire", "fcall", call)
-		toWire <- call
-	}
+// session dispatches the requests of one connection
+type session struct {
+	server Server
+	conn   Conn
+	toWire chan proto9p.FCall
 
+	lock     sync.Mutex
+	inFlight map[proto9p.Tag]*request
+	handling sync.WaitGroup
 }
 
 // Serve a single channel of data
 // this may be one of many channels of data if server can handle multiple requests
-// the server needs to ne handle the multiple clients (identified by Conn) if is is used in this way
+// the server needs to ne handle the multiple clients (identified by Conn) if is is used in this way.
+// Serve returns once r is done, nil if it ended cleanly, after the session has been closed.
+//
+// Every request but Tversion and Tflush is handled in its own goroutine, and answers
+// are sent as they are ready, not in the order the requests came in. Tversion waits for
+// every outstanding request to be flushed before it is handled. Once Rflush is sent,
+// nothing more is sent for the flushed tag, and everything sent for it before went first
 func Serve(r io.Reader, w io.Writer, server Server) error {
-	readChan := make(chan proto9p.FCall, 10)
-	writeChan := make(chan proto9p.FCall, 10)
-	conn := server.NewConnection()
-	// reading thread
-	var wg sync.WaitGroup
-	wg.Add(2)
-	defer func() { wg.Wait() }()
-
-	go takeCalls(readChan, writeChan, server, conn, &wg)
-	go writeToWire(w, writeChan, &wg)
-
-	defer close(readChan)
+	s := &session{
+		server:   server,
+		conn:     server.NewConnection(),
+		toWire:   make(chan proto9p.FCall, 10),
+		inFlight: map[proto9p.Tag]*request{},
+	}
+	written := make(chan struct{})
+	go func() {
+		writeToWire(w, s.toWire)
+		close(written)
+	}()
+	defer func() {
+		// nobody is left to answer
+		s.flushAll()
+		close(s.toWire)
+		<-written
+		s.conn.Close()
+	}()
 
 	for {
-		call, err := proto9p.ParseFCall(r)
-		if err != nil {
+		// a message too big for the session ends it, the rest of it is never read
+		call, err := proto9p.ParseFCallMax(r, s.conn.MSize())
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
 			slog.Error("Reading fcall", "err", err)
 			return err
 		}
 		slog.Debug("read call", "type", call.Type(), "call", call.String())
-		readChan <- call
+		s.dispatch(call)
+	}
+}
+
+func (s *session) dispatch(call proto9p.FCall) {
+	switch call := call.(type) {
+	case *proto9p.TVersion:
+		// a new session starts without any of the old requests
+		s.flushAll()
+		s.answer(context.Background(), call.Tag, call)
+	case *proto9p.TFlush:
+		s.flush(call)
+	default:
+		tag, isT := tagOf(call)
+		if !isT {
+			slog.Error("Error handling fcall", "err", NewErrServerRMessage(call))
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		req := &request{cancel: cancel, done: make(chan struct{})}
+		s.lock.Lock()
+		_, inUse := s.inFlight[tag]
+		if !inUse {
+			s.inFlight[tag] = req
+			s.handling.Add(1)
+		}
+		s.lock.Unlock()
+		if inUse {
+			cancel()
+			slog.Warn("Request reused an outstanding tag", "fcall", call)
+			s.toWire <- &proto9p.RError{Tag: tag, Ename: ErrTagInUse.Error()}
+			return
+		}
+		go func() {
+			defer s.handling.Done()
+			s.handle(ctx, tag, req, call)
+		}()
 	}
+}
+
+// handle answers call, unless it is flushed first
+func (s *session) handle(ctx context.Context, tag proto9p.Tag, req *request, call proto9p.FCall) {
+	reply := s.reply(ctx, tag, call)
+	req.cancel()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.inFlight, tag)
+	defer close(req.done)
+	if req.flushed {
+		return
+	}
+	// sent under the lock, so a flush cannot get its Rflush in first
+	s.toWire <- reply
+}
 
+// answer handles call right away, in the reading goroutine
+func (s *session) answer(ctx context.Context, tag proto9p.Tag, call proto9p.FCall) {
+	s.toWire <- s.reply(ctx, tag, call)
+}
+
+// reply is the answer to call. Errors and panics of the handler become an Rerror
+func (s *session) reply(ctx context.Context, tag proto9p.Tag, call proto9p.FCall) (reply proto9p.FCall) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Handler panicked", "fcall", call, "panic", r, "stack", string(debug.Stack()))
+			reply = &proto9p.RError{Tag: tag, Ename: ErrPanicked.Error()}
+		}
+	}()
+	reply, err := handleFCall(ctx, call, s.server, s.conn)
+	if err == nil && reply == nil {
+		err = ErrNoReply
+	}
+	if err != nil {
+		slog.Error("Request failed", "fcall", call, "err", err)
+		ename := Ename
+		if namer, ok := s.server.(ErrorNamer); ok {
+			ename = namer.Ename
+		}
+		return &proto9p.RError{Tag: tag, Ename: ename(err)}
+	}
+	return reply
 }
 
-func handleFCall(call proto9p.FCall, srv Server, conn Conn) (proto9p.FCall, error) {
+// http://9p.io/magic/man2html/5/flush
+//
+// The flushed request is cancelled and its answer dropped. Rflush is sent once it has
+// stopped, so the client can reuse the tag as soon as it sees the Rflush
+func (s *session) flush(call *proto9p.TFlush) {
+	s.lock.Lock()
+	req, exists := s.inFlight[call.Oldtag]
+	if exists {
+		req.flushed = true
+		req.cancel()
+	}
+	s.lock.Unlock()
+	if !exists {
+		// already answered, or never asked
+		s.toWire <- &proto9p.RFlush{Tag: call.Tag}
+		return
+	}
+	s.handling.Add(1)
+	go func() {
+		defer s.handling.Done()
+		<-req.done
+		s.toWire <- &proto9p.RFlush{Tag: call.Tag}
+	}()
+}
+
+// flushAll flushes every outstanding request and waits for them to stop
+func (s *session) flushAll() {
+	s.lock.Lock()
+	for _, req := range s.inFlight {
+		req.flushed = true
+		req.cancel()
+	}
+	s.lock.Unlock()
+	s.handling.Wait()
+}
+
+// tagOf is the tag of a T-message. T-messages have even types, their answers the odd one after
+func tagOf(call proto9p.FCall) (proto9p.Tag, bool) {
+	return call.MessageTag(), call.Type()%2 == 0
+}
+
+func handleFCall(ctx context.Context, call proto9p.FCall, srv Server, conn Conn) (proto9p.FCall, error) {
 	switch conc := call.(type) {
 
 	case *proto9p.TAttach:
-		return srv.Attach(conn, conc)
+		return srv.Attach(ctx, conn, conc)
 	case *proto9p.TAuth:
-		return srv.Auth(conn, conc)
+		return srv.Auth(ctx, conn, conc)
 	case *proto9p.TClunk:
-		return srv.Clunk(conn, conc)
+		return srv.Clunk(ctx, conn, conc)
 	case *proto9p.TCreate:
-		return srv.Create(conn, conc)
-	case *proto9p.TFlush:
-		return srv.Flush(conn, conc)
+		return srv.Create(ctx, conn, conc)
 	case *proto9p.TOpen:
-		return srv.Open(conn, conc)
+		return srv.Open(ctx, conn, conc)
 	case *proto9p.TRead:
-		return srv.Read(conn, conc)
+		return srv.Read(ctx, conn, conc)
 	case *proto9p.TRemove:
-		return srv.Remove(conn, conc)
+		return srv.Remove(ctx, conn, conc)
 	case *proto9p.TStat:
-		return srv.Stat(conn, conc)
+		return srv.Stat(ctx, conn, conc)
 	case *proto9p.TVersion:
-		return srv.Version(conn, conc)
+		return srv.Version(ctx, conn, conc)
 	case *proto9p.TWStat:
-		return srv.Wstat(conn, conc)
+		return srv.Wstat(ctx, conn, conc)
 	case *proto9p.TWalk:
-		return srv.Walk(conn, conc)
+		return srv.Walk(ctx, conn, conc)
 	case *proto9p.TWrite:
-		return srv.Write(conn, conc)
+		return srv.Write(ctx, conn, conc)
 	default:
 		return nil, NewErrServerRMessage(conc)
 	}
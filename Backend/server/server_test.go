@@ -0,0 +1,176 @@
+package server_test
+
+import (
+	"PumiceBackend/proto9p"
+	"PumiceBackend/proto9p/fs"
+	"PumiceBackend/server"
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// stallingFS never answers a read until it is flushed
+type stallingFS struct {
+	*fs.FS
+	reading chan struct{}
+}
+
+func (s stallingFS) Read(ctx context.Context, c server.Conn, p *proto9p.TRead) (proto9p.FCall, error) {
+	s.reading <- struct{}{}
+	<-ctx.Done()
+	return &proto9p.RError{Tag: p.Tag, Ename: ctx.Err().Error()}, nil
+}
+
+func serveStalling(t *testing.T) (net.Conn, chan struct{}) {
+	t.Helper()
+	files, err := fs.NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := stallingFS{FS: &files, reading: make(chan struct{})}
+	return serveAttached(t, srv), srv.reading
+}
+
+// serveAttached serves srv and attaches to it as fid 0
+func serveAttached(t *testing.T, srv server.Server) net.Conn {
+	t.Helper()
+	client, conn := net.Pipe()
+	go server.Serve(conn, conn, srv)
+	t.Cleanup(func() { client.Close() })
+
+	send(t, client, &proto9p.TVersion{Tag: proto9p.NOTAG, MSize: 8192, Version: fs.Version})
+	receive(t, client)
+	send(t, client, &proto9p.TAttach{Tag: 1, Fid: 0, Afid: proto9p.NOFID, Uname: "glenda"})
+	receive(t, client)
+	return client
+}
+
+func send(t *testing.T, conn net.Conn, fc proto9p.FCall) {
+	t.Helper()
+	bs, err := proto9p.WriteFCall(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(bs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(t *testing.T, conn net.Conn) proto9p.FCall {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	fc, err := proto9p.ParseFCall(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fc
+}
+
+func TestFlush(t *testing.T) {
+	conn, reading := serveStalling(t)
+
+	send(t, conn, &proto9p.TRead{Tag: 1, Fid: 0, Count: 100})
+	<-reading
+
+	// a stalled request holds up nothing else
+	send(t, conn, &proto9p.TStat{Tag: 2, Fid: 0})
+	if st, isStat := receive(t, conn).(*proto9p.RStat); !isStat || st.Tag != 2 {
+		t.Fatalf("got %v, want the Rstat", st)
+	}
+
+	send(t, conn, &proto9p.TFlush{Tag: 3, Oldtag: 1})
+	if fc, isFlush := receive(t, conn).(*proto9p.RFlush); !isFlush || fc.Tag != 3 {
+		t.Fatalf("got %v, want the Rflush", fc)
+	}
+
+	// nothing comes for the flushed tag, so the next answer is to the reused tag
+	send(t, conn, &proto9p.TStat{Tag: 1, Fid: 0})
+	if st, isStat := receive(t, conn).(*proto9p.RStat); !isStat || st.Tag != 1 {
+		t.Fatalf("got %v, want the Rstat", st)
+	}
+
+	// flushing what was already answered is answered right away
+	send(t, conn, &proto9p.TFlush{Tag: 2, Oldtag: 1})
+	if fc, isFlush := receive(t, conn).(*proto9p.RFlush); !isFlush || fc.Tag != 2 {
+		t.Fatalf("got %v, want the Rflush", fc)
+	}
+}
+
+func TestDuplicateTag(t *testing.T) {
+	conn, reading := serveStalling(t)
+
+	send(t, conn, &proto9p.TRead{Tag: 1, Fid: 0, Count: 100})
+	<-reading
+	send(t, conn, &proto9p.TStat{Tag: 1, Fid: 0})
+	if fc, isErr := receive(t, conn).(*proto9p.RError); !isErr || fc.Ename != server.ErrTagInUse.Error() {
+		t.Fatalf("got %v, want the tag refused", fc)
+	}
+
+	// version flushes everything outstanding
+	send(t, conn, &proto9p.TVersion{Tag: proto9p.NOTAG, MSize: 8192, Version: fs.Version})
+	if fc, isVersion := receive(t, conn).(*proto9p.RVersion); !isVersion {
+		t.Fatalf("got %v, want the Rversion", fc)
+	}
+}
+
+// brokenFS fails in the ways a buggy server might
+type brokenFS struct {
+	*fs.FS
+}
+
+func (brokenFS) Stat(ctx context.Context, c server.Conn, p *proto9p.TStat) (proto9p.FCall, error) {
+	return nil, &os.PathError{Op: "stat", Path: "/srv/secret/path", Err: os.ErrNotExist}
+}
+
+func (brokenFS) Clunk(ctx context.Context, c server.Conn, p *proto9p.TClunk) (proto9p.FCall, error) {
+	return nil, nil
+}
+
+func (brokenFS) Read(ctx context.Context, c server.Conn, p *proto9p.TRead) (proto9p.FCall, error) {
+	panic("not done yet")
+}
+
+// namingFS names its own errors
+type namingFS struct {
+	brokenFS
+}
+
+func (namingFS) Ename(err error) string {
+	return "no"
+}
+
+func TestAlwaysAnswer(t *testing.T) {
+	files, err := fs.NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := serveAttached(t, brokenFS{&files})
+
+	for _, c := range []struct {
+		call  proto9p.FCall
+		ename string
+	}{
+		{&proto9p.TStat{Tag: 1, Fid: 0}, "file does not exist"},
+		{&proto9p.TClunk{Tag: 2, Fid: 0}, server.ErrNoReply.Error()},
+		{&proto9p.TRead{Tag: 3, Fid: 0}, server.ErrPanicked.Error()},
+	} {
+		send(t, conn, c.call)
+		fc, isErr := receive(t, conn).(*proto9p.RError)
+		if !isErr || fc.Ename != c.ename {
+			t.Fatalf("%v: got %v, want the error %q", c.call, fc, c.ename)
+		}
+	}
+	// still serving after the panic
+	send(t, conn, &proto9p.TWalk{Tag: 4, Fid: 0, NewFid: 1})
+	if fc, isWalk := receive(t, conn).(*proto9p.RWalk); !isWalk || fc.Tag != 4 {
+		t.Fatalf("got %v, want the Rwalk", fc)
+	}
+
+	conn = serveAttached(t, namingFS{brokenFS{&files}})
+	send(t, conn, &proto9p.TStat{Tag: 1, Fid: 0})
+	if fc, isErr := receive(t, conn).(*proto9p.RError); !isErr || fc.Ename != "no" {
+		t.Fatalf("got %v, want the error named by the server", fc)
+	}
+}